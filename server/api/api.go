@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"yacoid_server/common"
 	"yacoid_server/constants"
 	"yacoid_server/database"
+	"yacoid_server/mail"
+	"yacoid_server/searchquery"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
@@ -19,31 +22,57 @@ func StartAPI() {
 
 	fmt.Println("Starting server...")
 
-	app := fiber.New()
+	app := createApp(GetAliasVersion())
+
+	fmt.Println("Started server on port " + os.Getenv(constants.EnvKeyRestPort))
+
+	app.Listen(":" + os.Getenv(constants.EnvKeyRestPort))
 
-	api := app.Group("/api")
+}
+
+/*
+createApp registers the routes of every API version and the bare /api alias of the given version.
+*/
+func createApp(aliasVersion *ApiVersion) *fiber.App {
+
+	app := fiber.New()
 
 	validate := validator.New()
 
-	definitionApi := api.Group("/definitions")
-	AddDefinitionRequests(&definitionApi, validate)
+	app.Use(bearerTokenMiddleware)
 
-	authorApi := api.Group("/authors")
-	AddAuthorsRequests(&authorApi, validate)
+	for _, version := range ApiVersions {
+		prefix := apiPrefix + "/" + version.Name
+		AddVersionRequests(app.Group(prefix), prefix, version, validate)
+	}
 
-	sourceApi := api.Group("/sources")
-	AddSourcesRequests(&sourceApi, validate)
+	AddVersionRequests(app.Group(apiPrefix), apiPrefix, aliasVersion, validate)
 
-	authApi := api.Group("/auth")
-	AddAuthRequests(&authApi, validate)
+	return app
 
-	userApi := api.Group("/user")
-	AddAuthRequests(&userApi, validate)
+}
 
-	fmt.Println("Started server on port " + os.Getenv(constants.EnvKeyRestPort))
+/*
+bearerTokenMiddleware passes an access token sent as "Authorization: Bearer <token>" on as the Authtoken header,
+which is where all handlers read the token from.
+*/
+func bearerTokenMiddleware(ctx *fiber.Ctx) error {
 
-	app.Listen(":" + os.Getenv(constants.EnvKeyRestPort))
+	authorization := ctx.Get(fiber.HeaderAuthorization)
+
+	if len(ctx.Get("Authtoken")) == 0 && len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		ctx.Request().Header.Set("Authtoken", strings.TrimSpace(authorization[7:]))
+	}
 
+	return ctx.Next()
+
+}
+
+/*
+GetAuditClient returns the IP and user agent of the request for the audit log.
+*/
+func GetAuditClient(ctx *fiber.Ctx) *database.AuditClient {
+	return &database.AuditClient{IP: ctx.IP(), UserAgent: ctx.Get(fiber.HeaderUserAgent)}
 }
 
 func GetOptionalIntParam(stringValue string, defaultValue int) int {
@@ -107,4 +136,46 @@ func InitErrorCodeMap() {
 	ErrorCodeMap[database.ErrorDefinitionRejectionBelongsToAnotherUser] = fiber.StatusUnauthorized
 	ErrorCodeMap[database.ErrorDefinitionRejectionNotAnsweredYet] = fiber.StatusBadRequest
 
+	ErrorCodeMap[common.ErrorInvalidISBN] = fiber.StatusBadRequest
+	ErrorCodeMap[common.ErrorInvalidDOI] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorSourceAlreadyExists] = fiber.StatusConflict
+	ErrorCodeMap[database.ErrorAuthorMergeIntoItself] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorDeletionBlocked] = fiber.StatusConflict
+	ErrorCodeMap[common.ErrorInvalidORCID] = fiber.StatusBadRequest
+	ErrorCodeMap[common.ErrorInvalidWikidataID] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorAuthorAlreadyExists] = fiber.StatusConflict
+	ErrorCodeMap[database.ErrorAuthorSlugUnavailable] = fiber.StatusConflict
+	ErrorCodeMap[database.ErrorInvalidLifeDates] = fiber.StatusBadRequest
+
+	ErrorCodeMap[database.ErrorAuthorNotApproved] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorAuthorAlreadyApproved] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorSourceNotApproved] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorSourceAlreadyApproved] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorRejectionNotAnsweredYet] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorDefinitionHasPendingDependencies] = fiber.StatusConflict
+	ErrorCodeMap[database.ErrorInvalidExpand] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorInvalidSearchKind] = fiber.StatusBadRequest
+	ErrorCodeMap[searchquery.ErrorInvalidSearchQuery] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorSavedSearchNotFound] = fiber.StatusNotFound
+	ErrorCodeMap[database.ErrorTooManySavedSearches] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorInvalidFeedFormat] = fiber.StatusNotFound
+	ErrorCodeMap[database.ErrorWebhookNotFound] = fiber.StatusNotFound
+	ErrorCodeMap[database.ErrorWebhookDeliveryNotFound] = fiber.StatusNotFound
+	ErrorCodeMap[database.ErrorInvalidWebhookEvent] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorInvalidWebhookURL] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorWebhookInactive] = fiber.StatusConflict
+	ErrorCodeMap[database.ErrorInvalidEmailKind] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorOutboxMailNotFound] = fiber.StatusNotFound
+	ErrorCodeMap[database.ErrorInvalidEmailVerificationToken] = fiber.StatusNotFound
+	ErrorCodeMap[database.ErrorEmailVerificationExpiryDateExceeded] = fiber.StatusBadRequest
+	ErrorCodeMap[database.ErrorEmailAlreadyVerified] = fiber.StatusConflict
+	ErrorCodeMap[database.ErrorEmailAlreadyTaken] = fiber.StatusConflict
+	ErrorCodeMap[database.ErrorEmailNotVerified] = fiber.StatusForbidden
+	ErrorCodeMap[database.ErrorLegacyLoginRetired] = fiber.StatusGone
+	ErrorCodeMap[database.ErrorSessionNotFound] = fiber.StatusNotFound
+	ErrorCodeMap[database.ErrorInvalidRefreshToken] = fiber.StatusUnauthorized
+	ErrorCodeMap[database.ErrorRefreshTokenReused] = fiber.StatusUnauthorized
+	ErrorCodeMap[mail.ErrorUnknownTemplate] = fiber.StatusNotFound
+	ErrorCodeMap[mail.ErrorUnsupportedLanguage] = fiber.StatusBadRequest
+
 }
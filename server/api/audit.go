@@ -0,0 +1,47 @@
+package api
+
+import (
+	"strings"
+	"yacoid_server/database"
+	"yacoid_server/types"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+AddAuditRequests registers the read-only access to the audit log. Entries are written by the audited operations only.
+*/
+func AddAuditRequests(auditApi *fiber.Router, validate *validator.Validate) {
+
+	(*auditApi).Post("/page", func(ctx *fiber.Ctx) error {
+
+		request := new(types.AuditLogRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		entries, err := database.GetAuditLog(request, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"entries": entries},
+		})
+
+	})
+
+}
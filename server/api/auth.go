@@ -1,16 +1,16 @@
 package api
 
 import (
-	"fmt"
 	"strings"
 	"yacoid_server/database"
+	"yacoid_server/types"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
-func AddAuthRequests(authApi *fiber.Router, validate *validator.Validate) {
+func AddAuthRequests(authApi *fiber.Router, version *ApiVersion, validate *validator.Validate) {
 
 	(*authApi).Post("/register", func(ctx *fiber.Ctx) error {
 
@@ -32,42 +32,153 @@ func AddAuthRequests(authApi *fiber.Router, validate *validator.Validate) {
 		if err != nil {
 			return ctx.Status(fiber.StatusBadRequest).JSON(Response{Error: err.Error()})
 		}
+
+		/* Hide some attributes */
+		user.PasswordHash = ""
+
 		return ctx.JSON(Response{Data: user})
 
 	})
 
-	(*authApi).Get("/login/:email/:password", func(ctx *fiber.Ctx) error {
+	(*authApi).Post("/login", func(ctx *fiber.Ctx) error {
 
-		email := ctx.Params("email")
-		password := ctx.Params("password")
-		fmt.Println("Input", email, password)
-		user, err := database.Login(email, password)
-		fmt.Println(user, err)
-		if err != nil {
+		request := new(types.LoginRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
 			return ctx.Status(fiber.StatusBadRequest).JSON(Response{Error: err.Error()})
 		}
 
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		user, err := database.Login(request.Email, request.Password, GetAuditClient(ctx))
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
 		/* Hide some attributes */
 		user.PasswordSalt = ""
 		user.PasswordHash = ""
 
 		return ctx.JSON(Response{
-			Data: bson.M{"user: ": user},
+			Data: bson.M{"user": user},
 		})
 
 	})
 
-	(*authApi).Get("/password_salt/:email", func(ctx *fiber.Ctx) error {
+	(*authApi).Post("/token", func(ctx *fiber.Ctx) error {
+
+		request := new(types.LoginRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		user, tokens, err := database.LoginWithTokens(request.Email, request.Password, GetAuditClient(ctx))
 
-		email := ctx.Params("email")
-		fmt.Println("Input", email)
-		salt, err := database.GetPasswordSalt(email)
-		fmt.Println("SALT", salt, err)
 		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		/* Hide some attributes */
+		user.PasswordSalt = ""
+		user.PasswordHash = ""
+
+		return ctx.JSON(Response{
+			Data: bson.M{"user": user, "tokens": tokens},
+		})
+
+	})
+
+	(*authApi).Post("/refresh", func(ctx *fiber.Ctx) error {
+
+		request := new(types.RefreshTokenRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
 			return ctx.Status(fiber.StatusBadRequest).JSON(Response{Error: err.Error()})
 		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		tokens, err := database.RefreshTokens(request.RefreshToken, GetAuditClient(ctx))
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"tokens": tokens},
+		})
+
+	})
+
+	/* the salted challenge login only exists in v1, until all accounts were migrated by the login above */
+	if version.Name == "v1" {
+
+		(*authApi).Get("/login/:email/:password", func(ctx *fiber.Ctx) error {
+
+			user, err := database.LegacyLogin(ctx.Params("email"), ctx.Params("password"), GetAuditClient(ctx))
+
+			if err != nil {
+				return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+			}
+
+			/* Hide some attributes */
+			user.PasswordSalt = ""
+			user.PasswordHash = ""
+
+			return ctx.JSON(Response{
+				Data: bson.M{"user: ": user},
+			})
+
+		})
+
+		(*authApi).Get("/password_salt/:email", func(ctx *fiber.Ctx) error {
+
+			salt, err := database.GetPasswordSalt(ctx.Params("email"))
+
+			if err != nil {
+				return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+			}
+
+			return ctx.JSON(Response{
+				Data: bson.M{"passwordSalt": salt},
+			})
+
+		})
+	}
+
+	(*authApi).Get("/password_migration", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		legacy, total, err := database.GetPasswordMigrationStatus(authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
 		return ctx.JSON(Response{
-			Data: bson.M{"passwordSalt": salt},
+			Data: bson.M{"legacy": legacy, "total": total},
 		})
 
 	})
@@ -89,23 +200,37 @@ func AddAuthRequests(authApi *fiber.Router, validate *validator.Validate) {
 	(*authApi).Get("/request_password_reset/:email", func(ctx *fiber.Ctx) error {
 
 		email := ctx.Params("email")
-		token, err := database.InitiatePasswordReset(email)
+		err := database.InitiatePasswordReset(email, GetAuditClient(ctx))
 
 		if err != nil {
 			return ctx.Status(fiber.StatusBadRequest).JSON(Response{Error: err.Error()})
 		}
 
+		/* the token is only sent by mail, otherwise anyone knowing the address could reset the password */
 		return ctx.JSON(Response{
-			Data: bson.M{"token": token},
+			Message: "Successfully sent password reset email!",
 		})
 
 	})
 
-	(*authApi).Get("/reset_password/:token/:password_hash", func(ctx *fiber.Ctx) error {
+	(*authApi).Post("/reset_password", func(ctx *fiber.Ctx) error {
+
+		request := new(types.ResetPasswordRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		err := database.ResetPassword(request.Token, request.Password, GetAuditClient(ctx))
 
-		token := ctx.Params("token")
-		passwordHash := ctx.Params("password_hash")
-		err := database.ResetPassword(token, passwordHash)
 		if err != nil {
 			return ctx.Status(fiber.StatusBadRequest).JSON(Response{Error: err.Error()})
 		}
@@ -116,4 +241,34 @@ func AddAuthRequests(authApi *fiber.Router, validate *validator.Validate) {
 
 	})
 
+	(*authApi).Post("/verify_email/:token", func(ctx *fiber.Ctx) error {
+
+		user, err := database.VerifyEmail(ctx.Params("token"), GetAuditClient(ctx))
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully verified email!",
+			Data:    bson.M{"email": user.Email},
+		})
+
+	})
+
+	(*authApi).Post("/resend_verification", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		err := database.ResendEmailVerification(authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully sent verification email!",
+		})
+
+	})
+
 }
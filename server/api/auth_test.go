@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResetPasswordRequiresBody(t *testing.T) {
+
+	app := createApp(GetApiVersion(defaultAliasVersion))
+
+	tests := []struct {
+		method   string
+		path     string
+		body     string
+		expected int
+	}{
+		{"POST", "/api/v1/auth/reset_password", `{}`, 400},
+		{"POST", "/api/v1/auth/reset_password", `{"token": "00000000-0000-0000-0000-000000000000"}`, 400},
+		{"POST", "/api/v1/auth/reset_password", `{"password": "secret"}`, 400},
+		{"GET", "/api/v1/auth/reset_password/00000000-0000-0000-0000-000000000000/secret", ``, 404},
+	}
+
+	for _, test := range tests {
+
+		request := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		request.Header.Set("Content-Type", "application/json")
+
+		response, err := app.Test(request)
+
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", test.method, test.path, err)
+		}
+
+		if response.StatusCode != test.expected {
+			t.Errorf("%s %s with %s = %d, expected %d", test.method, test.path, test.body, response.StatusCode, test.expected)
+		}
+	}
+
+}
@@ -1,12 +1,14 @@
 package api
 
 import (
+	"net/url"
 	"strings"
 	"yacoid_server/database"
 	"yacoid_server/types"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 func AddAuthorsRequests(authorApi *fiber.Router, validate *validator.Validate) {
@@ -28,13 +30,289 @@ func AddAuthorsRequests(authorApi *fiber.Router, validate *validator.Validate) {
 		}
 
 		authToken := ctx.GetReqHeaders()["Authtoken"]
-		err := database.CreateAuthor(request, authToken)
+		author, err := database.CreateAuthor(request, authToken)
 		if err != nil {
 			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
 		}
 
 		return ctx.JSON(Response{
 			Message: "Successfully created author!",
+			Data:    bson.M{"author": author},
+		})
+	})
+
+	(*authorApi).Get("/author/:id", func(ctx *fiber.Ctx) error {
+
+		idOrSlug := ctx.Params("id")
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		author, err := database.GetAuthorByIdOrSlug(idOrSlug, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		/* slugs of merged authors redirect to the surviving author */
+		if idOrSlug != author.SlugId && idOrSlug != author.ID.Hex() {
+			return ctx.Redirect(strings.TrimSuffix(ctx.Path(), idOrSlug)+author.SlugId, fiber.StatusMovedPermanently)
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"author": author},
+		})
+
+	})
+
+	(*authorApi).Get("/lookup/:name", func(ctx *fiber.Ctx) error {
+
+		name, err := url.PathUnescape(ctx.Params("name"))
+
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{Error: err.Error()})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		authors, err := database.FindAuthorsByName(name, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"authors": authors},
+		})
+
+	})
+
+	(*authorApi).Post("/page", func(ctx *fiber.Ctx) error {
+
+		request := new(types.AuthorPageRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		authors, pageCount, err := database.GetAuthors(request.PageSize, request.Page, request.Search, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{
+				"authors":   authors,
+				"pageCount": pageCount,
+			},
+		})
+
+	})
+
+	(*authorApi).Post("/update", func(ctx *fiber.Ctx) error {
+
+		request := new(types.UpdateAuthorRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		author, err := database.UpdateAuthor(request, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully updated author!",
+			Data:    bson.M{"author": author},
+		})
+	})
+
+	(*authorApi).Post("/merge", func(ctx *fiber.Ctx) error {
+
+		request := new(types.MergeAuthorsRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		author, err := database.MergeAuthors(request.TargetID, request.AuthorIDs, authToken, GetAuditClient(ctx))
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully merged authors!",
+			Data:    bson.M{"author": author},
+		})
+	})
+
+	(*authorApi).Get("/profile/:slug", func(ctx *fiber.Ctx) error {
+
+		profile, err := database.GetAuthorProfile(ctx.Params("slug"))
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"profile": profile},
+		})
+
+	})
+
+	(*authorApi).Get("/graph", func(ctx *fiber.Ctx) error {
+
+		graph, err := database.GetCoAuthorGraph()
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"graph": graph},
+		})
+
+	})
+
+	(*authorApi).Get("/graph/graphml", func(ctx *fiber.Ctx) error {
+
+		graph, err := database.GetCoAuthorGraph()
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		graphML, err := graph.ToGraphML()
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		ctx.Set(fiber.HeaderContentType, "application/graphml+xml")
+		ctx.Set(fiber.HeaderContentDisposition, "attachment; filename=\"co_authors.graphml\"")
+		return ctx.Send(graphML)
+
+	})
+
+	(*authorApi).Post("/delete/:id", func(ctx *fiber.Ctx) error {
+
+		id := ctx.Params("id")
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		blockers, err := database.DeleteAuthor(id, authToken)
+
+		if err != nil {
+			if err == database.ErrorDeletionBlocked {
+				return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error(), Data: bson.M{"blockers": blockers}})
+			}
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully deleted author!",
+		})
+
+	})
+
+	(*authorApi).Get("/pending", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		authors, err := database.GetPendingAuthors(authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"authors": authors},
+		})
+
+	})
+
+	(*authorApi).Get("/rejections/:id", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		rejections, err := database.GetAuthorRejections(ctx.Params("id"), authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"rejections": rejections},
+		})
+
+	})
+
+	(*authorApi).Get("/approve/:id", func(ctx *fiber.Ctx) error {
+
+		authorId := ctx.Params("id")
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		err := database.ApproveAuthor(authorId, authToken, GetAuditClient(ctx))
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully approved author!",
+		})
+
+	})
+
+	(*authorApi).Post("/reject", func(ctx *fiber.Ctx) error {
+
+		request := new(types.RejectRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		err := database.RejectAuthor(request.ID, authToken, request.Content, GetAuditClient(ctx))
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully rejected author!",
 		})
 	})
 
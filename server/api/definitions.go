@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"yacoid_server/database"
+	"yacoid_server/searchquery"
 	"yacoid_server/types"
 
 	"github.com/go-playground/validator/v10"
@@ -17,7 +18,14 @@ func AddDefinitionRequests(definitionApi *fiber.Router, validate *validator.Vali
 
 		id := ctx.Params("id")
 
-		definition, err := database.GetDefinitionById(id)
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		expansion, err := database.ParseExpansion(ctx.Query("expand"), authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		definition, err := database.GetDefinitionById(id, expansion)
 
 		if err != nil {
 			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
@@ -60,8 +68,10 @@ func AddDefinitionRequests(definitionApi *fiber.Router, validate *validator.Vali
 
 		definitionId := ctx.Params("id")
 
+		approveDependencies := ctx.Query("dependencies") == "true"
+
 		authToken := ctx.GetReqHeaders()["Authtoken"]
-		err := database.ApproveDefinition(definitionId, authToken)
+		err := database.ApproveDefinition(definitionId, authToken, approveDependencies, GetAuditClient(ctx))
 
 		if err != nil {
 			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
@@ -90,7 +100,7 @@ func AddDefinitionRequests(definitionApi *fiber.Router, validate *validator.Vali
 		}
 
 		authToken := ctx.GetReqHeaders()["Authtoken"]
-		err := database.RejectDefinition(request.ID, authToken, request.Content)
+		err := database.RejectDefinition(request.ID, authToken, request.Content, GetAuditClient(ctx))
 		if err != nil {
 			return ctx.Status(fiber.StatusInternalServerError).JSON(Response{Error: err.Error()})
 		}
@@ -131,7 +141,14 @@ func AddDefinitionRequests(definitionApi *fiber.Router, validate *validator.Vali
 
 		limit := GetOptionalIntParam(ctx.Query("limit"), 4)
 
-		definitions, err := database.GetNewestDefinitions(limit)
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		expansion, err := database.ParseExpansion(ctx.Query("expand"), authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		definitions, err := database.GetNewestDefinitions(limit, expansion)
 
 		if err != nil {
 			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
@@ -179,7 +196,30 @@ func AddDefinitionRequests(definitionApi *fiber.Router, validate *validator.Vali
 			})
 		}
 
-		definitions, err := database.GetDefinitions(request.PageSize, request.Page, request.Filter, request.Sort)
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		expansion, err := database.ParseExpansion(ctx.Query("expand"), authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		filter := request.Filter
+
+		if request.Query != nil {
+
+			query, syntaxErrors := searchquery.Parse(*request.Query)
+
+			if len(syntaxErrors) > 0 {
+				return ctx.Status(GetErrorCode(searchquery.ErrorInvalidSearchQuery)).JSON(Response{
+					Error: searchquery.ErrorInvalidSearchQuery.Error(),
+					Data:  bson.M{"errors": syntaxErrors},
+				})
+			}
+
+			filter = query.ToFilter()
+		}
+
+		definitions, err := database.GetDefinitions(request.PageSize, request.Page, filter, request.Sort, request.Fuzzy, expansion)
 
 		if err != nil {
 			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
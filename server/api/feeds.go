@@ -0,0 +1,127 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"yacoid_server/database"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+func AddFeedRequests(feedApi *fiber.Router, validate *validator.Validate) {
+
+	(*feedApi).Get("/definitions/:format", func(ctx *fiber.Ctx) error {
+
+		feed, err := database.GetDefinitionsFeed()
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return sendFeed(ctx, feed, ctx.Params("format"))
+
+	})
+
+	(*feedApi).Get("/tags/:tag/:format", func(ctx *fiber.Ctx) error {
+
+		tag, err := url.PathUnescape(ctx.Params("tag"))
+
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{Error: err.Error()})
+		}
+
+		feed, err := database.GetTagFeed(tag)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return sendFeed(ctx, feed, ctx.Params("format"))
+
+	})
+
+	(*feedApi).Get("/authors/:slug/:format", func(ctx *fiber.Ctx) error {
+
+		feed, err := database.GetAuthorFeed(ctx.Params("slug"))
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return sendFeed(ctx, feed, ctx.Params("format"))
+
+	})
+
+}
+
+/*
+sendFeed renders the feed with an ETag of its content and the approval date of the newest entry as Last-Modified,
+and answers conditional requests of feed readers with 304 Not Modified.
+*/
+func sendFeed(ctx *fiber.Ctx, feed *database.Feed, format string) error {
+
+	selfLink := ctx.BaseURL() + ctx.OriginalURL()
+	output, contentType, err := feed.Render(format, selfLink)
+
+	if err != nil {
+		return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+	}
+
+	checksum := sha256.Sum256(output)
+	etag := "\"" + hex.EncodeToString(checksum[:16]) + "\""
+
+	ctx.Set(fiber.HeaderETag, etag)
+	ctx.Set(fiber.HeaderCacheControl, "public, max-age=300")
+
+	if !feed.Updated.IsZero() {
+		ctx.Set(fiber.HeaderLastModified, feed.Updated.UTC().Format(http.TimeFormat))
+	}
+
+	if isNotModified(ctx, etag, feed.Updated) {
+		return ctx.SendStatus(fiber.StatusNotModified)
+	}
+
+	ctx.Set(fiber.HeaderContentType, contentType)
+	return ctx.Send(output)
+
+}
+
+/*
+isNotModified evaluates If-None-Match and, only if it is missing, If-Modified-Since as described in RFC 9110.
+*/
+func isNotModified(ctx *fiber.Ctx, etag string, lastModified time.Time) bool {
+
+	if noneMatch := ctx.Get(fiber.HeaderIfNoneMatch); len(noneMatch) > 0 {
+
+		for _, candidate := range strings.Split(noneMatch, ",") {
+
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	modifiedSince := ctx.Get(fiber.HeaderIfModifiedSince)
+
+	if len(modifiedSince) == 0 || lastModified.IsZero() {
+		return false
+	}
+
+	modifiedSinceTime, err := http.ParseTime(modifiedSince)
+
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.Truncate(time.Second).After(modifiedSinceTime)
+
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"strings"
+	"yacoid_server/graphql"
+	"yacoid_server/types"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+func AddGraphQLRequests(graphqlApi *fiber.Router, validate *validator.Validate) {
+
+	schema := graphql.NewSchema()
+
+	(*graphqlApi).Post("/", func(ctx *fiber.Ctx) error {
+
+		request := new(types.GraphQLRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		context := graphql.NewContext(ctx.GetReqHeaders()["Authtoken"])
+		response := schema.Execute(context, request.Query, request.OperationName, request.Variables)
+
+		return ctx.JSON(response)
+	})
+
+}
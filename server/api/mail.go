@@ -0,0 +1,67 @@
+package api
+
+import (
+	"yacoid_server/database"
+	"yacoid_server/mail"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func AddMailRequests(mailApi *fiber.Router, validate *validator.Validate) {
+
+	(*mailApi).Get("/templates", func(ctx *fiber.Ctx) error {
+
+		return ctx.JSON(Response{
+			Data: bson.M{"templates": mail.TemplateNames, "languages": mail.Languages},
+		})
+
+	})
+
+	(*mailApi).Get("/preview/:template", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		message, err := database.PreviewMailTemplate(ctx.Params("template"), ctx.Query("language"), authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"subject": message.Subject, "html": message.HTMLBody, "text": message.TextBody},
+		})
+
+	})
+
+	(*mailApi).Get("/dead", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		mails, err := database.GetDeadMails(authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"mails": mails},
+		})
+
+	})
+
+	(*mailApi).Post("/retry/:id", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		err := database.RetryDeadMail(ctx.Params("id"), authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully queued mail again!",
+		})
+
+	})
+
+}
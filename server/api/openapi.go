@@ -0,0 +1,420 @@
+package api
+
+import (
+	_ "embed"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"yacoid_server/database"
+	"yacoid_server/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+/*
+RouteSpec documents a single route of the API. Paths are relative to the version prefix and use
+the Fiber syntax for path parameters, e.g. "/definitions/definition/:id".
+Routes without Versions are available in every API version.
+*/
+type RouteSpec struct {
+	Method      string
+	Path        string
+	Tag         string
+	Summary     string
+	Auth        bool
+	Request     interface{}
+	QueryParams []string
+	Versions    []string
+}
+
+func (route *RouteSpec) IsAvailableIn(version string) bool {
+	return len(route.Versions) == 0 || containsString(route.Versions, version)
+}
+
+var RouteSpecs = []*RouteSpec{
+	{Method: fiber.MethodGet, Path: "/openapi.json", Tag: "docs", Summary: "OpenAPI specification of this API"},
+	{Method: fiber.MethodGet, Path: "/docs", Tag: "docs", Summary: "Documentation UI"},
+
+	{Method: fiber.MethodGet, Path: "/definitions/definition/:id", Tag: "definitions", Summary: "Get an approved definition", QueryParams: []string{"expand"}},
+	{Method: fiber.MethodPost, Path: "/definitions/submit", Tag: "definitions", Summary: "Submit a definition", Auth: true, Request: types.SubmitDefinitionRequest{}},
+	{Method: fiber.MethodGet, Path: "/definitions/approve/:id", Tag: "definitions", Summary: "Approve a definition", Auth: true, QueryParams: []string{"dependencies"}},
+	{Method: fiber.MethodPost, Path: "/definitions/reject", Tag: "definitions", Summary: "Reject a definition", Auth: true, Request: types.RejectRequest{}},
+	{Method: fiber.MethodPost, Path: "/definitions/change", Tag: "definitions", Summary: "Change a rejected definition", Auth: true, Request: types.ChangeDefinitionRequest{}},
+	{Method: fiber.MethodGet, Path: "/definitions/newest_definitions", Tag: "definitions", Summary: "Get the newest approved definitions", QueryParams: []string{"limit", "expand"}},
+	{Method: fiber.MethodGet, Path: "/definitions/page_count", Tag: "definitions", Summary: "Get the number of definition pages", QueryParams: []string{"page_size"}},
+	{Method: fiber.MethodPost, Path: "/definitions/page", Tag: "definitions", Summary: "Get a page of approved definitions. With fuzzy, the title and content filters match titles, tags and author names", Request: types.DefinitionPageRequest{}, QueryParams: []string{"expand"}},
+
+	{Method: fiber.MethodPost, Path: "/authors/create", Tag: "authors", Summary: "Create an author", Auth: true, Request: types.CreateAuthorRequest{}},
+	{Method: fiber.MethodGet, Path: "/authors/author/:id", Tag: "authors", Summary: "Get an author by ID or slug", Auth: true},
+	{Method: fiber.MethodGet, Path: "/authors/lookup/:name", Tag: "authors", Summary: "Find authors by name", Auth: true},
+	{Method: fiber.MethodPost, Path: "/authors/page", Tag: "authors", Summary: "Get a page of authors", Auth: true, Request: types.AuthorPageRequest{}},
+	{Method: fiber.MethodPost, Path: "/authors/update", Tag: "authors", Summary: "Update an author", Auth: true, Request: types.UpdateAuthorRequest{}},
+	{Method: fiber.MethodPost, Path: "/authors/merge", Tag: "authors", Summary: "Merge duplicate authors", Auth: true, Request: types.MergeAuthorsRequest{}},
+	{Method: fiber.MethodGet, Path: "/authors/profile/:slug", Tag: "authors", Summary: "Get the profile of an approved author"},
+	{Method: fiber.MethodGet, Path: "/authors/graph", Tag: "authors", Summary: "Get the co-author graph as JSON"},
+	{Method: fiber.MethodGet, Path: "/authors/graph/graphml", Tag: "authors", Summary: "Get the co-author graph as GraphML"},
+	{Method: fiber.MethodPost, Path: "/authors/delete/:id", Tag: "authors", Summary: "Delete an unreferenced author", Auth: true},
+	{Method: fiber.MethodGet, Path: "/authors/pending", Tag: "authors", Summary: "Get authors waiting for approval", Auth: true},
+	{Method: fiber.MethodGet, Path: "/authors/rejections/:id", Tag: "authors", Summary: "Get the rejections of an author to its submitter or an admin", Auth: true},
+	{Method: fiber.MethodGet, Path: "/authors/approve/:id", Tag: "authors", Summary: "Approve an author", Auth: true},
+	{Method: fiber.MethodPost, Path: "/authors/reject", Tag: "authors", Summary: "Reject an author", Auth: true, Request: types.RejectRequest{}},
+
+	{Method: fiber.MethodPost, Path: "/sources/create", Tag: "sources", Summary: "Create a source", Auth: true, Request: types.CreateSourceRequest{}},
+	{Method: fiber.MethodGet, Path: "/sources/duplicates", Tag: "sources", Summary: "Find probable duplicate sources", Auth: true},
+	{Method: fiber.MethodPost, Path: "/sources/merge", Tag: "sources", Summary: "Merge duplicate sources", Auth: true, Request: types.MergeSourcesRequest{}},
+	{Method: fiber.MethodGet, Path: "/sources/source/:id", Tag: "sources", Summary: "Get a source", Auth: true},
+	{Method: fiber.MethodPost, Path: "/sources/page", Tag: "sources", Summary: "Get a page of sources", Auth: true, Request: types.SourcePageRequest{}},
+	{Method: fiber.MethodPost, Path: "/sources/update", Tag: "sources", Summary: "Update a source", Auth: true, Request: types.UpdateSourceRequest{}},
+	{Method: fiber.MethodPost, Path: "/sources/delete/:id", Tag: "sources", Summary: "Delete an unreferenced source", Auth: true},
+	{Method: fiber.MethodGet, Path: "/sources/pending", Tag: "sources", Summary: "Get sources waiting for approval", Auth: true},
+	{Method: fiber.MethodGet, Path: "/sources/rejections/:id", Tag: "sources", Summary: "Get the rejections of a source to its submitter or an admin", Auth: true},
+	{Method: fiber.MethodGet, Path: "/sources/approve/:id", Tag: "sources", Summary: "Approve a source", Auth: true},
+	{Method: fiber.MethodPost, Path: "/sources/reject", Tag: "sources", Summary: "Reject a source", Auth: true, Request: types.RejectRequest{}},
+
+	{Method: fiber.MethodPost, Path: "/auth/register", Tag: "auth", Summary: "Register a new user", Request: database.User{}},
+	{Method: fiber.MethodPost, Path: "/auth/login", Tag: "auth", Summary: "Log in and receive an auth token", Request: types.LoginRequest{}},
+	{Method: fiber.MethodPost, Path: "/auth/token", Tag: "auth", Summary: "Log in and receive a short-lived access token and a refresh token", Request: types.LoginRequest{}},
+	{Method: fiber.MethodPost, Path: "/auth/refresh", Tag: "auth", Summary: "Exchange a refresh token for a new token pair", Request: types.RefreshTokenRequest{}},
+	{Method: fiber.MethodGet, Path: "/auth/login/:email/:password", Tag: "auth", Summary: "Log in with the salted password challenge of accounts that were not migrated yet, if LEGACY_LOGIN_ENABLED is set", Versions: []string{"v1"}},
+	{Method: fiber.MethodGet, Path: "/auth/password_salt/:email", Tag: "auth", Summary: "Get the password salt of an account that was not migrated yet", Versions: []string{"v1"}},
+	{Method: fiber.MethodGet, Path: "/auth/password_migration", Tag: "auth", Summary: "Get the number of accounts that were not migrated to server-side password hashes", Auth: true},
+	{Method: fiber.MethodGet, Path: "/auth/logout", Tag: "auth", Summary: "Log out", Auth: true},
+	{Method: fiber.MethodGet, Path: "/auth/request_password_reset/:email", Tag: "auth", Summary: "Send a password reset email. The token is only sent by mail"},
+	{Method: fiber.MethodPost, Path: "/auth/reset_password", Tag: "auth", Summary: "Reset the password with a reset token", Request: types.ResetPasswordRequest{}},
+	{Method: fiber.MethodPost, Path: "/auth/verify_email/:token", Tag: "auth", Summary: "Verify an email address with a verification token"},
+	{Method: fiber.MethodPost, Path: "/auth/resend_verification", Tag: "auth", Summary: "Send the email verification again", Auth: true},
+
+	{Method: fiber.MethodPost, Path: "/user/delete_user", Tag: "user", Summary: "Delete the own account", Auth: true, Request: DeleteUserRequest{}},
+	{Method: fiber.MethodPost, Path: "/user/change_account_data", Tag: "user", Summary: "Change the own account data", Auth: true, Request: ChangeAccountDataRequest{}},
+	{Method: fiber.MethodGet, Path: "/user/email_preferences", Tag: "user", Summary: "Get the kinds of emails the user opted out of", Auth: true},
+	{Method: fiber.MethodPost, Path: "/user/email_preferences", Tag: "user", Summary: "Opt out of submission receipt, approval or rejection emails", Auth: true, Request: types.EmailPreferencesRequest{}},
+	{Method: fiber.MethodGet, Path: "/user/sessions", Tag: "user", Summary: "Get the active sessions of the user", Auth: true},
+	{Method: fiber.MethodPost, Path: "/user/sessions/revoke/:id", Tag: "user", Summary: "Log out a session", Auth: true},
+	{Method: fiber.MethodPost, Path: "/user/sessions/revoke_others", Tag: "user", Summary: "Log out all sessions except the current one", Auth: true},
+
+	{Method: fiber.MethodGet, Path: "/search/parse", Tag: "search", Summary: "Parse a search query into its clauses and the resulting filter", QueryParams: []string{"query"}},
+	{Method: fiber.MethodGet, Path: "/search/autocomplete", Tag: "search", Summary: "Suggest definitions, tags and authors while typing", QueryParams: []string{"query", "kinds", "limit"}},
+
+	{Method: fiber.MethodGet, Path: "/saved_searches", Tag: "saved searches", Summary: "Get the own saved searches", Auth: true},
+	{Method: fiber.MethodPost, Path: "/saved_searches/create", Tag: "saved searches", Summary: "Save a filter or query to be notified about new matching definitions", Auth: true, Request: types.SaveSearchRequest{}},
+	{Method: fiber.MethodPost, Path: "/saved_searches/delete/:id", Tag: "saved searches", Summary: "Delete a saved search", Auth: true},
+
+	{Method: fiber.MethodGet, Path: "/notifications", Tag: "notifications", Summary: "Get the own notifications, newest first", Auth: true, QueryParams: []string{"unread"}},
+	{Method: fiber.MethodPost, Path: "/notifications/read", Tag: "notifications", Summary: "Mark notifications as read, all of them if no IDs are given", Auth: true, Request: types.ReadNotificationsRequest{}},
+
+	{Method: fiber.MethodGet, Path: "/feeds/definitions/:format", Tag: "feeds", Summary: "Feed of newly approved definitions as atom, rss or json"},
+	{Method: fiber.MethodGet, Path: "/feeds/tags/:tag/:format", Tag: "feeds", Summary: "Feed of newly approved definitions with a tag as atom, rss or json"},
+	{Method: fiber.MethodGet, Path: "/feeds/authors/:slug/:format", Tag: "feeds", Summary: "Feed of newly approved definitions of an author as atom, rss or json"},
+
+	{Method: fiber.MethodGet, Path: "/webhooks", Tag: "webhooks", Summary: "Get all webhooks and the events they can subscribe to", Auth: true},
+	{Method: fiber.MethodPost, Path: "/webhooks/create", Tag: "webhooks", Summary: "Create a webhook and receive its signing secret", Auth: true, Request: types.CreateWebhookRequest{}},
+	{Method: fiber.MethodPost, Path: "/webhooks/update", Tag: "webhooks", Summary: "Change the URL, events or activation of a webhook", Auth: true, Request: types.UpdateWebhookRequest{}},
+	{Method: fiber.MethodPost, Path: "/webhooks/delete/:id", Tag: "webhooks", Summary: "Delete a webhook and its delivery log", Auth: true},
+	{Method: fiber.MethodGet, Path: "/webhooks/deliveries/:id", Tag: "webhooks", Summary: "Get the newest deliveries of a webhook", Auth: true},
+	{Method: fiber.MethodPost, Path: "/webhooks/redeliver/:id", Tag: "webhooks", Summary: "Send the payload of a delivery again", Auth: true},
+
+	{Method: fiber.MethodPost, Path: "/audit/page", Tag: "audit", Summary: "Query the audit log by actor, target, action or time range", Auth: true, Request: types.AuditLogRequest{}},
+
+	{Method: fiber.MethodGet, Path: "/mail/templates", Tag: "mail", Summary: "Get the names of the mail templates and their languages"},
+	{Method: fiber.MethodGet, Path: "/mail/preview/:template", Tag: "mail", Summary: "Render a mail template with sample data", Auth: true, QueryParams: []string{"language"}},
+	{Method: fiber.MethodGet, Path: "/mail/dead", Tag: "mail", Summary: "Get the mails that could not be sent", Auth: true},
+	{Method: fiber.MethodPost, Path: "/mail/retry/:id", Tag: "mail", Summary: "Queue a mail that could not be sent again", Auth: true},
+
+	{Method: fiber.MethodPost, Path: "/graphql", Tag: "graphql", Summary: "Execute a GraphQL query", Request: types.GraphQLRequest{}},
+}
+
+func FindRouteSpec(method string, path string) *RouteSpec {
+
+	for _, route := range RouteSpecs {
+		if route.Method == method && route.Path == path {
+			return route
+		}
+	}
+
+	return nil
+
+}
+
+func containsString(values []string, value string) bool {
+
+	for _, existing := range values {
+		if existing == value {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+func AddOpenAPIRequests(api fiber.Router, prefix string, version *ApiVersion) {
+
+	spec := CreateOpenAPISpec(prefix, version)
+
+	api.Get("/openapi.json", func(ctx *fiber.Ctx) error {
+		return ctx.JSON(spec)
+	})
+
+	api.Get("/docs", func(ctx *fiber.Ctx) error {
+		ctx.Type("html", "utf-8")
+		return ctx.Send(docsPage)
+	})
+
+}
+
+var pathParamRegex = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+/*
+CreateOpenAPISpec creates an OpenAPI 3.1 document of all routes of the version in RouteSpecs.
+Request bodies are described by reflecting on the request types.
+*/
+func CreateOpenAPISpec(prefix string, version *ApiVersion) bson.M {
+
+	schemas := bson.M{
+		"Response": bson.M{
+			"type": "object",
+			"properties": bson.M{
+				"message": bson.M{"type": "string"},
+				"error":   bson.M{"description": "Error code, e.g. NOT_ENOUGH_PERMISSIONS"},
+				"data":    bson.M{"type": "object"},
+			},
+			"required": []string{"message"},
+		},
+	}
+
+	paths := bson.M{}
+
+	for _, route := range RouteSpecs {
+
+		if !route.IsAvailableIn(version.Name) {
+			continue
+		}
+
+		path := pathParamRegex.ReplaceAllString(prefix+route.Path, "{$1}")
+
+		parameters := []bson.M{}
+
+		for _, match := range pathParamRegex.FindAllStringSubmatch(route.Path, -1) {
+			parameters = append(parameters, bson.M{"name": match[1], "in": "path", "required": true, "schema": bson.M{"type": "string"}})
+		}
+
+		for _, name := range route.QueryParams {
+			parameters = append(parameters, bson.M{"name": name, "in": "query", "schema": bson.M{"type": "string"}})
+		}
+
+		if route.Auth {
+			parameters = append(parameters, bson.M{"name": "Authtoken", "in": "header", "required": true, "schema": bson.M{"type": "string"}})
+		}
+
+		operation := bson.M{
+			"tags":        []string{route.Tag},
+			"summary":     route.Summary,
+			"operationId": createOperationId(route),
+			"parameters":  parameters,
+			"responses": bson.M{
+				"200":     createResponseSpec("Success"),
+				"default": createResponseSpec("Error"),
+			},
+		}
+
+		if len(version.Successor) > 0 && route.IsAvailableIn(version.Successor) {
+			operation["deprecated"] = true
+		}
+
+		if route.Request != nil {
+			operation["requestBody"] = bson.M{
+				"required": true,
+				"content": bson.M{
+					"application/json": bson.M{"schema": createSchema(reflect.TypeOf(route.Request), schemas)},
+				},
+			}
+		}
+
+		pathItem, exists := paths[path].(bson.M)
+		if !exists {
+			pathItem = bson.M{}
+			paths[path] = pathItem
+		}
+
+		pathItem[strings.ToLower(route.Method)] = operation
+	}
+
+	return bson.M{
+		"openapi": "3.1.0",
+		"info": bson.M{
+			"title":   "YACOID API",
+			"version": version.Name,
+		},
+		"paths":      paths,
+		"components": bson.M{"schemas": schemas},
+	}
+
+}
+
+func createOperationId(route *RouteSpec) string {
+
+	parts := strings.FieldsFunc(pathParamRegex.ReplaceAllString(route.Path, "by_$1"), func(r rune) bool {
+		return r == '/' || r == '_' || r == '.'
+	})
+
+	operationId := strings.ToLower(route.Method)
+	for _, part := range parts {
+		operationId += strings.ToUpper(part[:1]) + part[1:]
+	}
+
+	return operationId
+
+}
+
+func createResponseSpec(description string) bson.M {
+	return bson.M{
+		"description": description,
+		"content": bson.M{
+			"application/json": bson.M{"schema": bson.M{"$ref": "#/components/schemas/Response"}},
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var objectIdType = reflect.TypeOf(primitive.ObjectID{})
+
+/*
+createSchema creates the JSON schema of a type. Named structs are added to the components and referenced.
+Fields are named after their json tag and required if their validate tag says so.
+*/
+func createSchema(t reflect.Type, schemas bson.M) bson.M {
+
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+
+	var schema bson.M
+
+	switch {
+	case t == timeType:
+		schema = bson.M{"type": "string", "format": "date-time"}
+	case t == objectIdType:
+		schema = bson.M{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	case t.Kind() == reflect.Struct:
+		if _, exists := schemas[t.Name()]; !exists {
+			schemas[t.Name()] = bson.M{}
+			schemas[t.Name()] = createObjectSchema(t, schemas)
+		}
+		schema = bson.M{"$ref": "#/components/schemas/" + t.Name()}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		schema = bson.M{"type": "array", "items": createSchema(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		schema = bson.M{"type": "object", "additionalProperties": createSchema(t.Elem(), schemas)}
+	case t.Kind() == reflect.String:
+		schema = bson.M{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		schema = bson.M{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		schema = bson.M{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		schema = bson.M{"type": "number"}
+	default:
+		return bson.M{}
+	}
+
+	if !nullable {
+		return schema
+	}
+
+	return bson.M{"oneOf": []bson.M{schema, {"type": "null"}}}
+
+}
+
+func createObjectSchema(t reflect.Type, schemas bson.M) bson.M {
+
+	properties := bson.M{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		properties[name] = createSchema(field.Type, schemas)
+
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "required" {
+				required = append(required, name)
+			}
+		}
+	}
+
+	schema := bson.M{"type": "object", "properties": properties}
+
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+
+}
+
+/*
+FindUndocumentedRoutes returns all registered API routes that are missing in RouteSpecs for their version.
+Routes below the bare /api belong to the alias version.
+*/
+func FindUndocumentedRoutes(app *fiber.App, aliasVersion *ApiVersion) []string {
+
+	undocumented := map[string]bool{}
+
+	for _, routes := range app.Stack() {
+		for _, route := range routes {
+
+			if route.Method == fiber.MethodHead || !strings.HasPrefix(route.Path, apiPrefix+"/") {
+				continue
+			}
+
+			path := normalizeRoutePath(route.Path)
+
+			prefix, version := apiPrefix, aliasVersion
+			for _, apiVersion := range ApiVersions {
+				versionPrefix := apiPrefix + "/" + apiVersion.Name
+				if path == versionPrefix || strings.HasPrefix(path, versionPrefix+"/") {
+					prefix, version = versionPrefix, apiVersion
+				}
+			}
+
+			/* middlewares mounted with Use are listed at the version root for every method */
+			if path == prefix {
+				continue
+			}
+
+			routeSpec := FindRouteSpec(route.Method, strings.TrimPrefix(path, prefix))
+
+			if routeSpec == nil || !routeSpec.IsAvailableIn(version.Name) {
+				undocumented[route.Method+" "+path] = true
+			}
+		}
+	}
+
+	missing := []string{}
+	for key := range undocumented {
+		missing = append(missing, key)
+	}
+
+	sort.Strings(missing)
+	return missing
+
+}
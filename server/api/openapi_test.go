@@ -0,0 +1,44 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoutesAreDocumented(t *testing.T) {
+
+	for _, aliasVersion := range ApiVersions {
+
+		app := createApp(aliasVersion)
+
+		if missing := FindUndocumentedRoutes(app, aliasVersion); len(missing) > 0 {
+			t.Errorf("routes missing in the OpenAPI specification with /api as alias of %s: %s", aliasVersion.Name, strings.Join(missing, ", "))
+		}
+	}
+
+}
+
+func TestRouteSpecsAreRegistered(t *testing.T) {
+
+	for _, version := range ApiVersions {
+
+		app := createApp(version)
+		registered := map[string]bool{}
+
+		for _, routes := range app.Stack() {
+			for _, route := range routes {
+				registered[route.Method+" "+normalizeRoutePath(route.Path)] = true
+			}
+		}
+
+		for _, routeSpec := range RouteSpecs {
+
+			key := routeSpec.Method + " " + normalizeRoutePath(apiPrefix+"/"+version.Name+routeSpec.Path)
+
+			if routeSpec.IsAvailableIn(version.Name) && !registered[key] {
+				t.Errorf("documented route %s is not registered", key)
+			}
+		}
+	}
+
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"strings"
+	"yacoid_server/database"
+	"yacoid_server/searchquery"
+	"yacoid_server/types"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func AddSavedSearchRequests(savedSearchApi *fiber.Router, validate *validator.Validate) {
+
+	(*savedSearchApi).Get("/", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		savedSearches, err := database.GetSavedSearches(authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"savedSearches": savedSearches},
+		})
+
+	})
+
+	(*savedSearchApi).Post("/create", func(ctx *fiber.Ctx) error {
+
+		request := new(types.SaveSearchRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		if request.Query != nil {
+
+			query, syntaxErrors := searchquery.Parse(*request.Query)
+
+			if len(syntaxErrors) > 0 {
+				return ctx.Status(GetErrorCode(searchquery.ErrorInvalidSearchQuery)).JSON(Response{
+					Error: searchquery.ErrorInvalidSearchQuery.Error(),
+					Data:  bson.M{"errors": syntaxErrors},
+				})
+			}
+
+			request.Filter = query.ToFilter()
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		savedSearch, err := database.CreateSavedSearch(request, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully saved search!",
+			Data:    bson.M{"savedSearch": savedSearch},
+		})
+
+	})
+
+	(*savedSearchApi).Post("/delete/:id", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		err := database.DeleteSavedSearch(ctx.Params("id"), authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully deleted saved search!",
+		})
+
+	})
+
+}
+
+func AddNotificationRequests(notificationApi *fiber.Router, validate *validator.Validate) {
+
+	(*notificationApi).Get("/", func(ctx *fiber.Ctx) error {
+
+		unreadOnly := ctx.Query("unread") == "true"
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		notifications, err := database.GetNotifications(authToken, unreadOnly)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"notifications": notifications},
+		})
+
+	})
+
+	(*notificationApi).Post("/read", func(ctx *fiber.Ctx) error {
+
+		request := new(types.ReadNotificationsRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		err := database.MarkNotificationsRead(request.IDs, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully marked notifications as read!",
+		})
+
+	})
+
+}
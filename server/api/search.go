@@ -0,0 +1,59 @@
+package api
+
+import (
+	"strings"
+	"yacoid_server/database"
+	"yacoid_server/searchquery"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func AddSearchRequests(searchApi *fiber.Router, validate *validator.Validate) {
+
+	(*searchApi).Get("/autocomplete", func(ctx *fiber.Ctx) error {
+
+		query := ctx.Query("query")
+		limit := GetOptionalIntParam(ctx.Query("limit"), 10)
+
+		kinds := []string{}
+		for _, kind := range strings.Split(ctx.Query("kinds"), ",") {
+			if kind = strings.TrimSpace(kind); len(kind) > 0 {
+				kinds = append(kinds, kind)
+			}
+		}
+
+		suggestions, err := database.Autocomplete(query, kinds, limit)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"suggestions": suggestions},
+		})
+
+	})
+
+	(*searchApi).Get("/parse", func(ctx *fiber.Ctx) error {
+
+		query, syntaxErrors := searchquery.Parse(ctx.Query("query"))
+
+		if len(syntaxErrors) > 0 {
+			return ctx.Status(GetErrorCode(searchquery.ErrorInvalidSearchQuery)).JSON(Response{
+				Error: searchquery.ErrorInvalidSearchQuery.Error(),
+				Data:  bson.M{"errors": syntaxErrors},
+			})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{
+				"query":  query,
+				"filter": query.ToFilter(),
+			},
+		})
+
+	})
+
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 func AddSourcesRequests(sourceApi *fiber.Router, validate *validator.Validate) {
@@ -28,13 +29,244 @@ func AddSourcesRequests(sourceApi *fiber.Router, validate *validator.Validate) {
 		}
 
 		authToken := ctx.GetReqHeaders()["Authtoken"]
-		err := database.CreateSource(request, authToken)
-		if err != nil {
+		source, err := database.CreateSource(request, authToken)
+		if err != nil && err != database.ErrorSourceAlreadyExists {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		/* the existing source is only returned if the user may see it */
+		if source == nil {
 			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
 		}
 
+		populatedSources, populateError := database.PopulateSources([]*types.Source{source}, database.GetOptionalUser(authToken))
+		if populateError != nil {
+			return ctx.Status(GetErrorCode(populateError)).JSON(Response{Error: populateError.Error()})
+		}
+
+		if err == database.ErrorSourceAlreadyExists {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error(), Data: bson.M{"source": populatedSources[0]}})
+		}
+
 		return ctx.JSON(Response{
 			Message: "Successfully created source!",
+			Data:    bson.M{"source": populatedSources[0]},
+		})
+	})
+
+	(*sourceApi).Get("/duplicates", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		duplicates, err := database.FindDuplicateSources(authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"duplicates": duplicates},
+		})
+
+	})
+
+	(*sourceApi).Post("/merge", func(ctx *fiber.Ctx) error {
+
+		request := new(types.MergeSourcesRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		source, err := database.MergeSources(request.TargetID, request.SourceIDs, authToken, GetAuditClient(ctx))
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully merged sources!",
+			Data:    bson.M{"source": source},
+		})
+	})
+
+	(*sourceApi).Get("/source/:id", func(ctx *fiber.Ctx) error {
+
+		id := ctx.Params("id")
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		source, err := database.GetPopulatedSourceById(id, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"source": source},
+		})
+
+	})
+
+	(*sourceApi).Post("/page", func(ctx *fiber.Ctx) error {
+
+		request := new(types.SourcePageRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		sources, pageCount, err := database.GetSources(request.PageSize, request.Page, request.Search, request.Year, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{
+				"sources":   sources,
+				"pageCount": pageCount,
+			},
+		})
+
+	})
+
+	(*sourceApi).Post("/update", func(ctx *fiber.Ctx) error {
+
+		request := new(types.UpdateSourceRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		source, err := database.UpdateSource(request, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully updated source!",
+			Data:    bson.M{"source": source},
+		})
+	})
+
+	(*sourceApi).Post("/delete/:id", func(ctx *fiber.Ctx) error {
+
+		id := ctx.Params("id")
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		blockers, err := database.DeleteSource(id, authToken)
+
+		if err != nil {
+			if err == database.ErrorDeletionBlocked {
+				return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error(), Data: bson.M{"blockers": blockers}})
+			}
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully deleted source!",
+		})
+
+	})
+
+	(*sourceApi).Get("/pending", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		sources, err := database.GetPendingSources(authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"sources": sources},
+		})
+
+	})
+
+	(*sourceApi).Get("/rejections/:id", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		rejections, err := database.GetSourceRejections(ctx.Params("id"), authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"rejections": rejections},
+		})
+
+	})
+
+	(*sourceApi).Get("/approve/:id", func(ctx *fiber.Ctx) error {
+
+		sourceId := ctx.Params("id")
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		err := database.ApproveSource(sourceId, authToken, GetAuditClient(ctx))
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully approved source!",
+		})
+
+	})
+
+	(*sourceApi).Post("/reject", func(ctx *fiber.Ctx) error {
+
+		request := new(types.RejectRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		err := database.RejectSource(request.ID, authToken, request.Content, GetAuditClient(ctx))
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully rejected source!",
 		})
 	})
 
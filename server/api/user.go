@@ -1,8 +1,9 @@
 package api
 
 import (
-	"fmt"
+	"strings"
 	"yacoid_server/database"
+	"yacoid_server/types"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
@@ -19,6 +20,7 @@ type ChangeAccountDataRequest struct {
 	LastName        *string `bson:"last_name,omitempty" json:"lastName,omitempty"`
 	Email           *string `bson:"email,omitempty" json:"email,omitempty"`
 	City            *string `bson:"city,omitempty" json:"city,omitempty"`
+	Language        *string `bson:"language,omitempty" json:"language,omitempty"`
 	CurrentPassword *string `bson:"current_password,omitempty" json:"currentPassword,omitempty"`
 	NewPassword     *string `bson:"new_password,omitempty" json:"newPassword,omitempty"`
 }
@@ -34,7 +36,7 @@ func AddUserRequests(userApi *fiber.Router, validate *validator.Validate) {
 		}
 
 		authToken := ctx.GetReqHeaders()["Authtoken"]
-		err := database.DeleteUser(authToken, request.PasswordHash, request.Reason)
+		err := database.DeleteUser(authToken, request.PasswordHash, request.Reason, GetAuditClient(ctx))
 
 		if err != nil {
 			return ctx.Status(fiber.StatusBadRequest).JSON(Response{Error: err.Error()})
@@ -50,14 +52,16 @@ func AddUserRequests(userApi *fiber.Router, validate *validator.Validate) {
 
 		request := new(ChangeAccountDataRequest)
 
-		fmt.Println("1")
 		if err := ctx.BodyParser(request); err != nil {
 			return ctx.Status(fiber.StatusBadRequest).JSON(Response{Error: err.Error()})
 		}
-		fmt.Println(request)
 
 		authToken := ctx.GetReqHeaders()["Authtoken"]
-		response, err := database.ChangeAccountData(authToken, request.FirstName, request.LastName, request.Email, request.City, request.CurrentPassword, request.NewPassword)
+		response, err := database.ChangeAccountData(authToken, request.FirstName, request.LastName, request.Email, request.City, request.Language, request.CurrentPassword, request.NewPassword, GetAuditClient(ctx))
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
 
 		if response.EmailVerification != nil && response.EmailVerification.Error != nil {
 			errorText := ErrorEmailVerification.Error()
@@ -71,16 +75,101 @@ func AddUserRequests(userApi *fiber.Router, validate *validator.Validate) {
 			}
 		}
 
-		fmt.Println(response, err)
+		return ctx.JSON(Response{
+			Data: bson.M{
+				"response": response,
+			},
+		})
+
+	})
+
+	(*userApi).Get("/email_preferences", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		optOuts, err := database.GetEmailOptOuts(authToken)
 
 		if err != nil {
-			return ctx.Status(fiber.StatusBadRequest).JSON(Response{Error: err.Error()})
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
 		}
 
 		return ctx.JSON(Response{
-			Data: bson.M{
-				"response": response,
-			},
+			Data: bson.M{"optOuts": optOuts, "kinds": database.EmailKinds},
+		})
+
+	})
+
+	(*userApi).Post("/email_preferences", func(ctx *fiber.Ctx) error {
+
+		request := new(types.EmailPreferencesRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		optOuts, err := database.UpdateEmailOptOuts(request.OptOuts, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully updated email preferences!",
+			Data:    bson.M{"optOuts": optOuts},
+		})
+
+	})
+
+	(*userApi).Get("/sessions", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		sessions, err := database.GetSessions(authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"sessions": sessions},
+		})
+
+	})
+
+	(*userApi).Post("/sessions/revoke/:id", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		err := database.RevokeSession(ctx.Params("id"), authToken, GetAuditClient(ctx))
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully revoked session!",
+		})
+
+	})
+
+	(*userApi).Post("/sessions/revoke_others", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		revoked, err := database.RevokeOtherSessions(authToken, GetAuditClient(ctx))
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully revoked other sessions!",
+			Data:    bson.M{"revoked": revoked},
 		})
 
 	})
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"yacoid_server/constants"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+const apiPrefix = "/api"
+
+var ErrorInvalidVersionConfig = errors.New("INVALID_API_VERSION_CONFIG")
+
+/*
+ApiVersion is a version of the API served below /api/<name>. A version with a successor is deprecated:
+its routes that also exist in the successor respond with Deprecation, Sunset and Link headers.
+The dates are read from the given environment variables in the format 2006-01-02 by LoadVersionConfig.
+*/
+type ApiVersion struct {
+	Name               string
+	Successor          string
+	DeprecationDateKey string
+	SunsetDateKey      string
+
+	deprecationDate *time.Time
+	sunsetDate      *time.Time
+}
+
+/*
+v2 serves the same routes as v1 apart from the retired legacy login, so v1 gets v2 as its successor
+only once v2 changes a route that v1 serves as well. Until then clients have nothing to migrate to.
+*/
+var ApiVersions = []*ApiVersion{
+	{Name: "v1", DeprecationDateKey: constants.EnvKeyApiV1DeprecationDate, SunsetDateKey: constants.EnvKeyApiV1SunsetDate},
+	{Name: "v2"},
+}
+
+/* The bare /api stays an alias of v1 unless configured otherwise, so that existing clients keep working. */
+const defaultAliasVersion = "v1"
+
+var aliasVersion = GetApiVersion(defaultAliasVersion)
+
+func GetApiVersion(name string) *ApiVersion {
+
+	for _, version := range ApiVersions {
+		if version.Name == name {
+			return version
+		}
+	}
+
+	return nil
+
+}
+
+/*
+LoadVersionConfig reads the version the bare /api is an alias for and the deprecation dates of all versions.
+*/
+func LoadVersionConfig() error {
+
+	name := os.Getenv(constants.EnvKeyApiAliasVersion)
+
+	if len(name) == 0 {
+		name = defaultAliasVersion
+	}
+
+	version := GetApiVersion(name)
+
+	if version == nil {
+		return fmt.Errorf("%w: unknown API version %q in %s", ErrorInvalidVersionConfig, name, constants.EnvKeyApiAliasVersion)
+	}
+
+	for _, apiVersion := range ApiVersions {
+
+		deprecationDate, err := parseVersionDate(apiVersion.DeprecationDateKey)
+
+		if err != nil {
+			return err
+		}
+
+		sunsetDate, err := parseVersionDate(apiVersion.SunsetDateKey)
+
+		if err != nil {
+			return err
+		}
+
+		apiVersion.deprecationDate = deprecationDate
+		apiVersion.sunsetDate = sunsetDate
+	}
+
+	aliasVersion = version
+	return nil
+
+}
+
+/*
+GetAliasVersion returns the version the bare /api is an alias for.
+*/
+func GetAliasVersion() *ApiVersion {
+	return aliasVersion
+}
+
+/*
+AddVersionRequests registers all routes of a version. Handlers are shared between versions as long as their behavior is identical.
+*/
+func AddVersionRequests(versionApi fiber.Router, prefix string, version *ApiVersion, validate *validator.Validate) {
+
+	/* the alias follows the configured version, so clients of the bare /api are never told to migrate */
+	if len(version.Successor) > 0 && prefix != apiPrefix {
+		versionApi.Use(createDeprecationMiddleware(prefix, version))
+	}
+
+	definitionApi := versionApi.Group("/definitions")
+	AddDefinitionRequests(&definitionApi, validate)
+
+	authorApi := versionApi.Group("/authors")
+	AddAuthorsRequests(&authorApi, validate)
+
+	sourceApi := versionApi.Group("/sources")
+	AddSourcesRequests(&sourceApi, validate)
+
+	authApi := versionApi.Group("/auth")
+	AddAuthRequests(&authApi, version, validate)
+
+	userApi := versionApi.Group("/user")
+	AddUserRequests(&userApi, validate)
+
+	searchApi := versionApi.Group("/search")
+	AddSearchRequests(&searchApi, validate)
+
+	savedSearchApi := versionApi.Group("/saved_searches")
+	AddSavedSearchRequests(&savedSearchApi, validate)
+
+	notificationApi := versionApi.Group("/notifications")
+	AddNotificationRequests(&notificationApi, validate)
+
+	feedApi := versionApi.Group("/feeds")
+	AddFeedRequests(&feedApi, validate)
+
+	webhookApi := versionApi.Group("/webhooks")
+	AddWebhookRequests(&webhookApi, validate)
+
+	auditApi := versionApi.Group("/audit")
+	AddAuditRequests(&auditApi, validate)
+
+	mailApi := versionApi.Group("/mail")
+	AddMailRequests(&mailApi, validate)
+
+	graphqlApi := versionApi.Group("/graphql")
+	AddGraphQLRequests(&graphqlApi, validate)
+
+	AddOpenAPIRequests(versionApi, prefix, version)
+
+}
+
+/*
+createDeprecationMiddleware adds the deprecation headers after the handler ran, since only then the matched route is known.
+*/
+func createDeprecationMiddleware(prefix string, version *ApiVersion) fiber.Handler {
+
+	deprecation := "true"
+	if version.deprecationDate != nil {
+		deprecation = fmt.Sprintf("@%d", version.deprecationDate.Unix())
+	}
+
+	sunset := version.sunsetDate
+	successorPrefix := apiPrefix + "/" + version.Successor
+
+	return func(ctx *fiber.Ctx) error {
+
+		err := ctx.Next()
+
+		path := strings.TrimPrefix(normalizeRoutePath(ctx.Route().Path), prefix)
+		route := FindRouteSpec(ctx.Method(), path)
+
+		if route == nil || !route.IsAvailableIn(version.Name) || !route.IsAvailableIn(version.Successor) {
+			return err
+		}
+
+		ctx.Set("Deprecation", deprecation)
+
+		if sunset != nil {
+			ctx.Set("Sunset", sunset.Format(http.TimeFormat))
+		}
+
+		successorPath := successorPrefix + strings.TrimPrefix(ctx.Path(), prefix)
+		ctx.Set(fiber.HeaderLink, fmt.Sprintf("<%s>; rel=\"successor-version\"", successorPath))
+
+		return err
+
+	}
+
+}
+
+func parseVersionDate(key string) (*time.Time, error) {
+
+	value := os.Getenv(key)
+
+	if len(key) == 0 || len(value) == 0 {
+		return nil, nil
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid date %q in %s", ErrorInvalidVersionConfig, value, key)
+	}
+
+	return &date, nil
+
+}
+
+func normalizeRoutePath(path string) string {
+
+	if len(path) > 1 {
+		return strings.TrimSuffix(path, "/")
+	}
+
+	return path
+
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"yacoid_server/constants"
+)
+
+func TestLoadVersionConfig(t *testing.T) {
+
+	tests := []struct {
+		aliasVersion    string
+		deprecationDate string
+		expected        string
+		err             error
+	}{
+		{"", "", "v1", nil},
+		{"v2", "", "v2", nil},
+		{"v1", "2030-01-31", "v1", nil},
+		{"v3", "", "", ErrorInvalidVersionConfig},
+		{"", "31.01.2030", "", ErrorInvalidVersionConfig},
+	}
+
+	t.Cleanup(func() {
+		for _, version := range ApiVersions {
+			version.deprecationDate, version.sunsetDate = nil, nil
+		}
+		aliasVersion = GetApiVersion(defaultAliasVersion)
+	})
+
+	for _, test := range tests {
+
+		t.Setenv(constants.EnvKeyApiAliasVersion, test.aliasVersion)
+		t.Setenv(constants.EnvKeyApiV1DeprecationDate, test.deprecationDate)
+		aliasVersion = GetApiVersion(defaultAliasVersion)
+
+		err := LoadVersionConfig()
+
+		if !errors.Is(err, test.err) || (err == nil && GetAliasVersion().Name != test.expected) {
+			t.Errorf("LoadVersionConfig() with %q, %q = %s, %v, expected %s, %v", test.aliasVersion, test.deprecationDate, GetAliasVersion().Name, err, test.expected, test.err)
+		}
+	}
+
+}
+
+func TestIdenticalVersionsAreNotDeprecated(t *testing.T) {
+
+	app := createApp(GetApiVersion(defaultAliasVersion))
+
+	for _, path := range []string{"/api/openapi.json", "/api/v1/openapi.json", "/api/v2/openapi.json"} {
+
+		response, err := app.Test(httptest.NewRequest("GET", path, nil))
+
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+
+		if deprecation := response.Header.Get("Deprecation"); len(deprecation) > 0 {
+			t.Errorf("GET %s responded with Deprecation: %s", path, deprecation)
+		}
+	}
+
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"strings"
+	"yacoid_server/database"
+	"yacoid_server/types"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func AddWebhookRequests(webhookApi *fiber.Router, validate *validator.Validate) {
+
+	(*webhookApi).Get("/", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		webhooks, err := database.GetWebhooks(authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"webhooks": webhooks, "events": database.WebhookEvents},
+		})
+
+	})
+
+	(*webhookApi).Post("/create", func(ctx *fiber.Ctx) error {
+
+		request := new(types.CreateWebhookRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		webhook, secret, err := database.CreateWebhook(request, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully created webhook!",
+			Data:    bson.M{"webhook": webhook, "secret": secret},
+		})
+
+	})
+
+	(*webhookApi).Post("/update", func(ctx *fiber.Ctx) error {
+
+		request := new(types.UpdateWebhookRequest)
+
+		if err := ctx.BodyParser(request); err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		validateErrors := request.Validate(validate)
+
+		if validateErrors != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(Response{
+				Error: "Error on fields: " + strings.Join(validateErrors, ", "),
+			})
+		}
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		webhook, err := database.UpdateWebhook(request, authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully updated webhook!",
+			Data:    bson.M{"webhook": webhook},
+		})
+
+	})
+
+	(*webhookApi).Post("/delete/:id", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		err := database.DeleteWebhook(ctx.Params("id"), authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully deleted webhook!",
+		})
+
+	})
+
+	(*webhookApi).Get("/deliveries/:id", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		deliveries, err := database.GetWebhookDeliveries(ctx.Params("id"), authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Data: bson.M{"deliveries": deliveries},
+		})
+
+	})
+
+	(*webhookApi).Post("/redeliver/:id", func(ctx *fiber.Ctx) error {
+
+		authToken := ctx.GetReqHeaders()["Authtoken"]
+		delivery, err := database.RedeliverWebhookDelivery(ctx.Params("id"), authToken)
+
+		if err != nil {
+			return ctx.Status(GetErrorCode(err)).JSON(Response{Error: err.Error()})
+		}
+
+		return ctx.JSON(Response{
+			Message: "Successfully queued redelivery!",
+			Data:    bson.M{"delivery": delivery},
+		})
+
+	})
+
+}
@@ -2,6 +2,9 @@ package common
 
 import (
 	"errors"
+	"os"
+	"strings"
+	"yacoid_server/constants"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/joho/godotenv"
@@ -40,3 +43,18 @@ func LoadEnvironmentVariables() error {
 	return nil
 
 }
+
+/*
+GetPublicBaseUrl returns the URL under which users reach the client, used for links in emails and feeds.
+*/
+func GetPublicBaseUrl() string {
+
+	baseUrl := os.Getenv(constants.EnvKeyPublicBaseUrl)
+
+	if len(baseUrl) == 0 {
+		return "http://localhost:3000"
+	}
+
+	return strings.TrimRight(baseUrl, "/")
+
+}
@@ -0,0 +1,259 @@
+package common
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const snippetContext = 60
+const maxSnippetFragments = 3
+
+/*
+Highlights are HTML-escaped excerpts of a search result in which every match is wrapped in <mark>.
+*/
+type Highlights struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+type wordSpan struct {
+	start  int
+	end    int
+	folded string
+}
+
+/*
+stem strips common English and German inflections, so that highlighting roughly follows the
+stemming of the text index ("perceived" and "perceives" both highlight "perceive").
+*/
+func stem(word string) string {
+
+	for _, suffix := range []string{"ingly", "ings", "ing", "edly", "ed", "es", "en", "er", "ly", "s", "e"} {
+		if strings.HasSuffix(word, suffix) && len([]rune(word))-len([]rune(suffix)) >= 3 {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+
+	return word
+
+}
+
+func splitWords(chars []rune) []wordSpan {
+
+	words := []wordSpan{}
+
+	for i := 0; i < len(chars); {
+
+		if !unicode.IsLetter(chars[i]) && !unicode.IsNumber(chars[i]) {
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(chars) && (unicode.IsLetter(chars[end]) || unicode.IsNumber(chars[end])) {
+			end++
+		}
+
+		words = append(words, wordSpan{start: i, end: end, folded: FoldText(string(chars[i:end]))})
+		i = end
+	}
+
+	return words
+
+}
+
+/*
+findMatches returns the sorted, non-overlapping rune ranges of all terms and phrases of the query in the text.
+*/
+func findMatches(chars []rune, query *TextQuery) [][2]int {
+
+	words := splitWords(chars)
+	matches := [][2]int{}
+
+	stems := map[string]bool{}
+	for _, term := range query.Terms {
+		for _, word := range Words(term) {
+			stems[stem(FoldText(word))] = true
+		}
+	}
+
+	for _, word := range words {
+		if stems[stem(word.folded)] {
+			matches = append(matches, [2]int{word.start, word.end})
+		}
+	}
+
+	for _, phrase := range query.Phrases {
+
+		phraseWords := Words(FoldText(phrase))
+
+		for i := 0; i+len(phraseWords) <= len(words); i++ {
+
+			matching := true
+			for j, phraseWord := range phraseWords {
+				if words[i+j].folded != phraseWord {
+					matching = false
+					break
+				}
+			}
+
+			if matching {
+				matches = append(matches, [2]int{words[i].start, words[i+len(phraseWords)-1].end})
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i int, j int) bool {
+		return matches[i][0] < matches[j][0]
+	})
+
+	merged := [][2]int{}
+	for _, match := range matches {
+		if len(merged) > 0 && match[0] <= merged[len(merged)-1][1] {
+			if match[1] > merged[len(merged)-1][1] {
+				merged[len(merged)-1][1] = match[1]
+			}
+			continue
+		}
+		merged = append(merged, match)
+	}
+
+	return merged
+
+}
+
+/*
+markRanges escapes the characters from start to end and wraps the matches in <mark>.
+*/
+func markRanges(chars []rune, matches [][2]int, start int, end int) string {
+
+	var builder strings.Builder
+	position := start
+
+	for _, match := range matches {
+
+		if match[1] <= start || match[0] >= end {
+			continue
+		}
+
+		builder.WriteString(html.EscapeString(string(chars[position:match[0]])))
+		builder.WriteString("<mark>")
+		builder.WriteString(html.EscapeString(string(chars[match[0]:match[1]])))
+		builder.WriteString("</mark>")
+		position = match[1]
+	}
+
+	builder.WriteString(html.EscapeString(string(chars[position:end])))
+	return builder.String()
+
+}
+
+/*
+HighlightText returns the whole text with all matches of the query highlighted.
+*/
+func HighlightText(text string, query *TextQuery) string {
+
+	chars := []rune(text)
+	return markRanges(chars, findMatches(chars, query), 0, len(chars))
+
+}
+
+/*
+CreateSnippet returns up to three highlighted fragments of the text around the matches of the query,
+joined by ellipses. Texts without matches are shortened to their beginning.
+*/
+func CreateSnippet(text string, query *TextQuery) string {
+
+	chars := []rune(text)
+	matches := findMatches(chars, query)
+
+	if len(matches) == 0 {
+		if len(chars) <= 2*snippetContext {
+			return html.EscapeString(text)
+		}
+		return html.EscapeString(strings.TrimSpace(string(chars[:expandToWord(chars, 2*snippetContext, 1)]))) + " …"
+	}
+
+	fragments := [][2]int{}
+
+	for _, match := range matches {
+
+		start := expandToWord(chars, maxInt(match[0]-snippetContext, 0), -1)
+		end := expandToWord(chars, minInt(match[1]+snippetContext, len(chars)), 1)
+
+		if len(fragments) > 0 && start <= fragments[len(fragments)-1][1] {
+			fragments[len(fragments)-1][1] = end
+			continue
+		}
+
+		if len(fragments) == maxSnippetFragments {
+			break
+		}
+
+		fragments = append(fragments, [2]int{start, end})
+	}
+
+	parts := []string{}
+	for _, fragment := range fragments {
+		parts = append(parts, strings.TrimSpace(markRanges(chars, matches, fragment[0], fragment[1])))
+	}
+
+	snippet := strings.Join(parts, " … ")
+
+	if fragments[0][0] > 0 {
+		snippet = "… " + snippet
+	}
+
+	if fragments[len(fragments)-1][1] < len(chars) {
+		snippet += " …"
+	}
+
+	return snippet
+
+}
+
+/*
+expandToWord moves the position in the given direction until it is not inside a word anymore.
+*/
+func expandToWord(chars []rune, position int, direction int) int {
+
+	isWordChar := func(index int) bool {
+		return index >= 0 && index < len(chars) && (unicode.IsLetter(chars[index]) || unicode.IsNumber(chars[index]))
+	}
+
+	if direction < 0 {
+		for position > 0 && isWordChar(position-1) && isWordChar(position) {
+			position--
+		}
+		return position
+	}
+
+	for position < len(chars) && isWordChar(position-1) && isWordChar(position) {
+		position++
+	}
+
+	return position
+
+}
+
+func minInt(a int, b int) int {
+
+	if a < b {
+		return a
+	}
+
+	return b
+
+}
+
+func maxInt(a int, b int) int {
+
+	if a > b {
+		return a
+	}
+
+	return b
+
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlightText(t *testing.T) {
+
+	tests := []struct {
+		text     string
+		search   string
+		expected string
+	}{
+		{"Perception is the organization of sensory information.", "perception", "<mark>Perception</mark> is the organization of sensory information."},
+		{"She perceived what he perceives.", "perceive", "She <mark>perceived</mark> what he <mark>perceives</mark>."},
+		{"Artificial intelligence is intelligence of machines.", `"artificial intelligence"`, "<mark>Artificial intelligence</mark> is intelligence of machines."},
+		{"Artificial, intelligence!", `"artificial intelligence"`, "<mark>Artificial, intelligence</mark>!"},
+		{"Intelligence, artificial or not.", `"artificial intelligence"`, "Intelligence, artificial or not."},
+		{"Artificial intelligence", `"artificial intelligence" intelligence`, "<mark>Artificial intelligence</mark>"},
+		{"Mind and body", "mind -body", "<mark>Mind</mark> and body"},
+		{"Mind and body", `-"mind and body"`, "Mind and body"},
+		{"Über Wahrnehmung", "uber", "<mark>Über</mark> Wahrnehmung"},
+		{"<b>Mind</b> & body", "mind", "&lt;b&gt;<mark>Mind</mark>&lt;/b&gt; &amp; body"},
+		{"Mindfulness", "mind", "Mindfulness"},
+	}
+
+	for _, test := range tests {
+
+		if highlighted := HighlightText(test.text, ParseTextQuery(test.search)); highlighted != test.expected {
+			t.Errorf("HighlightText(%q, %q) = %q, expected %q", test.text, test.search, highlighted, test.expected)
+		}
+	}
+
+}
+
+func TestCreateSnippet(t *testing.T) {
+
+	filler := strings.Repeat("lorem ipsum ", 20)
+
+	tests := []struct {
+		text     string
+		search   string
+		expected string
+	}{
+		{"Short text about the mind.", "mind", "Short text about the <mark>mind</mark>."},
+		{"Short text & nothing else.", "mind", "Short text &amp; nothing else."},
+		{filler + "mind " + filler, "mind", "… lorem ipsum lorem ipsum lorem ipsum lorem ipsum lorem ipsum <mark>mind</mark> lorem ipsum lorem ipsum lorem ipsum lorem ipsum lorem ipsum …"},
+		{"mind " + filler, "mind", "<mark>mind</mark> lorem ipsum lorem ipsum lorem ipsum lorem ipsum lorem ipsum …"},
+		{filler, "mind", strings.TrimSpace(strings.Repeat("lorem ipsum ", 10)) + " …"},
+	}
+
+	for _, test := range tests {
+
+		if snippet := CreateSnippet(test.text, ParseTextQuery(test.search)); snippet != test.expected {
+			t.Errorf("CreateSnippet(%q, %q) = %q, expected %q", test.text, test.search, snippet, test.expected)
+		}
+	}
+
+	text := "mind " + filler + "mind " + filler + "mind " + filler + "mind " + filler
+	snippet := CreateSnippet(text, ParseTextQuery("mind"))
+
+	if count := strings.Count(snippet, "<mark>"); count != maxSnippetFragments {
+		t.Errorf("CreateSnippet returned %d fragments, expected %d: %q", count, maxSnippetFragments, snippet)
+	}
+
+}
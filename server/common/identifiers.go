@@ -0,0 +1,202 @@
+package common
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var ErrorInvalidISBN = errors.New("INVALID_ISBN")
+var ErrorInvalidDOI = errors.New("INVALID_DOI")
+
+var doiPattern = regexp.MustCompile(`^10\.\d{4,9}/\S+$`)
+
+var doiPrefixes = []string{
+	"https://doi.org/",
+	"http://doi.org/",
+	"https://dx.doi.org/",
+	"http://dx.doi.org/",
+	"doi.org/",
+	"dx.doi.org/",
+	"doi:",
+}
+
+/*
+NormalizeISBN strips hyphens and spaces, validates the checksum and returns the ISBN-13 form,
+so that the ISBN-10 and ISBN-13 notation of the same book end up as the same identifier.
+*/
+func NormalizeISBN(isbn string) (string, error) {
+
+	cleaned := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(isbn)))
+	cleaned = strings.TrimPrefix(cleaned, "ISBN:")
+	cleaned = strings.TrimPrefix(cleaned, "ISBN")
+
+	switch len(cleaned) {
+	case 10:
+		if !isValidISBN10(cleaned) {
+			return "", ErrorInvalidISBN
+		}
+		return isbn10To13(cleaned), nil
+	case 13:
+		if !isValidISBN13(cleaned) {
+			return "", ErrorInvalidISBN
+		}
+		return cleaned, nil
+	}
+
+	return "", ErrorInvalidISBN
+
+}
+
+func isValidISBN10(isbn string) bool {
+
+	sum := 0
+	for i, char := range isbn {
+
+		var digit int
+		if char == 'X' && i == 9 {
+			digit = 10
+		} else if char >= '0' && char <= '9' {
+			digit = int(char - '0')
+		} else {
+			return false
+		}
+
+		sum += digit * (10 - i)
+	}
+
+	return sum%11 == 0
+
+}
+
+func isValidISBN13(isbn string) bool {
+
+	if !strings.HasPrefix(isbn, "978") && !strings.HasPrefix(isbn, "979") {
+		return false
+	}
+
+	sum := 0
+	for i, char := range isbn {
+
+		if char < '0' || char > '9' {
+			return false
+		}
+
+		digit := int(char - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+
+	return sum%10 == 0
+
+}
+
+func isbn10To13(isbn string) string {
+
+	body := "978" + isbn[:9]
+
+	sum := 0
+	for i, char := range body {
+		digit := int(char - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+
+	checkDigit := (10 - sum%10) % 10
+	return body + string(rune('0'+checkDigit))
+
+}
+
+/*
+NormalizeDOI removes resolver prefixes (doi.org URLs, "doi:") and lowercases the DOI.
+DOIs are case-insensitive, so the lowercase form is used for storage and comparison.
+*/
+func NormalizeDOI(doi string) (string, error) {
+
+	cleaned := strings.ToLower(strings.TrimSpace(doi))
+
+	for _, prefix := range doiPrefixes {
+		if strings.HasPrefix(cleaned, prefix) {
+			cleaned = strings.TrimSpace(strings.TrimPrefix(cleaned, prefix))
+			break
+		}
+	}
+
+	if !doiPattern.MatchString(cleaned) {
+		return "", ErrorInvalidDOI
+	}
+
+	return cleaned, nil
+
+}
+
+var ErrorInvalidORCID = errors.New("INVALID_ORCID")
+var ErrorInvalidWikidataID = errors.New("INVALID_WIKIDATA_ID")
+
+var orcidPattern = regexp.MustCompile(`^\d{4}-\d{4}-\d{4}-\d{3}[\dX]$`)
+var wikidataPattern = regexp.MustCompile(`^Q[1-9]\d*$`)
+
+/*
+NormalizeORCID removes the orcid.org prefix and validates the ISO 7064 11,2 check digit.
+*/
+func NormalizeORCID(orcid string) (string, error) {
+
+	cleaned := strings.ToUpper(strings.TrimSpace(orcid))
+
+	for _, prefix := range []string{"HTTPS://ORCID.ORG/", "HTTP://ORCID.ORG/", "ORCID.ORG/"} {
+		cleaned = strings.TrimPrefix(cleaned, prefix)
+	}
+
+	if len(cleaned) == 16 && !strings.Contains(cleaned, "-") {
+		cleaned = cleaned[0:4] + "-" + cleaned[4:8] + "-" + cleaned[8:12] + "-" + cleaned[12:16]
+	}
+
+	if !orcidPattern.MatchString(cleaned) {
+		return "", ErrorInvalidORCID
+	}
+
+	digits := strings.ReplaceAll(cleaned, "-", "")
+
+	total := 0
+	for _, char := range digits[:15] {
+		total = (total + int(char-'0')) * 2
+	}
+
+	result := (12 - total%11) % 11
+	checkDigit := byte('0' + result)
+	if result == 10 {
+		checkDigit = 'X'
+	}
+
+	if digits[15] != checkDigit {
+		return "", ErrorInvalidORCID
+	}
+
+	return cleaned, nil
+
+}
+
+/*
+NormalizeWikidataID accepts plain item IDs ("q42") as well as wikidata.org URLs and returns the item ID ("Q42").
+*/
+func NormalizeWikidataID(wikidataId string) (string, error) {
+
+	cleaned := strings.TrimSpace(wikidataId)
+
+	if index := strings.LastIndex(cleaned, "/"); index >= 0 {
+		cleaned = cleaned[index+1:]
+	}
+
+	cleaned = strings.ToUpper(cleaned)
+
+	if !wikidataPattern.MatchString(cleaned) {
+		return "", ErrorInvalidWikidataID
+	}
+
+	return cleaned, nil
+
+}
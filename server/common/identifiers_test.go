@@ -0,0 +1,127 @@
+package common
+
+import "testing"
+
+func TestNormalizeISBN(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected string
+		err      error
+	}{
+		{"978-0-306-40615-7", "9780306406157", nil},
+		{"9780306406157", "9780306406157", nil},
+		{" ISBN 978 0 306 40615 7 ", "9780306406157", nil},
+		{"isbn:978-0-306-40615-7", "9780306406157", nil},
+		{"0-306-40615-2", "9780306406157", nil},
+		{"080442957X", "9780804429573", nil},
+		{"080442957x", "9780804429573", nil},
+		{"979-10-90636-07-1", "9791090636071", nil},
+		{"978-0-306-40615-8", "", ErrorInvalidISBN},
+		{"0-306-40615-3", "", ErrorInvalidISBN},
+		{"X804429570", "", ErrorInvalidISBN},
+		{"977-0-306-40615-4", "", ErrorInvalidISBN},
+		{"978-0-306-4061A-7", "", ErrorInvalidISBN},
+		{"978030640615", "", ErrorInvalidISBN},
+		{"", "", ErrorInvalidISBN},
+	}
+
+	for _, test := range tests {
+
+		isbn, err := NormalizeISBN(test.input)
+
+		if isbn != test.expected || err != test.err {
+			t.Errorf("NormalizeISBN(%q) = %q, %v, expected %q, %v", test.input, isbn, err, test.expected, test.err)
+		}
+	}
+
+}
+
+func TestNormalizeDOI(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected string
+		err      error
+	}{
+		{"10.1000/182", "10.1000/182", nil},
+		{"10.1038/NPHYS1170", "10.1038/nphys1170", nil},
+		{"https://doi.org/10.1000/182", "10.1000/182", nil},
+		{"http://dx.doi.org/10.1000/182", "10.1000/182", nil},
+		{"doi: 10.1000/182", "10.1000/182", nil},
+		{" DOI:10.1000/182 ", "10.1000/182", nil},
+		{"10.123/182", "", ErrorInvalidDOI},
+		{"11.1000/182", "", ErrorInvalidDOI},
+		{"10.1000/", "", ErrorInvalidDOI},
+		{"10.1000/18 2", "", ErrorInvalidDOI},
+		{"https://example.org/10.1000/182", "", ErrorInvalidDOI},
+		{"", "", ErrorInvalidDOI},
+	}
+
+	for _, test := range tests {
+
+		doi, err := NormalizeDOI(test.input)
+
+		if doi != test.expected || err != test.err {
+			t.Errorf("NormalizeDOI(%q) = %q, %v, expected %q, %v", test.input, doi, err, test.expected, test.err)
+		}
+	}
+
+}
+
+func TestNormalizeORCID(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected string
+		err      error
+	}{
+		{"0000-0002-1825-0097", "0000-0002-1825-0097", nil},
+		{"https://orcid.org/0000-0001-5109-3700", "0000-0001-5109-3700", nil},
+		{"orcid.org/0000-0002-1694-233x", "0000-0002-1694-233X", nil},
+		{"000000021694233X", "0000-0002-1694-233X", nil},
+		{" 0000-0002-1825-0097 ", "0000-0002-1825-0097", nil},
+		{"0000-0002-1825-0098", "", ErrorInvalidORCID},
+		{"0000-0002-1694-2330", "", ErrorInvalidORCID},
+		{"0000-0002-1825-009", "", ErrorInvalidORCID},
+		{"0000-0002-1825-00X7", "", ErrorInvalidORCID},
+		{"https://example.org/0000-0002-1825-0097", "", ErrorInvalidORCID},
+		{"", "", ErrorInvalidORCID},
+	}
+
+	for _, test := range tests {
+
+		orcid, err := NormalizeORCID(test.input)
+
+		if orcid != test.expected || err != test.err {
+			t.Errorf("NormalizeORCID(%q) = %q, %v, expected %q, %v", test.input, orcid, err, test.expected, test.err)
+		}
+	}
+
+}
+
+func TestNormalizeWikidataID(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected string
+		err      error
+	}{
+		{"Q42", "Q42", nil},
+		{"q42", "Q42", nil},
+		{"https://www.wikidata.org/wiki/Q42", "Q42", nil},
+		{"Q042", "", ErrorInvalidWikidataID},
+		{"P31", "", ErrorInvalidWikidataID},
+		{"Q", "", ErrorInvalidWikidataID},
+	}
+
+	for _, test := range tests {
+
+		wikidataId, err := NormalizeWikidataID(test.input)
+
+		if wikidataId != test.expected || err != test.err {
+			t.Errorf("NormalizeWikidataID(%q) = %q, %v, expected %q, %v", test.input, wikidataId, err, test.expected, test.err)
+		}
+	}
+
+}
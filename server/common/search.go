@@ -0,0 +1,156 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+/*
+TextQuery is a parsed full text search like `perception "artificial intelligence" -philosophy`.
+Terms are optional and only rank the results, phrases have to occur literally,
+excluded terms and phrases must not occur at all.
+*/
+type TextQuery struct {
+	Terms           []string
+	Phrases         []string
+	ExcludedTerms   []string
+	ExcludedPhrases []string
+}
+
+/*
+ParseTextQuery splits the search into terms and "quoted phrases". A leading minus excludes a term or phrase.
+An unterminated quote extends the phrase to the end of the search.
+*/
+func ParseTextQuery(search string) *TextQuery {
+
+	query := TextQuery{}
+	chars := []rune(search)
+
+	for i := 0; i < len(chars); {
+
+		if unicode.IsSpace(chars[i]) {
+			i++
+			continue
+		}
+
+		excluded := false
+		if chars[i] == '-' {
+			excluded = true
+			i++
+		}
+
+		if i < len(chars) && chars[i] == '"' {
+
+			end := i + 1
+			for end < len(chars) && chars[end] != '"' {
+				end++
+			}
+
+			phrase := strings.Join(strings.Fields(string(chars[i+1:minInt(end, len(chars))])), " ")
+			i = end + 1
+
+			if len(phrase) == 0 {
+				continue
+			}
+
+			if excluded {
+				query.ExcludedPhrases = append(query.ExcludedPhrases, phrase)
+			} else {
+				query.Phrases = append(query.Phrases, phrase)
+			}
+			continue
+		}
+
+		end := i
+		for end < len(chars) && !unicode.IsSpace(chars[end]) && chars[end] != '"' {
+			end++
+		}
+
+		term := string(chars[i:end])
+		i = end
+
+		if len(Words(term)) == 0 {
+			continue
+		}
+
+		if excluded {
+			query.ExcludedTerms = append(query.ExcludedTerms, term)
+		} else {
+			query.Terms = append(query.Terms, term)
+		}
+	}
+
+	return &query
+
+}
+
+/*
+Merge adds all parts of the other query.
+*/
+func (query *TextQuery) Merge(other *TextQuery) {
+	query.Terms = append(query.Terms, other.Terms...)
+	query.Phrases = append(query.Phrases, other.Phrases...)
+	query.ExcludedTerms = append(query.ExcludedTerms, other.ExcludedTerms...)
+	query.ExcludedPhrases = append(query.ExcludedPhrases, other.ExcludedPhrases...)
+}
+
+func (query *TextQuery) IsEmpty() bool {
+	return !query.HasPositiveParts() && len(query.ExcludedTerms) == 0 && len(query.ExcludedPhrases) == 0
+}
+
+/*
+HasPositiveParts reports whether the query contains terms or phrases, which is required to search
+the text index. Queries that only exclude something match every document without these words.
+*/
+func (query *TextQuery) HasPositiveParts() bool {
+	return len(query.Terms) > 0 || len(query.Phrases) > 0
+}
+
+/*
+MongoSearch creates the $search string of a $text query from the terms, phrases and excluded terms.
+Excluded phrases are not part of it, since they have to be filtered with PhraseRegex.
+*/
+func (query *TextQuery) MongoSearch() string {
+
+	parts := []string{}
+
+	for _, phrase := range query.Phrases {
+		parts = append(parts, "\""+phrase+"\"")
+	}
+
+	parts = append(parts, query.Terms...)
+
+	for _, term := range query.ExcludedTerms {
+		parts = append(parts, "-"+term)
+	}
+
+	return strings.Join(parts, " ")
+
+}
+
+/*
+PhraseRegex creates a regex, to be used case insensitive, that matches the words of the phrase in this order,
+separated by anything but letters and digits, so "perceive, reason" also matches "Perceive; reason".
+*/
+func PhraseRegex(phrase string) string {
+
+	words := Words(phrase)
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+
+	return `(?<![\p{L}\p{N}])` + strings.Join(words, `[^\p{L}\p{N}]+`) + `(?![\p{L}\p{N}])`
+
+}
+
+/*
+Words splits the text into its words, dropping punctuation and whitespace.
+*/
+func Words(text string) []string {
+
+	return strings.FieldsFunc(text, func(char rune) bool {
+		return !unicode.IsLetter(char) && !unicode.IsNumber(char)
+	})
+
+}
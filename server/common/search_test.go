@@ -0,0 +1,81 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTextQuery(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected TextQuery
+	}{
+		{"", TextQuery{}},
+		{"perception", TextQuery{Terms: []string{"perception"}}},
+		{"  perception   reason ", TextQuery{Terms: []string{"perception", "reason"}}},
+		{`"artificial intelligence"`, TextQuery{Phrases: []string{"artificial intelligence"}}},
+		{`"  artificial   intelligence "`, TextQuery{Phrases: []string{"artificial intelligence"}}},
+		{`perception "artificial intelligence" -philosophy`, TextQuery{Terms: []string{"perception"}, Phrases: []string{"artificial intelligence"}, ExcludedTerms: []string{"philosophy"}}},
+		{`-"machine learning" mind`, TextQuery{Terms: []string{"mind"}, ExcludedPhrases: []string{"machine learning"}}},
+		{`"unterminated phrase`, TextQuery{Phrases: []string{"unterminated phrase"}}},
+		{`mind"body"`, TextQuery{Terms: []string{"mind"}, Phrases: []string{"body"}}},
+		{`"" - -- ...`, TextQuery{}},
+		{`-"" -philosophy`, TextQuery{ExcludedTerms: []string{"philosophy"}}},
+		{"self-awareness", TextQuery{Terms: []string{"self-awareness"}}},
+	}
+
+	for _, test := range tests {
+
+		query := ParseTextQuery(test.input)
+
+		if !reflect.DeepEqual(*query, test.expected) {
+			t.Errorf("ParseTextQuery(%q) = %+v, expected %+v", test.input, *query, test.expected)
+		}
+	}
+
+}
+
+func TestTextQueryMongoSearch(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected string
+		positive bool
+	}{
+		{`perception "artificial intelligence" -philosophy`, `"artificial intelligence" perception -philosophy`, true},
+		{`-"machine learning" -philosophy`, `-philosophy`, false},
+		{`"mind"`, `"mind"`, true},
+		{``, ``, false},
+	}
+
+	for _, test := range tests {
+
+		query := ParseTextQuery(test.input)
+
+		if search := query.MongoSearch(); search != test.expected || query.HasPositiveParts() != test.positive {
+			t.Errorf("ParseTextQuery(%q).MongoSearch() = %q, %v, expected %q, %v", test.input, search, query.HasPositiveParts(), test.expected, test.positive)
+		}
+	}
+
+}
+
+func TestPhraseRegex(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"perceive reason", `(?<![\p{L}\p{N}])perceive[^\p{L}\p{N}]+reason(?![\p{L}\p{N}])`},
+		{"perceive, reason!", `(?<![\p{L}\p{N}])perceive[^\p{L}\p{N}]+reason(?![\p{L}\p{N}])`},
+		{"c++ 2.0", `(?<![\p{L}\p{N}])c[^\p{L}\p{N}]+2[^\p{L}\p{N}]+0(?![\p{L}\p{N}])`},
+	}
+
+	for _, test := range tests {
+
+		if regex := PhraseRegex(test.input); regex != test.expected {
+			t.Errorf("PhraseRegex(%q) = %q, expected %q", test.input, regex, test.expected)
+		}
+	}
+
+}
@@ -0,0 +1,134 @@
+package common
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+/*
+FoldText lowercases the text and removes diacritics ("Müller" -> "muller"),
+so that names and titles can be compared independent of their spelling.
+*/
+func FoldText(text string) string {
+
+	transformer := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(transformer, text)
+
+	if err != nil {
+		folded = text
+	}
+
+	return strings.ToLower(folded)
+
+}
+
+/*
+NormalizeText folds the text and replaces every character that is not a letter or digit with a single space.
+*/
+func NormalizeText(text string) string {
+
+	folded := FoldText(text)
+
+	words := strings.FieldsFunc(folded, func(char rune) bool {
+		return !unicode.IsLetter(char) && !unicode.IsNumber(char)
+	})
+
+	return strings.Join(words, " ")
+
+}
+
+/*
+Trigrams returns the set of character trigrams of the normalized text. Every word is padded with
+spaces, so that short words and word boundaries contribute to the similarity as well.
+*/
+func Trigrams(text string) map[string]bool {
+
+	trigrams := map[string]bool{}
+
+	for _, word := range strings.Fields(NormalizeText(text)) {
+
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			trigrams[string(padded[i:i+3])] = true
+		}
+
+	}
+
+	return trigrams
+
+}
+
+/*
+PrefixTrigrams returns the trigrams of the text like Trigrams, except that the last word is not padded at its end.
+This way an incomplete word that is still being typed ("percep") is fully contained in the words it starts ("perception").
+*/
+func PrefixTrigrams(text string) map[string]bool {
+
+	trigrams := map[string]bool{}
+	words := strings.Fields(NormalizeText(text))
+
+	for i, word := range words {
+
+		padded := "  " + word + " "
+		if i == len(words)-1 {
+			padded = "  " + word
+		}
+
+		chars := []rune(padded)
+		for j := 0; j+3 <= len(chars); j++ {
+			trigrams[string(chars[j:j+3])] = true
+		}
+
+	}
+
+	return trigrams
+
+}
+
+/*
+TrigramSimilarity returns the Jaccard similarity (0..1) of the trigram sets of both texts.
+*/
+func TrigramSimilarity(a string, b string) float64 {
+
+	trigramsA := Trigrams(a)
+	trigramsB := Trigrams(b)
+
+	if len(trigramsA) == 0 && len(trigramsB) == 0 {
+		return 1
+	}
+
+	shared := 0
+	for trigram := range trigramsA {
+		if trigramsB[trigram] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(trigramsA)+len(trigramsB)-shared)
+
+}
+
+var transliterations = strings.NewReplacer(
+	"ä", "ae", "ö", "oe", "ü", "ue", "Ä", "Ae", "Ö", "Oe", "Ü", "Ue", "ß", "ss",
+	"æ", "ae", "Æ", "Ae", "ø", "o", "Ø", "O", "œ", "oe", "Œ", "Oe", "ł", "l", "Ł", "L", "đ", "d", "Đ", "D", "þ", "th", "Þ", "Th",
+)
+
+/*
+Slugify creates an URL-safe, lowercase ASCII slug ("Jürgen Müller" -> "juergen-mueller").
+German umlauts are transliterated, other diacritics are removed.
+*/
+func Slugify(text string) string {
+
+	folded := FoldText(transliterations.Replace(norm.NFC.String(text)))
+
+	words := strings.FieldsFunc(folded, func(char rune) bool {
+		return !(char >= 'a' && char <= 'z') && !(char >= '0' && char <= '9')
+	})
+
+	return strings.Join(words, "-")
+
+}
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func sortedTrigrams(trigrams map[string]bool) []string {
+
+	sorted := []string{}
+	for trigram := range trigrams {
+		sorted = append(sorted, trigram)
+	}
+
+	sort.Strings(sorted)
+	return sorted
+
+}
+
+func TestTrigrams(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"", []string{}},
+		{"a", []string{"  a", " a "}},
+		{"Mind", []string{"  m", " mi", "ind", "min", "nd "}},
+		{"Über", []string{"  u", " ub", "ber", "er ", "ube"}},
+		{"ai, ai!", []string{"  a", " ai", "ai "}},
+		{"to be", []string{"  b", "  t", " be", " to", "be ", "to "}},
+	}
+
+	for _, test := range tests {
+
+		if trigrams := sortedTrigrams(Trigrams(test.input)); !reflect.DeepEqual(trigrams, test.expected) {
+			t.Errorf("Trigrams(%q) = %q, expected %q", test.input, trigrams, test.expected)
+		}
+	}
+
+}
+
+func TestPrefixTrigrams(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"", []string{}},
+		{"Mi", []string{"  m", " mi"}},
+		{"min", []string{"  m", " mi", "min"}},
+		{"to b", []string{"  b", "  t", " to", "to "}},
+	}
+
+	for _, test := range tests {
+
+		if trigrams := sortedTrigrams(PrefixTrigrams(test.input)); !reflect.DeepEqual(trigrams, test.expected) {
+			t.Errorf("PrefixTrigrams(%q) = %q, expected %q", test.input, trigrams, test.expected)
+		}
+	}
+
+	for prefix, word := range map[string]string{"percep": "perception", "Intelli": "intelligence", "Über": "überlegung"} {
+		wordTrigrams := Trigrams(word)
+		for trigram := range PrefixTrigrams(prefix) {
+			if !wordTrigrams[trigram] {
+				t.Errorf("PrefixTrigrams(%q) contains %q, which is not a trigram of %q", prefix, trigram, word)
+			}
+		}
+	}
+
+}
+
+func TestTrigramSimilarity(t *testing.T) {
+
+	tests := []struct {
+		a        string
+		b        string
+		expected float64
+	}{
+		{"", "", 1},
+		{"mind", "", 0},
+		{"mind", "Mind!", 1},
+		{"mind", "body", 0},
+		{"mind", "mint", 3.0 / 7.0},
+		{"perception", "perceptoin", 7.0 / 15.0},
+	}
+
+	for _, test := range tests {
+
+		similarity := TrigramSimilarity(test.a, test.b)
+		reversed := TrigramSimilarity(test.b, test.a)
+
+		if math.Abs(similarity-test.expected) > 1e-9 || similarity != reversed {
+			t.Errorf("TrigramSimilarity(%q, %q) = %v, %v reversed, expected %v", test.a, test.b, similarity, reversed, test.expected)
+		}
+	}
+
+}
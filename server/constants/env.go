@@ -3,4 +3,32 @@ package constants
 const (
 	EnvKeyMongoDBUrl = "MONGODB_URL"
 	EnvKeyRestPort   = "REST_PORT"
+
+	EnvKeyApiAliasVersion      = "API_ALIAS_VERSION"
+	EnvKeyApiV1DeprecationDate = "API_V1_DEPRECATION_DATE"
+	EnvKeyApiV1SunsetDate      = "API_V1_SUNSET_DATE"
+
+	EnvKeyNotificationDigestInterval = "NOTIFICATION_DIGEST_INTERVAL"
+
+	EnvKeyMailDriver    = "MAIL_DRIVER"
+	EnvKeyMailFrom      = "MAIL_FROM"
+	EnvKeyMailDirectory = "MAIL_DIRECTORY"
+	EnvKeySMTPHost      = "SMTP_HOST"
+	EnvKeySMTPPort      = "SMTP_PORT"
+	EnvKeySMTPUsername  = "SMTP_USERNAME"
+	EnvKeySMTPPassword  = "SMTP_PASSWORD"
+	EnvKeySMTPSecurity  = "SMTP_SECURITY"
+
+	EnvKeyPublicBaseUrl = "PUBLIC_BASE_URL"
+
+	EnvKeyRequireVerifiedEmail = "REQUIRE_VERIFIED_EMAIL"
+
+	EnvKeyPasswordHasher     = "PASSWORD_HASHER"
+	EnvKeyLegacyLoginEnabled = "LEGACY_LOGIN_ENABLED"
+
+	EnvKeySessionIdleTimeout = "SESSION_IDLE_TIMEOUT"
+	EnvKeySessionMaxAge      = "SESSION_MAX_AGE"
+
+	EnvKeyAccessTokenKeys     = "ACCESS_TOKEN_KEYS"
+	EnvKeyAccessTokenLifetime = "ACCESS_TOKEN_LIFETIME"
 )
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"yacoid_server/common"
+	"yacoid_server/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AuditActionDefinitionApprove    = "definition.approve"
+	AuditActionDefinitionReject     = "definition.reject"
+	AuditActionAuthorApprove        = "author.approve"
+	AuditActionAuthorReject         = "author.reject"
+	AuditActionAuthorMerge          = "author.merge"
+	AuditActionSourceApprove        = "source.approve"
+	AuditActionSourceReject         = "source.reject"
+	AuditActionSourceMerge          = "source.merge"
+	AuditActionPasswordResetRequest = "user.password_reset_request"
+	AuditActionPasswordReset        = "user.password_reset"
+	AuditActionPasswordChange       = "user.password_change"
+	AuditActionEmailChange          = "user.email_change"
+	AuditActionEmailVerify          = "user.email_verify"
+	AuditActionSessionRevoke        = "user.session_revoke"
+	AuditActionRefreshTokenReuse    = "user.refresh_token_reuse"
+	AuditActionUserDelete           = "user.delete"
+)
+
+const (
+	AuditTargetDefinition = "definition"
+	AuditTargetAuthor     = "author"
+	AuditTargetSource     = "source"
+	AuditTargetUser       = "user"
+)
+
+const maxAuditPageSize = 100
+
+/*
+AuditClient identifies the client a privileged request came from.
+*/
+type AuditClient struct {
+	IP        string
+	UserAgent string
+}
+
+/*
+AuditEntry records a privileged or account-security operation. The audit log is append-only: entries are only ever
+inserted, there is no code path that updates or deletes them, and they survive the deletion of the acting user.
+Before and After only contain the changed fields and never password hashes or tokens.
+*/
+type AuditEntry struct {
+	ID         primitive.ObjectID  `bson:"_id" json:"id"`
+	Date       time.Time           `bson:"date" json:"date"`
+	ActorID    *primitive.ObjectID `bson:"actor_id,omitempty" json:"actorId,omitempty"`
+	Action     string              `bson:"action" json:"action"`
+	TargetType string              `bson:"target_type" json:"targetType"`
+	TargetID   primitive.ObjectID  `bson:"target_id" json:"targetId"`
+	Before     bson.M              `bson:"before,omitempty" json:"before,omitempty"`
+	After      bson.M              `bson:"after,omitempty" json:"after,omitempty"`
+	IP         string              `bson:"ip" json:"ip"`
+	UserAgent  string              `bson:"user_agent" json:"userAgent"`
+}
+
+func createAuditEntry(client *AuditClient, actorId *primitive.ObjectID, action string, targetType string, targetId primitive.ObjectID, before bson.M, after bson.M) *AuditEntry {
+
+	entry := AuditEntry{
+		ID:         primitive.NewObjectID(),
+		Date:       time.Now(),
+		ActorID:    actorId,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetId,
+		Before:     before,
+		After:      after,
+	}
+
+	if client != nil {
+		entry.IP = client.IP
+		entry.UserAgent = client.UserAgent
+	}
+
+	return &entry
+
+}
+
+/*
+insertAuditEntry writes the entry with the given context, so that it can be part of the transaction of the operation.
+*/
+func insertAuditEntry(context context.Context, entry *AuditEntry) error {
+
+	_, err := auditLogCollection.InsertOne(context, entry)
+	return err
+
+}
+
+/*
+recordAudit writes the entry of an operation that already succeeded. The operation cannot be undone anymore,
+so a failed write is logged instead of failing the request.
+*/
+func recordAudit(entry *AuditEntry) {
+
+	if err := insertAuditEntry(dbContext, entry); err != nil {
+		fmt.Println("Could not write audit log:", err, entry.Action, entry.TargetID.Hex())
+	}
+
+}
+
+/*
+GetAuditLog returns the entries matching the request, newest first.
+*/
+func GetAuditLog(request *types.AuditLogRequest, authToken string) ([]*AuditEntry, error) {
+
+	_, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	if request.PageSize <= 0 || request.PageSize > maxAuditPageSize || request.Page <= 0 {
+		return nil, common.ErrorInvalidType
+	}
+
+	filter := bson.M{}
+
+	if request.ActorID != nil {
+
+		actorId, err := primitive.ObjectIDFromHex(*request.ActorID)
+
+		if err != nil {
+			return nil, InvalidID
+		}
+
+		filter["actor_id"] = actorId
+	}
+
+	if request.TargetID != nil {
+
+		targetId, err := primitive.ObjectIDFromHex(*request.TargetID)
+
+		if err != nil {
+			return nil, InvalidID
+		}
+
+		filter["target_id"] = targetId
+	}
+
+	if request.TargetType != nil {
+		filter["target_type"] = *request.TargetType
+	}
+
+	if request.Action != nil {
+		filter["action"] = *request.Action
+	}
+
+	date := bson.M{}
+
+	if request.From != nil {
+		date["$gte"] = *request.From
+	}
+
+	if request.To != nil {
+		date["$lt"] = *request.To
+	}
+
+	if len(date) > 0 {
+		filter["date"] = date
+	}
+
+	options := options.Find().
+		SetSort(bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((request.Page - 1) * request.PageSize)).
+		SetLimit(int64(request.PageSize))
+
+	cursor, err := auditLogCollection.Find(dbContext, filter, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	entries := []*AuditEntry{}
+
+	for cursor.Next(dbContext) {
+
+		entry := AuditEntry{}
+		err := cursor.Decode(&entry)
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+
+}
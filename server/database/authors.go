@@ -1,8 +1,11 @@
 package database
 
 import (
+	"errors"
 	"fmt"
-	"math/rand"
+	"math"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 	"yacoid_server/common"
@@ -11,50 +14,334 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func CreateAuthor(request *types.CreateAuthorRequest, authToken string) error {
+var ErrorAuthorMergeIntoItself = errors.New("AUTHOR_MERGE_INTO_ITSELF")
+var ErrorAuthorAlreadyExists = errors.New("AUTHOR_ALREADY_EXISTS")
+var ErrorAuthorSlugUnavailable = errors.New("AUTHOR_SLUG_UNAVAILABLE")
+var ErrorInvalidLifeDates = errors.New("INVALID_LIFE_DATES")
+
+const maxSlugAttempts = 100
+
+/*
+CreateAuthor inserts a new author with a readable, unique slug ("mueller-juergen", "mueller-juergen-2", ...).
+*/
+func CreateAuthor(request *types.CreateAuthorRequest, authToken string) (*types.Author, error) {
 
 	user, userError := GetUserByAuthToken(authToken)
 
 	if userError != nil {
-		return userError
+		return nil, userError
 	}
 
 	var author types.Author
 
+	now := time.Now()
 	author.ID = primitive.NewObjectID()
-	author.SlugId = fmt.Sprintf("%s-%s-%08d", strings.ToLower(request.LastName), strings.ToLower(request.FirstName), rand.Intn(10000000))
 	author.SubmittedBy = user.ID
-	author.SubmittedDate = time.Now()
-	author.FirstName = request.FirstName
-	author.LastName = request.LastName
+	author.SubmittedDate = now
+	author.LastSubmitChangeDate = now
+	author.RejectionLog = []*types.Rejection{}
+
+	/* authors submitted by admins do not need to be moderated */
+	if user.Admin {
+		author.Approved = true
+		author.ApprovedBy = &user.ID
+		author.ApprovedDate = &now
+	}
+	author.FirstName = strings.TrimSpace(request.FirstName)
+	author.LastName = strings.TrimSpace(request.LastName)
+	author.NameVariants = cleanNameVariants(request.NameVariants)
+	author.BirthYear = request.BirthYear
+	author.DeathYear = request.DeathYear
+
+	if request.ORCID != nil && len(strings.TrimSpace(*request.ORCID)) > 0 {
+		orcid, orcidError := common.NormalizeORCID(*request.ORCID)
+		if orcidError != nil {
+			return nil, orcidError
+		}
+		author.ORCID = &orcid
+	}
 
-	_, err := authorsCollection.InsertOne(dbContext, author)
+	if request.WikidataID != nil && len(strings.TrimSpace(*request.WikidataID)) > 0 {
+		wikidataId, wikidataError := common.NormalizeWikidataID(*request.WikidataID)
+		if wikidataError != nil {
+			return nil, wikidataError
+		}
+		author.WikidataID = &wikidataId
+	}
+
+	if !hasValidLifeDates(&author) {
+		return nil, ErrorInvalidLifeDates
+	}
+
+	author.SearchNames = createAuthorSearchNames(&author)
+
+	err := storeWithUniqueSlug(&author, func(slug string) error {
+
+		author.SlugId = slug
+		_, insertError := authorsCollection.InsertOne(dbContext, author)
+		return insertError
+
+	})
 
 	if err != nil {
-		return err
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrorAuthorAlreadyExists
+		}
+		return nil, err
+	}
+
+	if author.Approved {
+		updateSearchIndex(func() error {
+			return refreshIndexedAuthors([]primitive.ObjectID{author.ID})
+		})
+	}
+	publishWebhookEvent(WebhookEventAuthorCreated, author)
+
+	return &author, nil
+
+}
+
+/*
+storeWithUniqueSlug passes the readable slugs of the author ("mueller-juergen", "mueller-juergen-2", ...) to store
+until one of them is stored without colliding with the slug of another author.
+*/
+func storeWithUniqueSlug(author *types.Author, store func(slug string) error) error {
+
+	baseSlug := common.Slugify(author.LastName + " " + author.FirstName)
+	if len(baseSlug) == 0 {
+		baseSlug = "author"
+	}
+
+	for attempt := 1; attempt <= maxSlugAttempts; attempt++ {
+
+		slug := baseSlug
+		if attempt > 1 {
+			slug = fmt.Sprintf("%s-%d", baseSlug, attempt)
+		}
+
+		/* slugs of merged authors are still taken */
+		_, findError := GetAuthorBySlug(slug)
+
+		if findError == nil {
+			continue
+		} else if findError != common.ErrorNotFound {
+			return findError
+		}
+
+		err := store(slug)
+
+		/* only retry if another request took the slug in the meantime */
+		if !isDuplicateKeyErrorOnIndex(err, authorSlugIndex) {
+			return err
+		}
+	}
+
+	return ErrorAuthorSlugUnavailable
+
+}
+
+/* slugs generated before readable slugs were introduced: "<last name>-<first name>-<8 random digits>" */
+var legacySlugPattern = regexp.MustCompile(`^.+-.+-\d{8}$`)
+
+/*
+migrateAuthorSlugs replaces the random slugs of authors created before readable slugs were introduced,
+which may contain spaces or other characters that are not URL-safe. The old slugs are kept as merged slugs,
+so that existing links still resolve.
+*/
+func migrateAuthorSlugs() error {
+
+	authors, findError := getAuthors(bson.M{"slug_id": primitive.Regex{Pattern: legacySlugPattern.String()}}, nil)
+
+	if findError != nil {
+		return findError
+	}
+
+	for _, author := range authors {
+
+		legacySlug := author.SlugId
+
+		err := storeWithUniqueSlug(author, func(slug string) error {
+
+			update := bson.M{
+				"$set":      bson.M{"slug_id": slug},
+				"$addToSet": bson.M{"merged_slug_ids": legacySlug},
+			}
+
+			_, updateError := authorsCollection.UpdateByID(dbContext, author.ID, update)
+			return updateError
+
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+func cleanNameVariants(nameVariants []string) []string {
+
+	cleaned := []string{}
+
+	for _, nameVariant := range nameVariants {
+		nameVariant = strings.TrimSpace(nameVariant)
+		if len(nameVariant) > 0 {
+			cleaned = append(cleaned, nameVariant)
+		}
+	}
+
+	return cleaned
+
+}
+
+func hasValidLifeDates(author *types.Author) bool {
+	return author.BirthYear == nil || author.DeathYear == nil || *author.BirthYear <= *author.DeathYear
+}
+
+/*
+createAuthorSearchNames returns every known name of the author in normalized form (lowercase, no diacritics),
+so that searches match "Müller", "Mueller" and "Muller" alike.
+*/
+func createAuthorSearchNames(author *types.Author) []string {
+
+	names := []string{
+		author.FirstName + " " + author.LastName,
+		author.LastName + " " + author.FirstName,
+	}
+	names = append(names, author.NameVariants...)
+
+	searchNames := []string{}
+
+	for _, name := range names {
+		for _, searchName := range []string{common.NormalizeText(name), common.NormalizeText(common.Slugify(name))} {
+			if len(searchName) > 0 && !containsString(searchNames, searchName) {
+				searchNames = append(searchNames, searchName)
+			}
+		}
+	}
+
+	return searchNames
+
+}
+
+func containsString(values []string, value string) bool {
+
+	for _, current := range values {
+		if current == value {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+/*
+migrateAuthorSearchNames fills the search names of authors created before they were introduced.
+*/
+func migrateAuthorSearchNames() error {
+
+	authors, findError := getAuthors(bson.M{"search_names": bson.M{"$exists": false}}, nil)
+
+	if findError != nil {
+		return findError
+	}
+
+	for _, author := range authors {
+
+		update := bson.M{"$set": bson.M{"search_names": createAuthorSearchNames(author)}}
+		_, updateError := authorsCollection.UpdateByID(dbContext, author.ID, update)
+
+		if updateError != nil {
+			return updateError
+		}
 	}
 
 	return nil
 
 }
 
+/*
+FindAuthorsByName returns all authors whose name or one of its variants equals the given name, ignoring case and diacritics.
+*/
+func FindAuthorsByName(name string, authToken string) ([]*types.Author, error) {
+
+	normalizedName := common.NormalizeText(name)
+
+	if len(normalizedName) == 0 {
+		return []*types.Author{}, nil
+	}
+
+	filter := withVisibility(bson.M{"search_names": normalizedName}, GetOptionalUser(authToken))
+	return getAuthors(filter, nil)
+
+}
+
 func GetAuthorById(stringId string) (*types.Author, error) {
 
 	id, idError := primitive.ObjectIDFromHex(stringId)
 
 	if idError != nil {
-		return nil, idError
+		return nil, InvalidID
 	}
 
 	return GetAuthor(id)
 
 }
 
+/*
+GetAuthorByIdOrSlug resolves an author by its hex ID, its slug or a slug of an author that was merged into it.
+Authors that are not approved yet are only found by their submitter and admins.
+*/
+func GetAuthorByIdOrSlug(idOrSlug string, authToken string) (*types.Author, error) {
+
+	var author *types.Author
+	var findError error
+
+	id, idError := primitive.ObjectIDFromHex(idOrSlug)
+
+	if idError == nil {
+		author, findError = GetAuthor(id)
+	} else {
+		author, findError = GetAuthorBySlug(idOrSlug)
+	}
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	if !isVisibleTo(author.Approved, author.SubmittedBy, GetOptionalUser(authToken)) {
+		return nil, common.ErrorNotFound
+	}
+
+	return author, nil
+
+}
+
+func GetAuthorBySlug(slug string) (*types.Author, error) {
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"slug_id": slug},
+		bson.M{"merged_slug_ids": slug},
+	}}
+
+	return getAuthor(filter)
+
+}
+
 func GetAuthor(id primitive.ObjectID) (*types.Author, error) {
 
 	filter := bson.M{"_id": id}
+	return getAuthor(filter)
+
+}
+
+func getAuthor(filter interface{}) (*types.Author, error) {
 
 	result := authorsCollection.FindOne(dbContext, filter)
 
@@ -75,3 +362,416 @@ func GetAuthor(id primitive.ObjectID) (*types.Author, error) {
 	return &author, nil
 
 }
+
+func getAuthors(filter interface{}, options *options.FindOptions) ([]*types.Author, error) {
+
+	cursor, err := authorsCollection.Find(dbContext, filter, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	authors := []*types.Author{}
+
+	for cursor.Next(dbContext) {
+
+		author := types.Author{}
+		err := cursor.Decode(&author)
+
+		if err != nil {
+			return nil, err
+		}
+
+		authors = append(authors, &author)
+	}
+
+	return authors, nil
+
+}
+
+func createAuthorSearchQuery(search *string) bson.M {
+
+	if search == nil || len(strings.TrimSpace(*search)) == 0 {
+		return bson.M{}
+	}
+
+	query := bson.A{}
+
+	for _, word := range strings.Fields(common.NormalizeText(*search)) {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(word)}
+		query = append(query, bson.M{"search_names": pattern})
+	}
+
+	if len(query) == 0 {
+		return bson.M{}
+	}
+
+	return bson.M{"$and": query}
+
+}
+
+/*
+GetAuthors returns one page of authors sorted by name together with the total page count.
+Every word of the search has to match one of the names of the author, ignoring case and diacritics.
+*/
+func GetAuthors(pageSize int, page int, search *string, authToken string) ([]*types.Author, int64, error) {
+
+	if pageSize <= 0 || pageSize > maxPageSize || page <= 0 {
+		return nil, 0, common.ErrorInvalidType
+	}
+
+	filter := withVisibility(createAuthorSearchQuery(search), GetOptionalUser(authToken))
+
+	options := options.Find()
+	options.SetSort(bson.D{{Key: "last_name", Value: 1}, {Key: "first_name", Value: 1}})
+	options.SetLimit(int64(pageSize))
+	options.SetSkip(int64((page - 1) * pageSize))
+
+	authors, findError := getAuthors(filter, options)
+
+	if findError != nil {
+		return nil, 0, findError
+	}
+
+	count, countError := authorsCollection.CountDocuments(dbContext, filter)
+
+	if countError != nil {
+		return nil, 0, countError
+	}
+
+	pageCount := int64(math.Ceil(float64(count) / float64(pageSize)))
+
+	return authors, pageCount, nil
+
+}
+
+/*
+UpdateAuthor changes the given fields of an author. Only the submitter of the author or an admin is allowed to do so.
+The slug is kept, so that existing links stay valid. Empty ORCID or Wikidata values remove the identifier.
+*/
+func UpdateAuthor(request *types.UpdateAuthorRequest, authToken string) (*types.Author, error) {
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	author, findError := GetAuthorById(request.ID)
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	if author.SubmittedBy != user.ID && user.Admin == false {
+		return nil, ErrorNotEnoughPermissions
+	}
+
+	/* approved authors can only be changed by admins, otherwise the moderation could be bypassed */
+	if author.Approved && user.Admin == false {
+		return nil, ErrorAuthorAlreadyApproved
+	}
+
+	if author.SubmittedBy == user.ID {
+		author.LastSubmitChangeDate = time.Now()
+	}
+
+	if request.FirstName != nil {
+		author.FirstName = strings.TrimSpace(*request.FirstName)
+	}
+
+	if request.LastName != nil {
+		author.LastName = strings.TrimSpace(*request.LastName)
+	}
+
+	if request.NameVariants != nil {
+		author.NameVariants = cleanNameVariants(*request.NameVariants)
+	}
+
+	if request.BirthYear != nil {
+		author.BirthYear = request.BirthYear
+	}
+
+	if request.DeathYear != nil {
+		author.DeathYear = request.DeathYear
+	}
+
+	if request.ORCID != nil {
+		if len(strings.TrimSpace(*request.ORCID)) == 0 {
+			author.ORCID = nil
+		} else {
+			orcid, orcidError := common.NormalizeORCID(*request.ORCID)
+			if orcidError != nil {
+				return nil, orcidError
+			}
+			author.ORCID = &orcid
+		}
+	}
+
+	if request.WikidataID != nil {
+		if len(strings.TrimSpace(*request.WikidataID)) == 0 {
+			author.WikidataID = nil
+		} else {
+			wikidataId, wikidataError := common.NormalizeWikidataID(*request.WikidataID)
+			if wikidataError != nil {
+				return nil, wikidataError
+			}
+			author.WikidataID = &wikidataId
+		}
+	}
+
+	if !hasValidLifeDates(author) {
+		return nil, ErrorInvalidLifeDates
+	}
+
+	author.SearchNames = createAuthorSearchNames(author)
+
+	_, updateError := authorsCollection.ReplaceOne(dbContext, bson.M{"_id": author.ID}, author)
+
+	if updateError != nil {
+		if mongo.IsDuplicateKeyError(updateError) {
+			return nil, ErrorAuthorAlreadyExists
+		}
+		return nil, updateError
+	}
+
+	updateSearchIndex(func() error {
+		return refreshIndexedAuthors([]primitive.ObjectID{author.ID})
+	})
+
+	return author, nil
+
+}
+
+/*
+MergeAuthors merges the given authors into the target author. Every source referencing one of the merged
+authors is rewritten to reference the target instead, and the slugs of the merged authors keep resolving to the target.
+*/
+func MergeAuthors(targetId string, authorIds []string, authToken string, client *AuditClient) (*types.Author, error) {
+
+	user, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	targetObjectId, targetIdError := primitive.ObjectIDFromHex(targetId)
+
+	if targetIdError != nil {
+		return nil, InvalidID
+	}
+
+	ids, idError := stringsToObjectIDs(&authorIds)
+
+	if idError != nil {
+		return nil, InvalidID
+	}
+
+	for _, id := range ids {
+		if id == targetObjectId {
+			return nil, ErrorAuthorMergeIntoItself
+		}
+	}
+
+	var mergedSlugIds []string
+
+	mergeError := runInTransaction(func(sessionContext mongo.SessionContext) error {
+
+		/* read inside the transaction, so that slugs of concurrently merged authors are not lost */
+		targetCount, countError := authorsCollection.CountDocuments(sessionContext, bson.M{"_id": targetObjectId})
+
+		if countError != nil {
+			return countError
+		}
+
+		if targetCount == 0 {
+			return common.ErrorNotFound
+		}
+
+		cursor, findError := authorsCollection.Find(sessionContext, bson.M{"_id": bson.M{"$in": ids}})
+
+		if findError != nil {
+			return findError
+		}
+
+		authors := []*types.Author{}
+
+		if err := cursor.All(sessionContext, &authors); err != nil {
+			return err
+		}
+
+		if len(authors) != len(ids) {
+			return common.ErrorNotFound
+		}
+
+		mergedSlugIds = []string{}
+
+		for _, author := range authors {
+			mergedSlugIds = append(mergedSlugIds, author.SlugId)
+			mergedSlugIds = append(mergedSlugIds, author.MergedSlugIds...)
+		}
+
+		/* two steps, because $addToSet and $pull cannot modify the same field in one update */
+		_, addError := sourcesCollection.UpdateMany(sessionContext,
+			bson.M{"authors": bson.M{"$in": ids}},
+			bson.M{"$addToSet": bson.M{"authors": targetObjectId}},
+		)
+
+		if addError != nil {
+			return addError
+		}
+
+		_, pullError := sourcesCollection.UpdateMany(sessionContext,
+			bson.M{"authors": bson.M{"$in": ids}},
+			bson.M{"$pull": bson.M{"authors": bson.M{"$in": ids}}},
+		)
+
+		if pullError != nil {
+			return pullError
+		}
+
+		update := bson.M{"$addToSet": bson.M{"merged_slug_ids": bson.M{"$each": mergedSlugIds}}}
+		_, updateError := authorsCollection.UpdateByID(sessionContext, targetObjectId, update)
+
+		if updateError != nil {
+			return updateError
+		}
+
+		_, deleteError := authorsCollection.DeleteMany(sessionContext, bson.M{"_id": bson.M{"$in": ids}})
+		return deleteError
+
+	})
+
+	if mergeError != nil {
+		return nil, mergeError
+	}
+
+	recordAudit(createAuditEntry(client, &user.ID, AuditActionAuthorMerge, AuditTargetAuthor, targetObjectId, nil, bson.M{"mergedAuthorIds": ids, "mergedSlugIds": mergedSlugIds}))
+
+	updateSearchIndex(func() error {
+		return refreshIndexedAuthors(append(ids, targetObjectId))
+	})
+
+	return GetAuthor(targetObjectId)
+
+}
+
+type CoAuthor struct {
+	Author *types.Author `json:"author"`
+	Count  int           `json:"count"`
+}
+
+type AuthorProfile struct {
+	Author      *types.Author            `json:"author"`
+	Sources     []*types.PopulatedSource `json:"sources"`
+	Definitions []*Definition            `json:"definitions"`
+	CoAuthors   []*CoAuthor              `json:"coAuthors"`
+}
+
+/*
+DeleteAuthor deletes an author as long as no source lists it. Otherwise the referencing sources
+are returned together with ErrorDeletionBlocked.
+*/
+func DeleteAuthor(stringId string, authToken string) ([]*Blocker, error) {
+
+	_, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	author, findError := GetAuthorById(stringId)
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	blockers, deleteError := deleteIfUnreferenced(authorsCollection, author.ID, findAuthorBlockers)
+
+	if deleteError != nil {
+		return blockers, deleteError
+	}
+
+	updateSearchIndex(func() error {
+		return refreshIndexedAuthors([]primitive.ObjectID{author.ID})
+	})
+
+	return nil, nil
+
+}
+
+/*
+GetAuthorProfile collects everything shown on an author page: the author, all sources the author contributed to,
+the approved definitions drawn from those sources and the co-authors ordered by the number of shared sources.
+*/
+func GetAuthorProfile(slug string) (*AuthorProfile, error) {
+
+	author, findError := GetAuthorBySlug(slug)
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	if !author.Approved {
+		return nil, common.ErrorNotFound
+	}
+
+	sortOptions := options.Find().SetSort(bson.D{{Key: "year", Value: -1}, {Key: "title", Value: 1}})
+	sources, sourcesError := getSources(bson.M{"authors": author.ID, "approved": true}, sortOptions)
+
+	if sourcesError != nil {
+		return nil, sourcesError
+	}
+
+	populatedSources, populateError := PopulateSources(sources, nil)
+
+	if populateError != nil {
+		return nil, populateError
+	}
+
+	sourceIds := []primitive.ObjectID{}
+	for _, source := range sources {
+		sourceIds = append(sourceIds, source.ID)
+	}
+
+	definitionOptions := options.Find().SetSort(bson.M{"approved_date": -1})
+	definitions, definitionsError := getDefinitions(bson.M{"source": bson.M{"$in": sourceIds}, "approved": true}, definitionOptions)
+
+	if definitionsError != nil {
+		return nil, definitionsError
+	}
+
+	coAuthorsById := map[primitive.ObjectID]*CoAuthor{}
+	coAuthors := []*CoAuthor{}
+
+	for _, source := range populatedSources {
+		for _, coAuthor := range source.Authors {
+
+			if coAuthor.ID == author.ID || !coAuthor.Approved {
+				continue
+			}
+
+			entry, exists := coAuthorsById[coAuthor.ID]
+			if !exists {
+				entry = &CoAuthor{Author: coAuthor}
+				coAuthorsById[coAuthor.ID] = entry
+				coAuthors = append(coAuthors, entry)
+			}
+			entry.Count++
+		}
+	}
+
+	sort.SliceStable(coAuthors, func(i, j int) bool {
+		return coAuthors[i].Count > coAuthors[j].Count
+	})
+
+	return &AuthorProfile{
+		Author:      author,
+		Sources:     populatedSources,
+		Definitions: definitions,
+		CoAuthors:   coAuthors,
+	}, nil
+
+}
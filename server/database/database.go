@@ -22,9 +22,22 @@ var definitionsCollection *mongo.Collection
 var userCollection *mongo.Collection
 var authorsCollection *mongo.Collection
 var sourcesCollection *mongo.Collection
+var savedSearchesCollection *mongo.Collection
+var notificationsCollection *mongo.Collection
+var webhooksCollection *mongo.Collection
+var webhookDeliveriesCollection *mongo.Collection
+var auditLogCollection *mongo.Collection
+var mailOutboxCollection *mongo.Collection
+var sessionsCollection *mongo.Collection
 
 var InvalidID = errors.New("INVALID_ID")
 
+/* upper bound of the page size of all paginated lists */
+const maxPageSize = 100
+
+const authorSlugIndex = "slug_id_1"
+const userEmailIndex = "email_1"
+
 var ErrorUserNotFound = errors.New("USER_NOT_FOUND")
 var ErrorDefinitionNotFound = errors.New("DEFINITION_NOT_FOUND")
 var ErrorNotEnoughPermissions = errors.New("NOT_ENOUGH_PERMISSIONS")
@@ -36,10 +49,10 @@ func Connect() error {
 	dbContext = context.TODO()
 	databaseURL := os.Getenv(constants.EnvKeyMongoDBUrl)
 
-	options := options.Client().ApplyURI(databaseURL)
+	clientOptions := options.Client().ApplyURI(databaseURL)
 
 	var err error
-	client, err = mongo.Connect(dbContext, options)
+	client, err = mongo.Connect(dbContext, clientOptions)
 	if err != nil {
 		fmt.Println("Could not connect to database:")
 		return err
@@ -54,6 +67,13 @@ func Connect() error {
 	}
 
 	fmt.Println("Successfully connected to database!")
+
+	err = detectTransactionSupport()
+
+	if err != nil {
+		fmt.Println("Could not detect transaction support:")
+		return err
+	}
 	database = client.Database("YACOID")
 
 	database.CreateCollection(dbContext, "definitions")
@@ -65,13 +85,204 @@ func Connect() error {
 
 	database.CreateCollection(dbContext, "user")
 	userCollection = database.Collection("user")
+	_, err = userCollection.Indexes().CreateOne(dbContext, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName(userEmailIndex),
+	})
+
+	if err != nil {
+		fmt.Println("Could not create the unique email index, is an email address used by several users?")
+		return err
+	}
+
+	err = migrateEmailVerification()
+
+	if err != nil {
+		fmt.Println("Could not migrate users:")
+		return err
+	}
+
+	sessionsCollection = database.Collection("sessions")
+	sessionsCollection.Indexes().CreateMany(dbContext, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expiry_date", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			Keys: bson.D{{Key: "used_token_hashes", Value: 1}},
+		},
+	})
+
+	err = migrateAuthTokens()
+
+	if err != nil {
+		fmt.Println("Could not migrate auth tokens:")
+		return err
+	}
 
 	authorsCollection = database.Collection("authors")
+	_, err = authorsCollection.Indexes().CreateMany(dbContext, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "slug_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName(authorSlugIndex),
+		},
+		{
+			Keys:    bson.D{{Key: "orcid", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"orcid": bson.M{"$type": "string"}}),
+		},
+		{
+			Keys:    bson.D{{Key: "wikidata_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"wikidata_id": bson.M{"$type": "string"}}),
+		},
+		{
+			Keys: bson.D{{Key: "merged_slug_ids", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "search_names", Value: 1}},
+		},
+	})
+
+	if err != nil {
+		fmt.Println("Could not create the author indexes, is a slug, ORCID or Wikidata ID used by several authors?")
+		return err
+	}
+
 	sourcesCollection = database.Collection("sources")
+	_, err = sourcesCollection.Indexes().CreateMany(dbContext, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "isbn", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"isbn": bson.M{"$type": "string"}}),
+		},
+		{
+			Keys:    bson.D{{Key: "doi", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"doi": bson.M{"$type": "string"}}),
+		},
+	})
+
+	if err != nil {
+		fmt.Println("Could not create the source indexes, is an ISBN or DOI used by several sources?")
+		return err
+	}
+
+	err = migrateModerationState()
+
+	if err != nil {
+		fmt.Println("Could not migrate moderation state:")
+		return err
+	}
+
+	err = migrateAuthorSearchNames()
+
+	if err != nil {
+		fmt.Println("Could not migrate authors:")
+		return err
+	}
+
+	err = migrateAuthorSlugs()
+
+	if err != nil {
+		fmt.Println("Could not migrate author slugs:")
+		return err
+	}
+
+	savedSearchesCollection = database.Collection("saved_searches")
+	savedSearchesCollection.Indexes().CreateOne(dbContext, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+
+	notificationsCollection = database.Collection("notifications")
+	notificationsCollection.Indexes().CreateMany(dbContext, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_date", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "email_pending", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"email_pending": true}),
+		},
+	})
+
+	webhooksCollection = database.Collection("webhooks")
+	webhooksCollection.Indexes().CreateOne(dbContext, mongo.IndexModel{
+		Keys: bson.D{{Key: "events", Value: 1}},
+	})
+
+	webhookDeliveriesCollection = database.Collection("webhook_deliveries")
+	webhookDeliveriesCollection.Indexes().CreateMany(dbContext, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_date", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "next_attempt_date", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"status": "pending"}),
+		},
+	})
+
+	auditLogCollection = database.Collection("audit_log")
+	auditLogCollection.Indexes().CreateMany(dbContext, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "date", Value: -1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "date", Value: -1}}},
+		{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "date", Value: -1}}},
+	})
+
+	mailOutboxCollection = database.Collection("mail_outbox")
+	mailOutboxCollection.Indexes().CreateMany(dbContext, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "next_attempt_date", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"status": "pending"}),
+		},
+		{
+			Keys:    bson.D{{Key: "sent_date", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(sentMailRetention.Seconds())),
+		},
+		{
+			Keys:    bson.D{{Key: "dead_date", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(deadMailRetention.Seconds())),
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_date", Value: -1}},
+		},
+	})
+
+	err = migrateOutboxRetention()
+
+	if err != nil {
+		fmt.Println("Could not migrate mail outbox:")
+		return err
+	}
+
+	fmt.Println("Building search index...")
+	err = buildSearchIndex()
+
+	if err != nil {
+		fmt.Println("Could not build search index:")
+		return err
+	}
 
 	return nil
 }
 
+/*
+isDuplicateKeyErrorOnIndex reports whether the write failed because it violated the unique index with the given name.
+*/
+func isDuplicateKeyErrorOnIndex(err error, indexName string) bool {
+
+	var serverError mongo.ServerError
+
+	if !mongo.IsDuplicateKeyError(err) || !errors.As(err, &serverError) {
+		return false
+	}
+
+	return serverError.HasErrorCodeWithMessage(11000, " index: "+indexName+" ")
+
+}
+
 func hash(seed string) string {
 	data := []byte(seed)
 	return fmt.Sprintf("%x", sha256.Sum256(data))
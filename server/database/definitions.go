@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
 	"yacoid_server/common"
@@ -19,13 +20,6 @@ var ErrorDefinitionAlreadyApproved = errors.New("DEFINITION_ALREADY_APPROVED")
 var ErrorDefinitionRejectionNotAnsweredYet = errors.New("DEFINITION_REJECTION_NOT_ANSWERED_YET")
 var ErrorDefinitionRejectionBelongsToAnotherUser = errors.New("DEFINITION_REJECTION_BELONGS_TO_ANOTHER_USER")
 
-type Rejection struct {
-	ID           primitive.ObjectID `bson:"_id" json:"-"`
-	RejectedBy   primitive.ObjectID `bson:"rejected_by" json:"rejectedBy" validate:"required"`
-	RejectedDate time.Time          `bson:"rejected_date" json:"rejectedDate" validate:"required"`
-	Content      string             `bson:"content" json:"content" validate:"required"`
-}
-
 type Definition struct {
 	ID                   primitive.ObjectID  `bson:"_id" json:"id"`
 	SubmittedBy          primitive.ObjectID  `bson:"submitted_by" json:"submittedBy"`
@@ -34,7 +28,7 @@ type Definition struct {
 	ApprovedBy           *primitive.ObjectID `bson:"approved_by" json:"approvedBy"`
 	ApprovedDate         *time.Time          `bson:"approved_date" json:"approvedDate"`
 	Approved             bool                `bson:"approved" json:"approved"`
-	RejectionLog         *[]*Rejection       `bson:"rejection_log" json:"-"`
+	RejectionLog         *[]*types.Rejection `bson:"rejection_log" json:"-"`
 	Title                string              `bson:"title" json:"title"`
 	Content              string              `bson:"content" json:"content"`
 	Source               primitive.ObjectID  `bson:"source" json:"source"`
@@ -54,6 +48,10 @@ func SubmitDefinition(request *types.SubmitDefinitionRequest, authToken string)
 		return nil, userError
 	}
 
+	if !user.EmailVerified && requiresVerifiedEmail() {
+		return nil, ErrorEmailNotVerified
+	}
+
 	var definition Definition
 
 	now := time.Now()
@@ -73,18 +71,18 @@ func SubmitDefinition(request *types.SubmitDefinitionRequest, authToken string)
 	sourceId, sourceIdError := primitive.ObjectIDFromHex(request.Source)
 
 	if sourceIdError != nil {
-		return nil, sourceIdError
+		return nil, InvalidID
 	}
 
-	sourceExistsError := validateSourceExists(sourceId)
+	sourceUsableError := validateSourceUsable(sourceId, user)
 
-	if sourceExistsError != nil {
-		return nil, sourceExistsError
+	if sourceUsableError != nil {
+		return nil, sourceUsableError
 	}
 
 	definition.Source = sourceId
 
-	rejectionLog := []*Rejection{}
+	rejectionLog := []*types.Rejection{}
 	definition.RejectionLog = &rejectionLog
 
 	if definition.Tags == nil {
@@ -92,24 +90,23 @@ func SubmitDefinition(request *types.SubmitDefinitionRequest, authToken string)
 	}
 
 	_, err := definitionsCollection.InsertOne(dbContext, definition)
-	// TODO: send email to user??
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &definition, nil
+	sendSubmissionReceiptEmail(&definition)
+	publishWebhookEvent(WebhookEventDefinitionSubmitted, definition)
 
-}
-
-func validateSourceExists(id primitive.ObjectID) error {
-
-	_, err := GetSource(id)
-	return err
+	return &definition, nil
 
 }
 
-func ApproveDefinition(definitionId string, authToken string) error {
+/*
+ApproveDefinition publishes a definition. If its source or one of the authors is still pending, the approval fails
+with ErrorDefinitionHasPendingDependencies unless approveDependencies is set, which approves them in the same step.
+*/
+func ApproveDefinition(definitionId string, authToken string, approveDependencies bool, client *AuditClient) error {
 
 	definitionObjectId, definitionObjectIdError := primitive.ObjectIDFromHex(definitionId)
 
@@ -127,7 +124,37 @@ func ApproveDefinition(definitionId string, authToken string) error {
 		return ErrorNotEnoughPermissions
 	}
 
-	filter := bson.M{"_id": definitionObjectId}
+	definition, findError := GetDefinitionByObjectId(definitionObjectId)
+
+	if findError != nil {
+		return findError
+	}
+
+	if definition.Approved {
+		return ErrorDefinitionAlreadyApproved
+	}
+
+	pendingDependencies, dependenciesError := hasPendingDependencies(definition)
+
+	if dependenciesError != nil {
+		return dependenciesError
+	}
+
+	if pendingDependencies {
+
+		if !approveDependencies {
+			return ErrorDefinitionHasPendingDependencies
+		}
+
+		approveError := approveDefinitionDependencies(definition, user, client)
+
+		if approveError != nil {
+			return approveError
+		}
+	}
+
+	/* approved is part of the filter, so that a definition is only approved, announced and notified about once */
+	filter := bson.M{"_id": definitionObjectId, "approved": false}
 	update := bson.M{
 		"$set": bson.M{
 			"approved_by":   user.ID,
@@ -136,22 +163,35 @@ func ApproveDefinition(definitionId string, authToken string) error {
 		},
 	}
 
-	var result bson.M
-	updateError := definitionsCollection.FindOneAndUpdate(dbContext, filter, update, nil).Decode(&result)
-	// TODO: send email to user
+	var approvedDefinition Definition
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	updateError := definitionsCollection.FindOneAndUpdate(dbContext, filter, update, updateOptions).Decode(&approvedDefinition)
 
 	if updateError != nil {
 		if updateError == mongo.ErrNoDocuments {
-			return ErrorDefinitionNotFound
+			return ErrorDefinitionAlreadyApproved
 		}
 		return updateError
 	}
 
+	updateSearchIndex(func() error {
+		return refreshIndexedDefinitions(bson.M{"_id": definitionObjectId})
+	})
+
+	recordAudit(createAuditEntry(client, &user.ID, AuditActionDefinitionApprove, AuditTargetDefinition, definitionObjectId,
+		bson.M{"approved": false},
+		bson.M{"approved": true, "approvedBy": user.ID, "approvedDate": approvedDefinition.ApprovedDate},
+	))
+
+	sendDefinitionApprovedEmail(&approvedDefinition)
+	notifySavedSearches(definitionObjectId)
+	publishWebhookEvent(WebhookEventDefinitionApproved, approvedDefinition)
+
 	return nil
 
 }
 
-func RejectDefinition(definitionId string, authToken string, content string) error {
+func RejectDefinition(definitionId string, authToken string, content string, client *AuditClient) error {
 
 	definitionObjectId, definitionObjectIdError := primitive.ObjectIDFromHex(definitionId)
 
@@ -179,7 +219,7 @@ func RejectDefinition(definitionId string, authToken string, content string) err
 		return ErrorDefinitionAlreadyApproved
 	}
 
-	rejection := Rejection{
+	rejection := types.Rejection{
 		ID:           primitive.NewObjectID(),
 		RejectedBy:   user.ID,
 		RejectedDate: time.Now(),
@@ -206,7 +246,6 @@ func RejectDefinition(definitionId string, authToken string, content string) err
 	}
 
 	result := definitionsCollection.FindOneAndUpdate(dbContext, filter, update, nil)
-	// TODO: send email to user
 
 	if result.Err() != nil {
 		if result.Err() == mongo.ErrNoDocuments {
@@ -215,11 +254,19 @@ func RejectDefinition(definitionId string, authToken string, content string) err
 		return result.Err()
 	}
 
+	recordAudit(createAuditEntry(client, &user.ID, AuditActionDefinitionReject, AuditTargetDefinition, definitionObjectId,
+		nil,
+		bson.M{"rejection": rejection},
+	))
+
+	sendDefinitionRejectedEmail(definition, &rejection)
+	publishWebhookEvent(WebhookEventDefinitionRejected, bson.M{"definition": definition, "rejection": rejection})
+
 	return nil
 
 }
 
-func ChangeDefinition(id string, title *string, content *string, source *types.Source, tags *[]string, authToken string) error {
+func ChangeDefinition(id string, title *string, content *string, source *string, tags *[]string, authToken string) error {
 
 	definitionObjectId, definitionObjectIdError := primitive.ObjectIDFromHex(id)
 
@@ -257,7 +304,20 @@ func ChangeDefinition(id string, title *string, content *string, source *types.S
 		updateEntries = append(updateEntries, bson.E{Key: "content", Value: content})
 	}
 	if source != nil {
-		updateEntries = append(updateEntries, bson.E{Key: "source", Value: source})
+
+		sourceId, sourceIdError := primitive.ObjectIDFromHex(*source)
+
+		if sourceIdError != nil {
+			return InvalidID
+		}
+
+		sourceUsableError := validateSourceUsable(sourceId, user)
+
+		if sourceUsableError != nil {
+			return sourceUsableError
+		}
+
+		updateEntries = append(updateEntries, bson.E{Key: "source", Value: sourceId})
 	}
 	if tags != nil {
 		updateEntries = append(updateEntries, bson.E{Key: "tags", Value: tags})
@@ -268,21 +328,25 @@ func ChangeDefinition(id string, title *string, content *string, source *types.S
 		updateEntries = append(updateEntries, bson.E{Key: "last_submit_change_date", Value: time.Now()})
 		update := bson.M{"$set": updateEntries}
 
-		result := definitionsCollection.FindOneAndUpdate(dbContext, filter, update, nil)
+		var changedDefinition Definition
+		updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		updateError := definitionsCollection.FindOneAndUpdate(dbContext, filter, update, updateOptions).Decode(&changedDefinition)
 
-		if result.Err() != nil {
-			if result.Err() == mongo.ErrNoDocuments {
+		if updateError != nil {
+			if updateError == mongo.ErrNoDocuments {
 				return ErrorDefinitionNotFound
 			}
-			return result.Err()
+			return updateError
 		}
+
+		publishWebhookEvent(WebhookEventDefinitionChanged, changedDefinition)
 	}
 
 	return nil
 
 }
 
-func GetDefinitionById(id string) (*Definition, error) {
+func GetDefinitionById(id string, expansion *Expansion) (*ExpandedDefinition, error) {
 
 	objectId, idError := primitive.ObjectIDFromHex(id)
 
@@ -290,8 +354,18 @@ func GetDefinitionById(id string) (*Definition, error) {
 		return nil, InvalidID
 	}
 
-	filter := bson.M{"_id": objectId}
-	return getDefinition(filter, nil)
+	stages := []bson.D{{{Key: "$match", Value: bson.M{"_id": objectId}}}}
+	definitions, err := getExpandedDefinitions(stages, expansion)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(definitions) == 0 {
+		return nil, ErrorDefinitionNotFound
+	}
+
+	return definitions[0], nil
 
 }
 
@@ -302,67 +376,314 @@ func GetDefinitionByObjectId(id primitive.ObjectID) (*Definition, error) {
 
 }
 
-func GetNewestDefinitions(limit int) ([]*Definition, error) {
+/*
+GetNewestDefinitions returns the newest approved definitions. The limit is clamped to 1..maxPageSize,
+since it comes straight from the query of the request.
+*/
+func GetNewestDefinitions(limit int, expansion *Expansion) ([]*ExpandedDefinition, error) {
 
-	options := options.Find().SetSort(bson.M{"creation_date": -1}).SetLimit(int64(limit))
-	return getDefinitions(bson.M{"approved": true}, options)
+	if limit < 1 {
+		limit = 1
+	} else if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	stages := []bson.D{
+		{{Key: "$match", Value: bson.M{"approved": true}}},
+		{{Key: "$sort", Value: bson.M{"approved_date": -1}}},
+		{{Key: "$limit", Value: int64(limit)}},
+	}
+	return getExpandedDefinitions(stages, expansion)
 
 }
 
-func GetDefinitions(pageSize int, page int, definitionFilter *types.DefinitionFilter, sort *interface{}) ([]*Definition, error) {
+/*
+GetDefinitions returns a page of definitions. In fuzzy mode the title and content search is matched
+against the search index instead of the text index, which tolerates typos and incomplete words.
+The search index only covers titles, tags and author names, so a fuzzy content search does not look at the content.
+*/
+func GetDefinitions(pageSize int, page int, definitionFilter *types.DefinitionFilter, sort *interface{}, fuzzy bool, expansion *Expansion) ([]*ExpandedDefinition, error) {
 
-	if pageSize <= 0 || page <= 0 {
+	if pageSize <= 0 || pageSize > maxPageSize || page <= 0 {
 		return nil, common.ErrorInvalidType
 	}
 
-	options := options.FindOptions{}
+	if fuzzy && definitionFilter != nil {
+		return getFuzzyDefinitions(pageSize, page, definitionFilter, sort, expansion)
+	}
 
-	if sort != nil {
-		options.SetSort(*sort)
+	filter, filterError := CreateFilterQuery(definitionFilter)
+
+	if filterError != nil {
+		return nil, filterError
 	}
-	options.SetLimit(int64(pageSize))
-	options.SetSkip(int64((page - 1) * pageSize))
 
-	filter := CreateFilterQuery(definitionFilter)
 	fmt.Println("FILTER_QUERY")
 	fmt.Println(filter)
-	return getDefinitions(filter, &options)
+
+	textQuery := createTextQuery(definitionFilter)
+	ranked := textQuery.HasPositiveParts()
+
+	/* $text queries require the $match stage to be the first one */
+	stages := []bson.D{{{Key: "$match", Value: filter}}}
+
+	if ranked {
+		stages = append(stages, bson.D{{Key: "$addFields", Value: bson.M{"score": bson.M{"$meta": "textScore"}}}})
+	}
+
+	if sort != nil {
+		stages = append(stages, bson.D{{Key: "$sort", Value: *sort}})
+	} else if ranked {
+		stages = append(stages, bson.D{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "_id", Value: 1}}}})
+	}
+
+	stages = append(stages,
+		bson.D{{Key: "$skip", Value: int64((page - 1) * pageSize)}},
+		bson.D{{Key: "$limit", Value: int64(pageSize)}},
+	)
+
+	definitions, err := getExpandedDefinitions(stages, expansion)
+
+	if err != nil || !ranked {
+		return definitions, err
+	}
+
+	for _, definition := range definitions {
+		definition.Highlights = &common.Highlights{
+			Title:   common.HighlightText(definition.Title, textQuery),
+			Content: common.CreateSnippet(definition.Content, textQuery),
+		}
+	}
+
+	return definitions, nil
 
 }
 
-func CreateFilterQuery(filter *types.DefinitionFilter) bson.D {
+func getFuzzyDefinitions(pageSize int, page int, definitionFilter *types.DefinitionFilter, sort *interface{}, expansion *Expansion) ([]*ExpandedDefinition, error) {
 
-	query := bson.D{}
+	search := ""
+	for _, text := range []*string{definitionFilter.Title, definitionFilter.Content} {
+		if text != nil {
+			search += " " + *text
+		}
+	}
+
+	remainingFilter := *definitionFilter
+	remainingFilter.Title = nil
+	remainingFilter.Content = nil
+
+	filter, filterError := CreateFilterQuery(&remainingFilter)
+
+	if filterError != nil {
+		return nil, filterError
+	}
+
+	ids, scores := findFuzzyDefinitions(search)
+	ranked := len(strings.TrimSpace(search)) > 0
+
+	stages := []bson.D{}
+
+	if ranked {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.M{"$in": ids}})
+		stages = append(stages,
+			bson.D{{Key: "$match", Value: filter}},
+			bson.D{{Key: "$addFields", Value: bson.M{"fuzzy_rank": bson.M{"$indexOfArray": bson.A{ids, "$_id"}}}}},
+		)
+	} else {
+		stages = append(stages, bson.D{{Key: "$match", Value: filter}})
+	}
+
+	if sort != nil {
+		stages = append(stages, bson.D{{Key: "$sort", Value: *sort}})
+	} else if ranked {
+		stages = append(stages, bson.D{{Key: "$sort", Value: bson.D{{Key: "fuzzy_rank", Value: 1}}}})
+	}
+
+	stages = append(stages,
+		bson.D{{Key: "$skip", Value: int64((page - 1) * pageSize)}},
+		bson.D{{Key: "$limit", Value: int64(pageSize)}},
+	)
 
-	textSearch := ""
-	if filter.Title != nil && len(*filter.Title) > 0 {
-		textSearch = *filter.Title
+	definitions, err := getExpandedDefinitions(stages, expansion)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if filter.Content != nil && len(*filter.Content) > 0 {
-		if len(textSearch) > 0 {
-			textSearch += " "
+	if ranked {
+		for _, definition := range definitions {
+			score := scores[definition.ID]
+			definition.Score = &score
 		}
-		textSearch += *filter.Content
 	}
 
-	if len(textSearch) > 0 {
-		query = append(query, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: textSearch}}})
+	return definitions, nil
+
+}
+
+/*
+createTextQuery combines the title and content search of the filter. Both are searched in title
+and content, since the text index covers both fields.
+*/
+func createTextQuery(filter *types.DefinitionFilter) *common.TextQuery {
+
+	textQuery := common.TextQuery{}
+
+	if filter == nil {
+		return &textQuery
+	}
+
+	if filter.Title != nil {
+		textQuery.Merge(common.ParseTextQuery(*filter.Title))
+	}
+
+	if filter.Content != nil {
+		textQuery.Merge(common.ParseTextQuery(*filter.Content))
+	}
+
+	return &textQuery
+
+}
+
+func createTextRegexCondition(phrase string) bson.A {
+
+	regex := primitive.Regex{Pattern: common.PhraseRegex(phrase), Options: "i"}
+	return bson.A{bson.M{"title": regex}, bson.M{"content": regex}}
+
+}
+
+/*
+findSourcesOfAuthors returns the IDs of the approved sources of every approved author matching one of the names.
+Pending authors and sources are ignored, so that they do not decide which approved definitions are listed.
+*/
+func findSourcesOfAuthors(names []string) ([]primitive.ObjectID, error) {
+
+	nameQueries := bson.A{}
+	for _, name := range names {
+		nameQueries = append(nameQueries, createAuthorSearchQuery(&name))
+	}
+
+	authors, err := getAuthors(bson.M{"$or": nameQueries, "approved": true}, options.Find().SetProjection(bson.M{"_id": 1}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	authorIds := []primitive.ObjectID{}
+	for _, author := range authors {
+		authorIds = append(authorIds, author.ID)
+	}
+
+	sources, sourcesError := getSources(bson.M{"authors": bson.M{"$in": authorIds}, "approved": true}, options.Find().SetProjection(bson.M{"_id": 1}))
+
+	if sourcesError != nil {
+		return nil, sourcesError
+	}
+
+	sourceIds := []primitive.ObjectID{}
+	for _, source := range sources {
+		sourceIds = append(sourceIds, source.ID)
+	}
+
+	return sourceIds, nil
+
+}
+
+/*
+CreateFilterQuery translates the filter into a Mongo query. Tags and author names match if any of them matches,
+excluded tags and authors must not match at all. The years limit the publishing date.
+*/
+func CreateFilterQuery(filter *types.DefinitionFilter) (bson.D, error) {
+
+	query := bson.D{}
+
+	if filter == nil {
+		return query, nil
+	}
+
+	textQuery := createTextQuery(filter)
+
+	if textQuery.HasPositiveParts() {
+		query = append(query, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: textQuery.MongoSearch()}}})
+	}
+
+	conditions := bson.A{}
+
+	/* $text does not require every phrase on all Mongo versions, so each phrase is required explicitly */
+	for _, phrase := range textQuery.Phrases {
+		conditions = append(conditions, bson.M{"$or": createTextRegexCondition(phrase)})
+	}
+
+	for _, phrase := range textQuery.ExcludedPhrases {
+		conditions = append(conditions, bson.M{"$nor": createTextRegexCondition(phrase)})
+	}
+
+	/* a $text search consisting only of excluded terms matches nothing instead of everything else */
+	if !textQuery.HasPositiveParts() {
+		for _, term := range textQuery.ExcludedTerms {
+			conditions = append(conditions, bson.M{"$nor": createTextRegexCondition(term)})
+		}
 	}
 
 	if filter.Tags != nil {
-		query = append(query, bson.E{Key: "tags", Value: bson.D{{Key: "$in", Value: *filter.Tags}}})
+		conditions = append(conditions, bson.M{"tags": bson.M{"$in": *filter.Tags}})
+	}
+
+	if filter.ExcludedTags != nil {
+		conditions = append(conditions, bson.M{"tags": bson.M{"$nin": *filter.ExcludedTags}})
+	}
+
+	if filter.AuthorNames != nil {
+
+		sourceIds, err := findSourcesOfAuthors(*filter.AuthorNames)
+
+		if err != nil {
+			return nil, err
+		}
+
+		conditions = append(conditions, bson.M{"source": bson.M{"$in": sourceIds}})
 	}
 
-	// TODO: Sources, Authors, PublishingDates
+	if filter.ExcludedAuthorNames != nil {
 
-	// bson.D{{Key: "title", Value: bson.D{{Key: "$regex", Value: primitive.Regex{Pattern: *filter.Title, Options: "i"}}}}}
-	return query
+		sourceIds, err := findSourcesOfAuthors(*filter.ExcludedAuthorNames)
+
+		if err != nil {
+			return nil, err
+		}
+
+		conditions = append(conditions, bson.M{"source": bson.M{"$nin": sourceIds}})
+	}
+
+	publishingDate := bson.M{}
+
+	if filter.YearFrom != nil {
+		publishingDate["$gte"] = time.Date(*filter.YearFrom, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	if filter.YearTo != nil {
+		publishingDate["$lt"] = time.Date(*filter.YearTo+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	if len(publishingDate) > 0 {
+		conditions = append(conditions, bson.M{"publishing_date": publishingDate})
+	}
+
+	/* a single $and, so that the query can also be converted into a map */
+	if len(conditions) > 0 {
+		query = append(query, bson.E{Key: "$and", Value: conditions})
+	}
+
+	return query, nil
 
 }
 
 func GetPageCount(pageSize int, filter interface{}) (int64, error) {
 
+	if pageSize <= 0 || pageSize > maxPageSize {
+		return 0, common.ErrorInvalidType
+	}
+
 	count, err := definitionsCollection.CountDocuments(dbContext, filter, nil)
 	pageCount := int64(math.Ceil(float64(count) / float64(pageSize)))
 
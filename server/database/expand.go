@@ -0,0 +1,261 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"yacoid_server/common"
+	"yacoid_server/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrorInvalidExpand = errors.New("INVALID_EXPAND")
+
+/*
+Expansion lists the references of a definition that are inlined into the response.
+Sources and authors are only inlined if they are visible to the user, see createVisibilityFilter.
+*/
+type Expansion struct {
+	Source        bool
+	SourceAuthors bool
+	SubmittedBy   bool
+	ApprovedBy    bool
+	user          *User
+}
+
+/*
+ParseExpansion parses a comma separated list like "source,source.authors,submittedBy".
+Expanding "source.authors" implies expanding "source".
+*/
+func ParseExpansion(expand string, authToken string) (*Expansion, error) {
+
+	var expansion Expansion
+
+	for _, field := range strings.Split(expand, ",") {
+
+		switch strings.TrimSpace(field) {
+		case "":
+		case "source":
+			expansion.Source = true
+		case "source.authors":
+			expansion.Source = true
+			expansion.SourceAuthors = true
+		case "submittedBy":
+			expansion.SubmittedBy = true
+		case "approvedBy":
+			expansion.ApprovedBy = true
+		default:
+			return nil, ErrorInvalidExpand
+		}
+	}
+
+	if expansion.Source {
+		expansion.user = GetOptionalUser(authToken)
+	}
+
+	return &expansion, nil
+
+}
+
+type definitionExpansions struct {
+	Source        *types.Source     `bson:"source,omitempty"`
+	SourceAuthors []*types.Author   `bson:"source_authors,omitempty"`
+	SubmittedBy   *types.PublicUser `bson:"submitted_by,omitempty"`
+	ApprovedBy    *types.PublicUser `bson:"approved_by,omitempty"`
+}
+
+/*
+ExpandedDefinition is a definition whose expanded references replace the plain IDs in the JSON representation.
+*/
+type ExpandedDefinition struct {
+	Definition `bson:",inline"`
+	Expanded   *definitionExpansions `bson:"expanded,omitempty"`
+	Score      *float64              `bson:"score,omitempty"`
+	Highlights *common.Highlights    `bson:"-"`
+}
+
+func (definition *ExpandedDefinition) MarshalJSON() ([]byte, error) {
+
+	encoded, err := json.Marshal(&definition.Definition)
+
+	if err != nil || (definition.Expanded == nil && definition.Score == nil && definition.Highlights == nil) {
+		return encoded, err
+	}
+
+	fields := map[string]interface{}{}
+
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+
+	if definition.Score != nil {
+		fields["score"] = *definition.Score
+	}
+
+	if definition.Highlights != nil {
+		fields["highlights"] = definition.Highlights
+	}
+
+	if definition.Expanded == nil {
+		return json.Marshal(fields)
+	}
+
+	if definition.Expanded.Source != nil {
+
+		if definition.Expanded.SourceAuthors != nil {
+			fields["source"] = populateSourceWith(definition.Expanded.Source, definition.Expanded.SourceAuthors)
+		} else {
+			fields["source"] = definition.Expanded.Source
+		}
+	}
+
+	if definition.Expanded.SubmittedBy != nil {
+		fields["submittedBy"] = definition.Expanded.SubmittedBy
+	}
+
+	if definition.Expanded.ApprovedBy != nil {
+		fields["approvedBy"] = definition.Expanded.ApprovedBy
+	}
+
+	return json.Marshal(fields)
+
+}
+
+/*
+populateSourceWith resolves the author references of the source in their original order.
+*/
+func populateSourceWith(source *types.Source, authors []*types.Author) *types.PopulatedSource {
+
+	authorsById := map[primitive.ObjectID]*types.Author{}
+	for _, author := range authors {
+		authorsById[author.ID] = author
+	}
+
+	populatedSource := types.PopulatedSource{
+		ID:            source.ID,
+		SubmittedBy:   source.SubmittedBy,
+		SubmittedDate: source.SubmittedDate,
+		Approved:      source.Approved,
+		Authors:       []*types.Author{},
+		Title:         source.Title,
+		Year:          source.Year,
+		ISBN:          source.ISBN,
+		DOI:           source.DOI,
+	}
+
+	for _, authorId := range source.Authors {
+		if author, exists := authorsById[authorId]; exists {
+			populatedSource.Authors = append(populatedSource.Authors, author)
+		}
+	}
+
+	return &populatedSource
+
+}
+
+/*
+createPublicUserLookup joins a user reference, projected to the public fields only.
+*/
+func createPublicUserLookup(localField string, as string) []bson.D {
+
+	return []bson.D{
+		{{Key: "$lookup", Value: bson.M{
+			"from": userCollection.Name(),
+			"let":  bson.M{"id": "$" + localField},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$_id", "$$id"}}}},
+				bson.M{"$project": bson.M{"_id": 1, "first_name": 1, "last_name": 1}},
+			},
+			"as": as,
+		}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$" + as, "preserveNullAndEmptyArrays": true}}},
+	}
+
+}
+
+/*
+createExpansionStages returns the $lookup stages that inline the requested references under "expanded".
+*/
+func createExpansionStages(expansion *Expansion) []bson.D {
+
+	stages := []bson.D{}
+
+	if expansion == nil {
+		return stages
+	}
+
+	/* pending sources and authors are left out unless the user submitted them, like everywhere else */
+	if expansion.Source {
+		stages = append(stages,
+			bson.D{{Key: "$lookup", Value: bson.M{
+				"from": sourcesCollection.Name(),
+				"let":  bson.M{"id": "$source"},
+				"pipeline": bson.A{
+					bson.M{"$match": withVisibility(bson.M{"$expr": bson.M{"$eq": bson.A{"$_id", "$$id"}}}, expansion.user)},
+				},
+				"as": "expanded.source",
+			}}},
+			bson.D{{Key: "$unwind", Value: bson.M{"path": "$expanded.source", "preserveNullAndEmptyArrays": true}}},
+		)
+	}
+
+	if expansion.SourceAuthors {
+		stages = append(stages, bson.D{{Key: "$lookup", Value: bson.M{
+			"from": authorsCollection.Name(),
+			"let":  bson.M{"ids": bson.M{"$ifNull": bson.A{"$expanded.source.authors", bson.A{}}}},
+			"pipeline": bson.A{
+				bson.M{"$match": withVisibility(bson.M{"$expr": bson.M{"$in": bson.A{"$_id", "$$ids"}}}, expansion.user)},
+			},
+			"as": "expanded.source_authors",
+		}}})
+	}
+
+	if expansion.SubmittedBy {
+		stages = append(stages, createPublicUserLookup("submitted_by", "expanded.submitted_by")...)
+	}
+
+	if expansion.ApprovedBy {
+		stages = append(stages, createPublicUserLookup("approved_by", "expanded.approved_by")...)
+	}
+
+	return stages
+
+}
+
+/*
+getExpandedDefinitions runs the given stages (filter, sort, paging) followed by the expansion stages.
+*/
+func getExpandedDefinitions(stages []bson.D, expansion *Expansion) ([]*ExpandedDefinition, error) {
+
+	pipeline := mongo.Pipeline{}
+	pipeline = append(pipeline, stages...)
+	pipeline = append(pipeline, createExpansionStages(expansion)...)
+
+	cursor, err := definitionsCollection.Aggregate(dbContext, pipeline)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	definitions := []*ExpandedDefinition{}
+
+	for cursor.Next(dbContext) {
+
+		definition := ExpandedDefinition{}
+		err := cursor.Decode(&definition)
+
+		if err != nil {
+			return nil, err
+		}
+
+		definitions = append(definitions, &definition)
+	}
+
+	return definitions, nil
+
+}
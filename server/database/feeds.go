@@ -0,0 +1,420 @@
+package database
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"html"
+	"strings"
+	"time"
+	"yacoid_server/common"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrorInvalidFeedFormat = errors.New("INVALID_FEED_FORMAT")
+
+const (
+	FeedFormatAtom = "atom"
+	FeedFormatRSS  = "rss"
+	FeedFormatJSON = "json"
+)
+
+const feedSize = 50
+
+/*
+FeedEntry is an approved definition as shown in a feed reader.
+*/
+type FeedEntry struct {
+	ID        primitive.ObjectID
+	Title     string
+	Quote     string
+	Authors   []string
+	Citation  string
+	Tags      []string
+	Published time.Time
+}
+
+func (entry *FeedEntry) Link() string {
+	return common.GetPublicBaseUrl() + "/definitions/" + entry.ID.Hex()
+}
+
+/*
+ContentHTML is the quote followed by its authors and the citation of its source.
+*/
+func (entry *FeedEntry) ContentHTML() string {
+
+	var content strings.Builder
+	content.WriteString("<blockquote>" + html.EscapeString(entry.Quote) + "</blockquote>")
+
+	if len(entry.Authors) > 0 {
+		content.WriteString("<p>— " + html.EscapeString(strings.Join(entry.Authors, ", ")) + "</p>")
+	}
+
+	if len(entry.Citation) > 0 {
+		content.WriteString("<p><cite>" + html.EscapeString(entry.Citation) + "</cite></p>")
+	}
+
+	return content.String()
+
+}
+
+func (entry *FeedEntry) ContentText() string {
+
+	content := "\"" + entry.Quote + "\""
+
+	if len(entry.Authors) > 0 {
+		content += "\n— " + strings.Join(entry.Authors, ", ")
+	}
+
+	if len(entry.Citation) > 0 {
+		content += "\n\n" + entry.Citation
+	}
+
+	return content
+
+}
+
+/*
+Feed holds the newest approved definitions, newest first. Updated is the approval date of the newest one.
+*/
+type Feed struct {
+	Title       string
+	Description string
+	Updated     time.Time
+	Entries     []*FeedEntry
+}
+
+func GetDefinitionsFeed() (*Feed, error) {
+	return createFeed("YACOID", "Neu freigegebene Definitionen", bson.M{})
+}
+
+func GetTagFeed(tag string) (*Feed, error) {
+	return createFeed("YACOID: "+tag, "Neu freigegebene Definitionen mit dem Tag "+tag, bson.M{"tags": tag})
+}
+
+/*
+GetAuthorFeed returns the definitions drawn from the sources of the approved author with the given slug.
+*/
+func GetAuthorFeed(slug string) (*Feed, error) {
+
+	author, findError := GetAuthorBySlug(slug)
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	if !author.Approved {
+		return nil, common.ErrorNotFound
+	}
+
+	sources, sourcesError := getSources(bson.M{"authors": author.ID, "approved": true}, nil)
+
+	if sourcesError != nil {
+		return nil, sourcesError
+	}
+
+	sourceIds := []primitive.ObjectID{}
+	for _, source := range sources {
+		sourceIds = append(sourceIds, source.ID)
+	}
+
+	return createFeed("YACOID: "+author.FullName(), "Neu freigegebene Definitionen von "+author.FullName(), bson.M{"source": bson.M{"$in": sourceIds}})
+
+}
+
+func createFeed(title string, description string, filter bson.M) (*Feed, error) {
+
+	stages := []bson.D{
+		{{Key: "$match", Value: bson.M{"$and": bson.A{filter, bson.M{"approved": true}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "approved_date", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$limit", Value: int64(feedSize)}},
+	}
+
+	definitions, err := getExpandedDefinitions(stages, &Expansion{Source: true, SourceAuthors: true})
+
+	if err != nil {
+		return nil, err
+	}
+
+	feed := Feed{Title: title, Description: description, Entries: []*FeedEntry{}}
+
+	for _, definition := range definitions {
+
+		entry := FeedEntry{
+			ID:    definition.ID,
+			Title: definition.Title,
+			Quote: definition.Content,
+			Tags:  []string{},
+		}
+
+		if definition.ApprovedDate != nil {
+			entry.Published = *definition.ApprovedDate
+		}
+
+		if definition.Tags != nil {
+			entry.Tags = *definition.Tags
+		}
+
+		if definition.Expanded != nil && definition.Expanded.Source != nil {
+
+			source := populateSourceWith(definition.Expanded.Source, definition.Expanded.SourceAuthors)
+			entry.Citation = source.Citation()
+
+			for _, author := range source.Authors {
+				entry.Authors = append(entry.Authors, author.FullName())
+			}
+		}
+
+		if entry.Published.After(feed.Updated) {
+			feed.Updated = entry.Published
+		}
+
+		feed.Entries = append(feed.Entries, &entry)
+	}
+
+	return &feed, nil
+
+}
+
+/*
+Render serializes the feed in the given format and returns it together with its content type.
+The self link is the URL the feed was requested from.
+*/
+func (feed *Feed) Render(format string, selfLink string) ([]byte, string, error) {
+
+	switch format {
+	case FeedFormatAtom:
+		output, err := feed.toAtom(selfLink)
+		return output, "application/atom+xml; charset=utf-8", err
+	case FeedFormatRSS:
+		output, err := feed.toRSS(selfLink)
+		return output, "application/rss+xml; charset=utf-8", err
+	case FeedFormatJSON:
+		output, err := feed.toJSONFeed(selfLink)
+		return output, "application/feed+json; charset=utf-8", err
+	default:
+		return nil, "", ErrorInvalidFeedFormat
+	}
+
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Links      []atomLink     `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomPerson   `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"feed"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	Links    []atomLink  `xml:"link"`
+	Updated  string      `xml:"updated"`
+	Author   atomPerson  `xml:"author"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+func (feed *Feed) toAtom(selfLink string) ([]byte, error) {
+
+	document := atomFeed{
+		Xmlns:    "http://www.w3.org/2005/Atom",
+		ID:       selfLink,
+		Title:    feed.Title,
+		Subtitle: feed.Description,
+		Links: []atomLink{
+			{Href: selfLink, Rel: "self", Type: "application/atom+xml"},
+			{Href: common.GetPublicBaseUrl() + "/definitions", Rel: "alternate", Type: "text/html"},
+		},
+		Updated: feed.Updated.UTC().Format(time.RFC3339),
+		Author:  atomPerson{Name: "YACOID"},
+	}
+
+	for _, entry := range feed.Entries {
+
+		atom := atomEntry{
+			ID:        entry.Link(),
+			Title:     entry.Title,
+			Links:     []atomLink{{Href: entry.Link(), Rel: "alternate", Type: "text/html"}},
+			Published: entry.Published.UTC().Format(time.RFC3339),
+			Updated:   entry.Published.UTC().Format(time.RFC3339),
+			Content:   atomContent{Type: "html", Value: entry.ContentHTML()},
+		}
+
+		for _, author := range entry.Authors {
+			atom.Authors = append(atom.Authors, atomPerson{Name: author})
+		}
+
+		for _, tag := range entry.Tags {
+			atom.Categories = append(atom.Categories, atomCategory{Term: tag})
+		}
+
+		document.Entries = append(document.Entries, atom)
+	}
+
+	output, err := xml.MarshalIndent(document, "", "  ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), output...), nil
+
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        rssGuid  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creators    []string `xml:"dc:creator"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	SelfLink      atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+func (feed *Feed) toRSS(selfLink string) ([]byte, error) {
+
+	document := rssDocument{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       feed.Title,
+			Link:        common.GetPublicBaseUrl() + "/definitions",
+			Description: feed.Description,
+			SelfLink:    atomLink{Href: selfLink, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+
+	if !feed.Updated.IsZero() {
+		document.Channel.LastBuildDate = feed.Updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, entry := range feed.Entries {
+		document.Channel.Items = append(document.Channel.Items, rssItem{
+			Title:       entry.Title,
+			Link:        entry.Link(),
+			Guid:        rssGuid{IsPermaLink: true, Value: entry.Link()},
+			PubDate:     entry.Published.UTC().Format(time.RFC1123Z),
+			Creators:    entry.Authors,
+			Categories:  entry.Tags,
+			Description: entry.ContentHTML(),
+		})
+	}
+
+	output, err := xml.MarshalIndent(document, "", "  ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), output...), nil
+
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string            `json:"id"`
+	URL           string            `json:"url"`
+	Title         string            `json:"title"`
+	ContentHTML   string            `json:"content_html"`
+	ContentText   string            `json:"content_text"`
+	DatePublished string            `json:"date_published"`
+	Authors       []*jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+}
+
+type jsonFeed struct {
+	Version     string          `json:"version"`
+	Title       string          `json:"title"`
+	HomePageURL string          `json:"home_page_url"`
+	FeedURL     string          `json:"feed_url"`
+	Description string          `json:"description"`
+	Items       []*jsonFeedItem `json:"items"`
+}
+
+func (feed *Feed) toJSONFeed(selfLink string) ([]byte, error) {
+
+	document := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		HomePageURL: common.GetPublicBaseUrl() + "/definitions",
+		FeedURL:     selfLink,
+		Description: feed.Description,
+		Items:       []*jsonFeedItem{},
+	}
+
+	for _, entry := range feed.Entries {
+
+		item := jsonFeedItem{
+			ID:            entry.Link(),
+			URL:           entry.Link(),
+			Title:         entry.Title,
+			ContentHTML:   entry.ContentHTML(),
+			ContentText:   entry.ContentText(),
+			DatePublished: entry.Published.UTC().Format(time.RFC3339),
+			Tags:          entry.Tags,
+		}
+
+		for _, author := range entry.Authors {
+			item.Authors = append(item.Authors, &jsonFeedAuthor{Name: author})
+		}
+
+		document.Items = append(document.Items, &item)
+	}
+
+	return json.MarshalIndent(document, "", "  ")
+
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"encoding/xml"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CoAuthorNode struct {
+	ID          string `json:"id"`
+	SlugId      string `json:"slugId"`
+	Label       string `json:"label"`
+	SourceCount int    `json:"sourceCount"`
+}
+
+type CoAuthorEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Weight int    `json:"weight"`
+}
+
+type CoAuthorGraph struct {
+	Nodes []*CoAuthorNode `json:"nodes"`
+	Edges []*CoAuthorEdge `json:"edges"`
+}
+
+/*
+GetCoAuthorGraph builds the co-authorship network: every author is a node and two authors are connected
+by an edge weighted with the number of sources they wrote together. Only approved entries are part of the graph.
+*/
+func GetCoAuthorGraph() (*CoAuthorGraph, error) {
+
+	authors, authorsError := getAuthors(bson.M{"approved": true}, nil)
+
+	if authorsError != nil {
+		return nil, authorsError
+	}
+
+	sources, sourcesError := getSources(bson.M{"approved": true}, nil)
+
+	if sourcesError != nil {
+		return nil, sourcesError
+	}
+
+	graph := CoAuthorGraph{Nodes: []*CoAuthorNode{}, Edges: []*CoAuthorEdge{}}
+	nodesById := map[primitive.ObjectID]*CoAuthorNode{}
+
+	for _, author := range authors {
+		node := CoAuthorNode{
+			ID:     author.ID.Hex(),
+			SlugId: author.SlugId,
+			Label:  author.FullName(),
+		}
+		nodesById[author.ID] = &node
+		graph.Nodes = append(graph.Nodes, &node)
+	}
+
+	edgesByKey := map[[2]primitive.ObjectID]*CoAuthorEdge{}
+
+	for _, source := range sources {
+
+		/* ignore dangling references and authors listed twice on the same source */
+		authorIds := []primitive.ObjectID{}
+		for _, authorId := range source.Authors {
+			if _, exists := nodesById[authorId]; exists && !containsObjectID(authorIds, authorId) {
+				authorIds = append(authorIds, authorId)
+			}
+		}
+
+		for i, authorId := range authorIds {
+
+			nodesById[authorId].SourceCount++
+
+			for _, otherId := range authorIds[i+1:] {
+
+				key := [2]primitive.ObjectID{authorId, otherId}
+				if otherId.Hex() < authorId.Hex() {
+					key = [2]primitive.ObjectID{otherId, authorId}
+				}
+
+				edge, exists := edgesByKey[key]
+				if !exists {
+					edge = &CoAuthorEdge{Source: key[0].Hex(), Target: key[1].Hex()}
+					edgesByKey[key] = edge
+					graph.Edges = append(graph.Edges, edge)
+				}
+				edge.Weight++
+			}
+		}
+	}
+
+	return &graph, nil
+
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+/*
+ToGraphML serializes the graph as GraphML, which can be imported into tools like Gephi.
+*/
+func (graph *CoAuthorGraph) ToGraphML() ([]byte, error) {
+
+	document := graphMLDocument{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "label", For: "node", AttrName: "label", AttrType: "string"},
+			{ID: "slug", For: "node", AttrName: "slug", AttrType: "string"},
+			{ID: "sources", For: "node", AttrName: "sources", AttrType: "int"},
+			{ID: "weight", For: "edge", AttrName: "weight", AttrType: "int"},
+		},
+		Graph: graphMLGraph{ID: "co_authors", EdgeDefault: "undirected"},
+	}
+
+	for _, node := range graph.Nodes {
+		document.Graph.Nodes = append(document.Graph.Nodes, graphMLNode{
+			ID: node.ID,
+			Data: []graphMLData{
+				{Key: "label", Value: node.Label},
+				{Key: "slug", Value: node.SlugId},
+				{Key: "sources", Value: strconv.Itoa(node.SourceCount)},
+			},
+		})
+	}
+
+	for _, edge := range graph.Edges {
+		document.Graph.Edges = append(document.Graph.Edges, graphMLEdge{
+			Source: edge.Source,
+			Target: edge.Target,
+			Data:   []graphMLData{{Key: "weight", Value: strconv.Itoa(edge.Weight)}},
+		})
+	}
+
+	output, err := xml.MarshalIndent(document, "", "  ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), output...), nil
+
+}
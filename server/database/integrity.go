@@ -0,0 +1,258 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrorDeletionBlocked = errors.New("DELETION_BLOCKED")
+
+/*
+DeletedUserID replaces references to users that deleted their account, so that their approved
+contributions stay available without pointing to a missing user.
+*/
+var DeletedUserID = primitive.NilObjectID
+
+const maxReportedBlockers = 100
+
+/*
+Blocker is a document that still references an entity which should be deleted.
+*/
+type Blocker struct {
+	Collection string `json:"collection"`
+	ID         string `json:"id"`
+	Field      string `json:"field"`
+}
+
+/* transactions are only available if the database runs as a replica set or a sharded cluster */
+var transactionsSupported bool
+
+/*
+detectTransactionSupport checks whether the database is a replica set member or a mongos router.
+A standalone server, which this project has always been deployed with, does not support transactions.
+*/
+func detectTransactionSupport() error {
+
+	var result struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+
+	err := client.Database("admin").RunCommand(dbContext, bson.D{{Key: "isMaster", Value: 1}}).Decode(&result)
+
+	if err != nil {
+		return err
+	}
+
+	transactionsSupported = len(result.SetName) > 0 || result.Msg == "isdbgrid"
+
+	if !transactionsSupported {
+		fmt.Println("Database is not a replica set, multi-document writes are executed without transactions")
+	}
+
+	return nil
+
+}
+
+/*
+runInTransaction executes the callback inside a Mongo transaction. All reads and writes of the callback have to
+use the given session context. On a standalone server, the callback runs without a transaction: its writes are
+executed in order, but are not rolled back if a later one fails. Callbacks therefore move references before
+they delete the referenced documents, so that an interrupted run never leaves dangling references behind.
+*/
+func runInTransaction(callback func(sessionContext mongo.SessionContext) error) error {
+
+	session, sessionError := client.StartSession()
+
+	if sessionError != nil {
+		return sessionError
+	}
+
+	defer session.EndSession(dbContext)
+
+	if !transactionsSupported {
+		return mongo.WithSession(dbContext, session, callback)
+	}
+
+	_, err := session.WithTransaction(dbContext, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		return nil, callback(sessionContext)
+	})
+
+	return err
+
+}
+
+func findBlockers(sessionContext mongo.SessionContext, collection *mongo.Collection, field string, id primitive.ObjectID) ([]*Blocker, error) {
+
+	options := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(maxReportedBlockers)
+	cursor, err := collection.Find(sessionContext, bson.M{field: id}, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(sessionContext)
+
+	blockers := []*Blocker{}
+
+	for cursor.Next(sessionContext) {
+
+		var document struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+
+		if err := cursor.Decode(&document); err != nil {
+			return nil, err
+		}
+
+		blockers = append(blockers, &Blocker{Collection: collection.Name(), ID: document.ID.Hex(), Field: field})
+	}
+
+	return blockers, nil
+
+}
+
+/*
+findAuthorBlockers returns the sources that still list the author.
+*/
+func findAuthorBlockers(sessionContext mongo.SessionContext, id primitive.ObjectID) ([]*Blocker, error) {
+	return findBlockers(sessionContext, sourcesCollection, "authors", id)
+}
+
+/*
+findSourceBlockers returns the definitions that are still drawn from the source.
+*/
+func findSourceBlockers(sessionContext mongo.SessionContext, id primitive.ObjectID) ([]*Blocker, error) {
+	return findBlockers(sessionContext, definitionsCollection, "source", id)
+}
+
+/*
+deleteIfUnreferenced deletes the document in the same transaction in which the blockers are checked,
+so that no reference can be added in between (without transactions, see runInTransaction, a reference added
+in between cannot be ruled out). If blockers exist, they are returned with ErrorDeletionBlocked.
+*/
+func deleteIfUnreferenced(collection *mongo.Collection, id primitive.ObjectID, blockerFinder func(sessionContext mongo.SessionContext, id primitive.ObjectID) ([]*Blocker, error)) ([]*Blocker, error) {
+
+	var blockers []*Blocker
+
+	err := runInTransaction(func(sessionContext mongo.SessionContext) error {
+
+		var findError error
+		blockers, findError = blockerFinder(sessionContext, id)
+
+		if findError != nil {
+			return findError
+		}
+
+		if len(blockers) > 0 {
+			return ErrorDeletionBlocked
+		}
+
+		_, deleteError := collection.DeleteOne(sessionContext, bson.M{"_id": id})
+		return deleteError
+
+	})
+
+	if err != nil {
+		if err == ErrorDeletionBlocked {
+			return blockers, err
+		}
+		return nil, err
+	}
+
+	return nil, nil
+
+}
+
+/*
+anonymizeUserReferences reassigns everything the user submitted, approved or rejected to DeletedUserID.
+Definitions, sources and authors of the user that were never approved are deleted, since nobody can answer
+their rejections anymore. Pending sources and authors that are still referenced are kept and reassigned.
+*/
+func anonymizeUserReferences(sessionContext mongo.SessionContext, userId primitive.ObjectID) error {
+
+	_, deleteError := definitionsCollection.DeleteMany(sessionContext, bson.M{"submitted_by": userId, "approved": false})
+
+	if deleteError != nil {
+		return deleteError
+	}
+
+	sourcesError := deleteUnreferencedPendingEntries(sessionContext, sourcesCollection, definitionsCollection, "source", userId)
+
+	if sourcesError != nil {
+		return sourcesError
+	}
+
+	authorsError := deleteUnreferencedPendingEntries(sessionContext, authorsCollection, sourcesCollection, "authors", userId)
+
+	if authorsError != nil {
+		return authorsError
+	}
+
+	for _, collection := range []*mongo.Collection{definitionsCollection, authorsCollection, sourcesCollection} {
+
+		for _, field := range []string{"submitted_by", "approved_by"} {
+
+			_, updateError := collection.UpdateMany(sessionContext, bson.M{field: userId}, bson.M{"$set": bson.M{field: DeletedUserID}})
+
+			if updateError != nil {
+				return updateError
+			}
+		}
+
+		arrayFilters := options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"rejection.rejected_by": userId}},
+		})
+
+		_, rejectionError := collection.UpdateMany(sessionContext,
+			bson.M{"rejection_log.rejected_by": userId},
+			bson.M{"$set": bson.M{"rejection_log.$[rejection].rejected_by": DeletedUserID}},
+			arrayFilters,
+		)
+
+		if rejectionError != nil {
+			return rejectionError
+		}
+	}
+
+	return nil
+
+}
+
+/*
+deleteUnreferencedPendingEntries deletes the unapproved entries the user submitted to the collection,
+unless a document of the referencing collection still points to them in the given field.
+*/
+func deleteUnreferencedPendingEntries(sessionContext mongo.SessionContext, collection *mongo.Collection, referencingCollection *mongo.Collection, field string, userId primitive.ObjectID) error {
+
+	pendingIds, findError := collection.Distinct(sessionContext, "_id", bson.M{"submitted_by": userId, "approved": false})
+
+	if findError != nil {
+		return findError
+	}
+
+	if len(pendingIds) == 0 {
+		return nil
+	}
+
+	referencedIds, referencedError := referencingCollection.Distinct(sessionContext, field, bson.M{field: bson.M{"$in": pendingIds}})
+
+	if referencedError != nil {
+		return referencedError
+	}
+
+	if referencedIds == nil {
+		referencedIds = bson.A{}
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": pendingIds, "$nin": referencedIds}}
+
+	_, deleteError := collection.DeleteMany(sessionContext, filter)
+	return deleteError
+
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"yacoid_server/mail"
+	"yacoid_server/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrorInvalidEmailKind = errors.New("INVALID_EMAIL_KIND")
+
+/*
+Kinds of lifecycle emails a user can opt out of.
+*/
+const (
+	EmailKindSubmissionReceipt  = mail.TemplateSubmissionReceipt
+	EmailKindDefinitionApproved = mail.TemplateDefinitionApproved
+	EmailKindDefinitionRejected = mail.TemplateDefinitionRejected
+)
+
+var EmailKinds = []string{EmailKindSubmissionReceipt, EmailKindDefinitionApproved, EmailKindDefinitionRejected}
+
+func (user *User) WantsEmail(kind string) bool {
+	return !containsString(user.EmailOptOuts, kind)
+}
+
+/*
+UpdateEmailOptOuts replaces the kinds of lifecycle emails the user does not want to receive.
+*/
+func UpdateEmailOptOuts(optOuts []string, authToken string) ([]string, error) {
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	cleaned := []string{}
+
+	for _, kind := range optOuts {
+
+		if !containsString(EmailKinds, kind) {
+			return nil, ErrorInvalidEmailKind
+		}
+
+		if !containsString(cleaned, kind) {
+			cleaned = append(cleaned, kind)
+		}
+	}
+
+	_, err := userCollection.UpdateOne(dbContext, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"email_opt_outs": cleaned}})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cleaned, nil
+
+}
+
+func GetEmailOptOuts(authToken string) ([]string, error) {
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	if user.EmailOptOuts == nil {
+		return []string{}, nil
+	}
+
+	return user.EmailOptOuts, nil
+
+}
+
+/*
+sendLifecycleEmail mails the user in the background unless they opted out of the kind,
+so that the request which triggered the email does not wait for the mail server.
+The template of a kind has the same name as the kind.
+*/
+func sendLifecycleEmail(userId primitive.ObjectID, kind string, data mail.TemplateData) {
+
+	go func() {
+
+		user, err := GetUserById(userId)
+
+		if err != nil {
+			if err != ErrorUserNotFound {
+				fmt.Println("Could not send "+kind+" email:", err)
+			}
+			return
+		}
+
+		if !user.WantsEmail(kind) {
+			return
+		}
+
+		data["FirstName"] = user.FirstName
+
+		if err := sendTemplatedMail(user.Email, user.Language, kind, data); err != nil {
+			fmt.Println("Could not send "+kind+" email:", err)
+		}
+
+	}()
+
+}
+
+func sendSubmissionReceiptEmail(definition *Definition) {
+
+	data := mail.TemplateData{"DefinitionTitle": definition.Title}
+	sendLifecycleEmail(definition.SubmittedBy, EmailKindSubmissionReceipt, data)
+
+}
+
+func sendDefinitionApprovedEmail(definition *Definition) {
+
+	data := mail.TemplateData{"DefinitionID": definition.ID.Hex(), "DefinitionTitle": definition.Title}
+	sendLifecycleEmail(definition.SubmittedBy, EmailKindDefinitionApproved, data)
+
+}
+
+func sendDefinitionRejectedEmail(definition *Definition, rejection *types.Rejection) {
+
+	data := mail.TemplateData{"DefinitionTitle": definition.Title, "Rejection": rejection.Content}
+	sendLifecycleEmail(definition.SubmittedBy, EmailKindDefinitionRejected, data)
+
+}
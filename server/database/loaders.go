@@ -0,0 +1,154 @@
+package database
+
+import (
+	"yacoid_server/common"
+	"yacoid_server/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+The functions in this file load many entities with a single query. They are used to batch the
+lookups of nested queries and apply the same visibility rules as the single-entity functions.
+*/
+
+type TagCount struct {
+	Name  string `bson:"_id" json:"name"`
+	Count int    `bson:"count" json:"count"`
+}
+
+func GetAuthorsByIds(ids []primitive.ObjectID, user *User) ([]*types.Author, error) {
+	return getAuthors(withVisibility(bson.M{"_id": bson.M{"$in": ids}}, user), nil)
+}
+
+func GetSourcesByIds(ids []primitive.ObjectID, user *User) ([]*types.Source, error) {
+	return getSources(withVisibility(bson.M{"_id": bson.M{"$in": ids}}, user), nil)
+}
+
+func GetSourcesByAuthorIds(authorIds []primitive.ObjectID, user *User) ([]*types.Source, error) {
+	options := options.Find().SetSort(bson.D{{Key: "year", Value: -1}, {Key: "title", Value: 1}})
+	return getSources(withVisibility(bson.M{"authors": bson.M{"$in": authorIds}}, user), options)
+}
+
+func GetDefinitionsByIds(ids []primitive.ObjectID, user *User) ([]*Definition, error) {
+	return getDefinitions(withVisibility(bson.M{"_id": bson.M{"$in": ids}}, user), nil)
+}
+
+func GetDefinitionsBySourceIds(sourceIds []primitive.ObjectID, user *User) ([]*Definition, error) {
+	options := options.Find().SetSort(bson.M{"approved_date": -1})
+	return getDefinitions(withVisibility(bson.M{"source": bson.M{"$in": sourceIds}}, user), options)
+}
+
+func GetDefinitionsByTags(tags []string, user *User) ([]*Definition, error) {
+	options := options.Find().SetSort(bson.M{"approved_date": -1})
+	return getDefinitions(withVisibility(bson.M{"tags": bson.M{"$in": tags}}, user), options)
+}
+
+/*
+GetPublicUsersByIds loads users projected to their public fields.
+*/
+func GetPublicUsersByIds(ids []primitive.ObjectID) ([]*types.PublicUser, error) {
+
+	options := options.Find().SetProjection(bson.M{"_id": 1, "first_name": 1, "last_name": 1})
+	cursor, err := userCollection.Find(dbContext, bson.M{"_id": bson.M{"$in": ids}}, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	users := []*types.PublicUser{}
+
+	if err := cursor.All(dbContext, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+
+}
+
+/*
+FindDefinitions returns one page of the definitions visible to the user, newest approvals first.
+*/
+func FindDefinitions(pageSize int, page int, definitionFilter *types.DefinitionFilter, user *User) ([]*Definition, error) {
+
+	if pageSize <= 0 || pageSize > maxPageSize || page <= 0 {
+		return nil, common.ErrorInvalidType
+	}
+
+	filterQuery, filterError := CreateFilterQuery(definitionFilter)
+
+	if filterError != nil {
+		return nil, filterError
+	}
+
+	filter := bson.M{}
+	for _, element := range filterQuery {
+		filter[element.Key] = element.Value
+	}
+
+	options := options.Find()
+	options.SetSort(bson.M{"approved_date": -1})
+	options.SetLimit(int64(pageSize))
+	options.SetSkip(int64((page - 1) * pageSize))
+
+	return getDefinitions(withVisibility(filter, user), options)
+
+}
+
+/*
+FindSources returns one page of the sources visible to the user without populating the authors.
+*/
+func FindSources(pageSize int, page int, search *string, year *int, user *User) ([]*types.Source, error) {
+
+	if pageSize <= 0 || pageSize > maxPageSize || page <= 0 {
+		return nil, common.ErrorInvalidType
+	}
+
+	searchQuery, queryError := createSourceSearchQuery(search, year)
+
+	if queryError != nil {
+		return nil, queryError
+	}
+
+	options := options.Find()
+	options.SetSort(bson.D{{Key: "title", Value: 1}})
+	options.SetLimit(int64(pageSize))
+	options.SetSkip(int64((page - 1) * pageSize))
+
+	return getSources(withVisibility(searchQuery, user), options)
+
+}
+
+/*
+GetTags returns all tags of approved definitions with the number of definitions using them.
+*/
+func GetTags() ([]*TagCount, error) {
+
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"approved": true}},
+		bson.M{"$unwind": "$tags"},
+		bson.M{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+		bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+	}
+
+	cursor, err := definitionsCollection.Aggregate(dbContext, pipeline)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	tags := []*TagCount{}
+
+	if err := cursor.All(dbContext, &tags); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+
+}
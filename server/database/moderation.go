@@ -0,0 +1,526 @@
+package database
+
+import (
+	"errors"
+	"time"
+	"yacoid_server/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrorAuthorNotApproved = errors.New("AUTHOR_NOT_APPROVED")
+var ErrorAuthorAlreadyApproved = errors.New("AUTHOR_ALREADY_APPROVED")
+var ErrorSourceNotApproved = errors.New("SOURCE_NOT_APPROVED")
+var ErrorSourceAlreadyApproved = errors.New("SOURCE_ALREADY_APPROVED")
+var ErrorRejectionNotAnsweredYet = errors.New("REJECTION_NOT_ANSWERED_YET")
+var ErrorDefinitionHasPendingDependencies = errors.New("DEFINITION_HAS_PENDING_DEPENDENCIES")
+
+/*
+GetOptionalUser returns the user of the auth token or nil, if the request is anonymous or the token is invalid.
+*/
+func GetOptionalUser(authToken string) *User {
+
+	if len(authToken) == 0 {
+		return nil
+	}
+
+	user, err := GetUserByAuthToken(authToken)
+
+	if err != nil {
+		return nil
+	}
+
+	return user
+
+}
+
+/*
+createVisibilityFilter restricts authors and sources to the approved ones. Users additionally see their
+own pending or rejected submissions, admins see everything.
+*/
+func createVisibilityFilter(user *User) bson.M {
+
+	if user == nil {
+		return bson.M{"approved": true}
+	}
+
+	if user.Admin {
+		return bson.M{}
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{"approved": true},
+		bson.M{"submitted_by": user.ID},
+	}}
+
+}
+
+func isVisibleTo(approved bool, submittedBy primitive.ObjectID, user *User) bool {
+	return approved || (user != nil && (user.Admin || submittedBy == user.ID))
+}
+
+func withVisibility(filter bson.M, user *User) bson.M {
+
+	visibilityFilter := createVisibilityFilter(user)
+
+	if len(visibilityFilter) == 0 {
+		return filter
+	}
+
+	if len(filter) == 0 {
+		return visibilityFilter
+	}
+
+	return bson.M{"$and": bson.A{filter, visibilityFilter}}
+
+}
+
+/*
+isUsableBy reports whether a catalog entry may be referenced in a submission of the user:
+approved entries can be used by everyone, pending entries only by their submitter.
+*/
+func isUsableBy(approved bool, submittedBy primitive.ObjectID, rejectionLog []*types.Rejection, lastSubmitChangeDate time.Time, user *User) bool {
+
+	if approved {
+		return true
+	}
+
+	return submittedBy == user.ID && !types.IsRejected(rejectionLog, lastSubmitChangeDate)
+
+}
+
+func validateAuthorsUsable(ids *[]primitive.ObjectID, user *User) error {
+
+	for _, id := range *ids {
+
+		author, err := GetAuthor(id)
+
+		if err != nil {
+			return err
+		}
+
+		if !isUsableBy(author.Approved, author.SubmittedBy, author.RejectionLog, author.LastSubmitChangeDate, user) {
+			return ErrorAuthorNotApproved
+		}
+
+	}
+
+	return nil
+
+}
+
+func validateSourceUsable(id primitive.ObjectID, user *User) error {
+
+	source, err := GetSource(id)
+
+	if err != nil {
+		return err
+	}
+
+	if !isUsableBy(source.Approved, source.SubmittedBy, source.RejectionLog, source.LastSubmitChangeDate, user) {
+		return ErrorSourceNotApproved
+	}
+
+	return validateAuthorsUsable(&source.Authors, user)
+
+}
+
+/*
+approveEntry approves a pending author or source. The caller has to make sure that the entry exists.
+*/
+func approveEntry(collection *mongo.Collection, id primitive.ObjectID, user *User, alreadyApprovedError error) error {
+
+	filter := bson.M{"_id": id, "approved": false}
+	update := bson.M{
+		"$set": bson.M{
+			"approved_by":   user.ID,
+			"approved_date": time.Now(),
+			"approved":      true,
+		},
+	}
+
+	result, err := collection.UpdateOne(dbContext, filter, update)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return alreadyApprovedError
+	}
+
+	return nil
+
+}
+
+func rejectEntry(collection *mongo.Collection, id primitive.ObjectID, user *User, content string, rejectionLog []*types.Rejection, lastSubmitChangeDate time.Time) error {
+
+	if types.IsRejected(rejectionLog, lastSubmitChangeDate) {
+		return ErrorRejectionNotAnsweredYet
+	}
+
+	rejection := types.Rejection{
+		ID:           primitive.NewObjectID(),
+		RejectedBy:   user.ID,
+		RejectedDate: time.Now(),
+		Content:      content,
+	}
+
+	update := bson.M{
+		"$push": bson.M{
+			"rejection_log": rejection,
+		},
+	}
+
+	_, err := collection.UpdateByID(dbContext, id, update)
+	return err
+
+}
+
+func getAdmin(authToken string) (*User, error) {
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	if user.Admin == false {
+		return nil, ErrorNotEnoughPermissions
+	}
+
+	return user, nil
+
+}
+
+func ApproveAuthor(authorId string, authToken string, client *AuditClient) error {
+
+	user, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return userError
+	}
+
+	author, findError := GetAuthorById(authorId)
+
+	if findError != nil {
+		return findError
+	}
+
+	approveError := approveEntry(authorsCollection, author.ID, user, ErrorAuthorAlreadyApproved)
+
+	if approveError != nil {
+		return approveError
+	}
+
+	recordAudit(createAuditEntry(client, &user.ID, AuditActionAuthorApprove, AuditTargetAuthor, author.ID, bson.M{"approved": false}, bson.M{"approved": true}))
+
+	updateSearchIndex(func() error {
+		return refreshIndexedAuthors([]primitive.ObjectID{author.ID})
+	})
+
+	return nil
+
+}
+
+func RejectAuthor(authorId string, authToken string, content string, client *AuditClient) error {
+
+	user, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return userError
+	}
+
+	author, findError := GetAuthorById(authorId)
+
+	if findError != nil {
+		return findError
+	}
+
+	if author.Approved {
+		return ErrorAuthorAlreadyApproved
+	}
+
+	rejectError := rejectEntry(authorsCollection, author.ID, user, content, author.RejectionLog, author.LastSubmitChangeDate)
+
+	if rejectError != nil {
+		return rejectError
+	}
+
+	recordAudit(createAuditEntry(client, &user.ID, AuditActionAuthorReject, AuditTargetAuthor, author.ID, nil, bson.M{"content": content}))
+
+	return nil
+
+}
+
+func ApproveSource(sourceId string, authToken string, client *AuditClient) error {
+
+	user, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return userError
+	}
+
+	source, findError := GetSourceById(sourceId)
+
+	if findError != nil {
+		return findError
+	}
+
+	pendingAuthorCount, countError := authorsCollection.CountDocuments(dbContext, bson.M{"_id": bson.M{"$in": source.Authors}, "approved": false})
+
+	if countError != nil {
+		return countError
+	}
+
+	if pendingAuthorCount > 0 {
+		return ErrorAuthorNotApproved
+	}
+
+	approveError := approveEntry(sourcesCollection, source.ID, user, ErrorSourceAlreadyApproved)
+
+	if approveError != nil {
+		return approveError
+	}
+
+	recordAudit(createAuditEntry(client, &user.ID, AuditActionSourceApprove, AuditTargetSource, source.ID, bson.M{"approved": false}, bson.M{"approved": true}))
+
+	return nil
+
+}
+
+func RejectSource(sourceId string, authToken string, content string, client *AuditClient) error {
+
+	user, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return userError
+	}
+
+	source, findError := GetSourceById(sourceId)
+
+	if findError != nil {
+		return findError
+	}
+
+	if source.Approved {
+		return ErrorSourceAlreadyApproved
+	}
+
+	rejectError := rejectEntry(sourcesCollection, source.ID, user, content, source.RejectionLog, source.LastSubmitChangeDate)
+
+	if rejectError != nil {
+		return rejectError
+	}
+
+	recordAudit(createAuditEntry(client, &user.ID, AuditActionSourceReject, AuditTargetSource, source.ID, nil, bson.M{"content": content}))
+
+	return nil
+
+}
+
+/*
+approveDefinitionDependencies approves the pending source of a definition together with its pending authors.
+Rejected dependencies are not approved implicitly.
+*/
+func approveDefinitionDependencies(definition *Definition, user *User, client *AuditClient) error {
+
+	source, sourceError := GetSource(definition.Source)
+
+	if sourceError != nil {
+		return sourceError
+	}
+
+	if types.IsRejected(source.RejectionLog, source.LastSubmitChangeDate) {
+		return ErrorSourceNotApproved
+	}
+
+	authors, authorsError := getAuthors(bson.M{"_id": bson.M{"$in": source.Authors}, "approved": false}, nil)
+
+	if authorsError != nil {
+		return authorsError
+	}
+
+	for _, author := range authors {
+		if types.IsRejected(author.RejectionLog, author.LastSubmitChangeDate) {
+			return ErrorAuthorNotApproved
+		}
+	}
+
+	authorIds := []primitive.ObjectID{}
+
+	for _, author := range authors {
+
+		err := approveEntry(authorsCollection, author.ID, user, ErrorAuthorAlreadyApproved)
+
+		if err != nil && err != ErrorAuthorAlreadyApproved {
+			return err
+		}
+
+		if err == nil {
+			recordAudit(createAuditEntry(client, &user.ID, AuditActionAuthorApprove, AuditTargetAuthor, author.ID, bson.M{"approved": false}, bson.M{"approved": true}))
+		}
+
+		authorIds = append(authorIds, author.ID)
+	}
+
+	if len(authorIds) > 0 {
+		updateSearchIndex(func() error {
+			return refreshIndexedAuthors(authorIds)
+		})
+	}
+
+	if !source.Approved {
+
+		err := approveEntry(sourcesCollection, source.ID, user, ErrorSourceAlreadyApproved)
+
+		if err != nil && err != ErrorSourceAlreadyApproved {
+			return err
+		}
+
+		if err == nil {
+			recordAudit(createAuditEntry(client, &user.ID, AuditActionSourceApprove, AuditTargetSource, source.ID, bson.M{"approved": false}, bson.M{"approved": true}))
+		}
+	}
+
+	return nil
+
+}
+
+/*
+hasPendingDependencies reports whether the source of the definition or one of its authors is not approved yet.
+*/
+func hasPendingDependencies(definition *Definition) (bool, error) {
+
+	source, sourceError := GetSource(definition.Source)
+
+	if sourceError != nil {
+		return false, sourceError
+	}
+
+	if !source.Approved {
+		return true, nil
+	}
+
+	pendingAuthorCount, countError := authorsCollection.CountDocuments(dbContext, bson.M{"_id": bson.M{"$in": source.Authors}, "approved": false})
+
+	if countError != nil {
+		return false, countError
+	}
+
+	return pendingAuthorCount > 0, nil
+
+}
+
+/*
+getVisibleRejectionLog returns the rejections of an author or source. They are only shown to the submitter,
+who has to answer them, and to admins.
+*/
+func getVisibleRejectionLog(rejectionLog []*types.Rejection, submittedBy primitive.ObjectID, user *User) ([]*types.Rejection, error) {
+
+	if !user.Admin && submittedBy != user.ID {
+		return nil, ErrorNotEnoughPermissions
+	}
+
+	if rejectionLog == nil {
+		return []*types.Rejection{}, nil
+	}
+
+	return rejectionLog, nil
+
+}
+
+func GetAuthorRejections(authorId string, authToken string) ([]*types.Rejection, error) {
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	author, findError := GetAuthorById(authorId)
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	return getVisibleRejectionLog(author.RejectionLog, author.SubmittedBy, user)
+
+}
+
+func GetSourceRejections(sourceId string, authToken string) ([]*types.Rejection, error) {
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	source, findError := GetSourceById(sourceId)
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	return getVisibleRejectionLog(source.RejectionLog, source.SubmittedBy, user)
+
+}
+
+/*
+GetPendingAuthors returns all authors waiting for moderation.
+*/
+func GetPendingAuthors(authToken string) ([]*types.Author, error) {
+
+	_, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	return getAuthors(bson.M{"approved": false}, nil)
+
+}
+
+/*
+GetPendingSources returns all sources waiting for moderation.
+*/
+func GetPendingSources(authToken string) ([]*types.PopulatedSource, error) {
+
+	user, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	sources, findError := getSources(bson.M{"approved": false}, nil)
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	return PopulateSources(sources, user)
+
+}
+
+/*
+migrateModerationState marks authors and sources created before the moderation workflow existed as approved.
+*/
+func migrateModerationState() error {
+
+	filter := bson.M{"approved": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"approved": true, "rejection_log": bson.A{}}}
+
+	for _, collection := range []*mongo.Collection{authorsCollection, sourcesCollection} {
+
+		_, err := collection.UpdateMany(dbContext, filter, update)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
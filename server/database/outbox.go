@@ -0,0 +1,316 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"yacoid_server/mail"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrorOutboxMailNotFound = errors.New("OUTBOX_MAIL_NOT_FOUND")
+
+const (
+	OutboxMailPending = "pending"
+	OutboxMailSent    = "sent"
+	OutboxMailDead    = "dead"
+)
+
+const maxMailAttempts = 6
+const mailRetryBaseDelay = time.Minute
+const mailPollInterval = 30 * time.Second
+const mailSendLease = 2 * time.Minute
+const sentMailRetention = 30 * 24 * time.Hour
+const deadMailRetention = 14 * 24 * time.Hour
+const maxDeadMails = 100
+
+var mailer mail.Mailer
+
+/*
+OutboxMail is a queued email. Mails that still fail after maxMailAttempts are moved to the dead letters,
+from where an admin can retry them until they are removed after deadMailRetention. The bodies may contain tokens,
+so they are never part of the JSON representation and are dropped once the mail is sent.
+Sent mails are kept without their bodies for sentMailRetention. Both retentions are enforced by TTL indexes.
+*/
+type OutboxMail struct {
+	ID              primitive.ObjectID `bson:"_id" json:"id"`
+	To              string             `bson:"to" json:"to"`
+	Subject         string             `bson:"subject" json:"subject"`
+	HTMLBody        string             `bson:"html_body" json:"-"`
+	TextBody        string             `bson:"text_body,omitempty" json:"-"`
+	Status          string             `bson:"status" json:"status"`
+	Attempts        int                `bson:"attempts" json:"attempts"`
+	LastError       *string            `bson:"last_error,omitempty" json:"lastError,omitempty"`
+	CreatedDate     time.Time          `bson:"created_date" json:"createdDate"`
+	NextAttemptDate *time.Time         `bson:"next_attempt_date,omitempty" json:"nextAttemptDate,omitempty"`
+	SentDate        *time.Time         `bson:"sent_date,omitempty" json:"sentDate,omitempty"`
+	DeadDate        *time.Time         `bson:"dead_date,omitempty" json:"deadDate,omitempty"`
+}
+
+func (outboxMail *OutboxMail) message() *mail.Message {
+	return &mail.Message{To: outboxMail.To, Subject: outboxMail.Subject, HTMLBody: outboxMail.HTMLBody, TextBody: outboxMail.TextBody}
+}
+
+/*
+sendTemplatedMail renders the template in the given language and queues the mail in the outbox.
+The returned error only reports whether the mail could be queued, the delivery happens in the background.
+*/
+func sendTemplatedMail(email string, language string, name string, data mail.TemplateData) error {
+
+	message, err := mail.RenderTemplate(email, name, language, data)
+
+	if err != nil {
+		return err
+	}
+
+	return enqueueMail(message)
+
+}
+
+/*
+PreviewMailTemplate renders a template with sample data, without sending it.
+*/
+func PreviewMailTemplate(name string, language string, authToken string) (*mail.Message, error) {
+
+	admin, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	data, found := mail.TemplateSamples[name]
+
+	if !found {
+		return nil, mail.ErrorUnknownTemplate
+	}
+
+	return mail.RenderTemplate(admin.Email, name, language, data)
+
+}
+
+func enqueueMail(message *mail.Message) error {
+
+	now := time.Now()
+
+	outboxMail := OutboxMail{
+		ID:              primitive.NewObjectID(),
+		To:              message.To,
+		Subject:         message.Subject,
+		HTMLBody:        message.HTMLBody,
+		TextBody:        message.TextBody,
+		Status:          OutboxMailPending,
+		Attempts:        0,
+		CreatedDate:     now,
+		NextAttemptDate: &now,
+	}
+
+	_, err := mailOutboxCollection.InsertOne(dbContext, outboxMail)
+
+	if err != nil {
+		return err
+	}
+
+	if mailer != nil {
+		go attemptMail(outboxMail.ID)
+	}
+
+	return nil
+
+}
+
+/*
+StartMailOutbox sends the queued mails with the mailer and periodically retries the failed ones.
+*/
+func StartMailOutbox(outboxMailer mail.Mailer) {
+
+	mailer = outboxMailer
+
+	go func() {
+
+		ticker := time.NewTicker(mailPollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+
+			filter := bson.M{"status": OutboxMailPending, "next_attempt_date": bson.M{"$lte": time.Now()}}
+			options := options.Find().SetSort(bson.D{{Key: "next_attempt_date", Value: 1}}).SetProjection(bson.M{"_id": 1})
+			mails, err := getOutboxMails(filter, options)
+
+			if err != nil {
+				fmt.Println("Could not send queued mails:", err)
+				continue
+			}
+
+			for _, outboxMail := range mails {
+				attemptMail(outboxMail.ID)
+			}
+		}
+
+	}()
+
+}
+
+/*
+attemptMail sends a due mail once. Like webhook deliveries, the mail is claimed first, so that it is never sent twice at the same time.
+*/
+func attemptMail(id primitive.ObjectID) {
+
+	now := time.Now()
+
+	filter := bson.M{"_id": id, "status": OutboxMailPending, "next_attempt_date": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"next_attempt_date": now.Add(mailSendLease)}}
+
+	var outboxMail OutboxMail
+	err := mailOutboxCollection.FindOneAndUpdate(dbContext, filter, update).Decode(&outboxMail)
+
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			fmt.Println("Could not claim queued mail:", err)
+		}
+		return
+	}
+
+	sendError := mailer.Send(outboxMail.message())
+	attempts := outboxMail.Attempts + 1
+
+	var result bson.M
+
+	switch {
+	case sendError == nil:
+		result = bson.M{
+			"$set":   bson.M{"status": OutboxMailSent, "attempts": attempts, "sent_date": time.Now()},
+			"$unset": bson.M{"next_attempt_date": "", "last_error": "", "html_body": "", "text_body": ""},
+		}
+	case attempts >= maxMailAttempts:
+		fmt.Println("Giving up on mail to", outboxMail.To+":", sendError)
+		result = bson.M{
+			"$set":   bson.M{"status": OutboxMailDead, "attempts": attempts, "last_error": sendError.Error(), "dead_date": time.Now()},
+			"$unset": bson.M{"next_attempt_date": ""},
+		}
+	default:
+		result = bson.M{
+			"$set": bson.M{
+				"attempts":          attempts,
+				"last_error":        sendError.Error(),
+				"next_attempt_date": time.Now().Add(mailRetryBaseDelay * time.Duration(1<<(attempts-1))),
+			},
+		}
+	}
+
+	if _, err := mailOutboxCollection.UpdateOne(dbContext, bson.M{"_id": id}, result); err != nil {
+		fmt.Println("Could not update queued mail:", err)
+	}
+
+}
+
+/*
+GetDeadMails returns the mails that could not be sent, newest first.
+*/
+func GetDeadMails(authToken string) ([]*OutboxMail, error) {
+
+	_, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	options := options.Find().SetSort(bson.D{{Key: "created_date", Value: -1}}).SetLimit(maxDeadMails)
+	return getOutboxMails(bson.M{"status": OutboxMailDead}, options)
+
+}
+
+/*
+RetryDeadMail queues a dead mail again with a fresh number of attempts.
+*/
+func RetryDeadMail(id string, authToken string) error {
+
+	_, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return userError
+	}
+
+	objectId, idError := primitive.ObjectIDFromHex(id)
+
+	if idError != nil {
+		return InvalidID
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"status": OutboxMailPending, "attempts": 0, "next_attempt_date": time.Now()},
+		"$unset": bson.M{"last_error": "", "dead_date": ""},
+	}
+
+	result, err := mailOutboxCollection.UpdateOne(dbContext, bson.M{"_id": objectId, "status": OutboxMailDead}, update)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrorOutboxMailNotFound
+	}
+
+	if mailer != nil {
+		go attemptMail(objectId)
+	}
+
+	return nil
+
+}
+
+/*
+migrateOutboxRetention drops the bodies of mails sent before they were dropped on sending
+and starts the retention of dead mails that were kept without a dead date.
+*/
+func migrateOutboxRetention() error {
+
+	_, err := mailOutboxCollection.UpdateMany(dbContext,
+		bson.M{"status": OutboxMailSent, "html_body": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"html_body": "", "text_body": ""}},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = mailOutboxCollection.UpdateMany(dbContext,
+		bson.M{"status": OutboxMailDead, "dead_date": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"dead_date": time.Now()}},
+	)
+
+	return err
+
+}
+
+func getOutboxMails(filter interface{}, options *options.FindOptions) ([]*OutboxMail, error) {
+
+	cursor, err := mailOutboxCollection.Find(dbContext, filter, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	mails := []*OutboxMail{}
+
+	for cursor.Next(dbContext) {
+
+		outboxMail := OutboxMail{}
+		err := cursor.Decode(&outboxMail)
+
+		if err != nil {
+			return nil, err
+		}
+
+		mails = append(mails, &outboxMail)
+	}
+
+	return mails, nil
+
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"yacoid_server/constants"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrorInvalidPasswordHash = errors.New("INVALID_PASSWORD_HASH")
+var ErrorLegacyLoginRetired = errors.New("LEGACY_LOGIN_RETIRED")
+
+const (
+	PasswordHasherArgon2id = "argon2id"
+	PasswordHasherBcrypt   = "bcrypt"
+)
+
+/* parameters of new argon2id hashes, as recommended by RFC 9106 for memory-constrained environments */
+const argon2Time = 3
+const argon2Memory = 64 * 1024
+const argon2Threads = 4
+const argon2KeyLength = 32
+const argon2SaltLength = 16
+
+const bcryptCost = 12
+
+/*
+Passwords are stored in the PHC string format, e.g. "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>", or in the format of bcrypt.
+Password hashes of accounts that were not migrated yet are the value the client sent at registration,
+which was checked with a challenge derived from the password salt. They never start with "$".
+*/
+func isLegacyPasswordHash(passwordHash string) bool {
+	return !strings.HasPrefix(passwordHash, "$")
+}
+
+func getPasswordHasher() string {
+
+	if os.Getenv(constants.EnvKeyPasswordHasher) == PasswordHasherBcrypt {
+		return PasswordHasherBcrypt
+	}
+
+	return PasswordHasherArgon2id
+
+}
+
+/*
+hashPassword hashes the password with the configured algorithm.
+*/
+func hashPassword(password string) (string, error) {
+
+	if getPasswordHasher() == PasswordHasherBcrypt {
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+
+		if err != nil {
+			return "", err
+		}
+
+		return string(hash), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+
+}
+
+/*
+verifyPassword checks the password against a hash created by hashPassword. needsRehash reports whether
+the hash was created with another algorithm or other parameters than new hashes.
+*/
+func verifyPassword(passwordHash string, password string) (correct bool, needsRehash bool, err error) {
+
+	if strings.HasPrefix(passwordHash, "$argon2id$") {
+
+		var version int
+		var memory uint32
+		var time uint32
+		var threads uint8
+
+		parts := strings.Split(passwordHash, "$")
+
+		if len(parts) != 6 {
+			return false, false, ErrorInvalidPasswordHash
+		}
+
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return false, false, ErrorInvalidPasswordHash
+		}
+
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+			return false, false, ErrorInvalidPasswordHash
+		}
+
+		salt, saltError := base64.RawStdEncoding.DecodeString(parts[4])
+		key, keyError := base64.RawStdEncoding.DecodeString(parts[5])
+
+		if saltError != nil || keyError != nil || len(key) == 0 {
+			return false, false, ErrorInvalidPasswordHash
+		}
+
+		computed := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+		correct := subtle.ConstantTimeCompare(computed, key) == 1
+		outdated := getPasswordHasher() != PasswordHasherArgon2id || memory != argon2Memory || time != argon2Time || threads != argon2Threads || len(key) != argon2KeyLength
+
+		return correct, outdated, nil
+	}
+
+	cost, costError := bcrypt.Cost([]byte(passwordHash))
+
+	if costError != nil {
+		return false, false, ErrorInvalidPasswordHash
+	}
+
+	compareError := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
+
+	if compareError != nil {
+		if compareError == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		return false, false, compareError
+	}
+
+	return true, getPasswordHasher() != PasswordHasherBcrypt || cost != bcryptCost, nil
+
+}
+
+var dummyPasswordHash string
+var dummyPasswordHashOnce sync.Once
+
+/*
+verifyDummyPassword is called for unknown email addresses, so that the response time does not reveal whether an account exists.
+*/
+func verifyDummyPassword(password string) {
+
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = hashPassword(uuid.NewString())
+	})
+
+	verifyPassword(dummyPasswordHash, password)
+
+}
+
+/*
+isLegacyLoginEnabled reports whether the salted challenge login of v1 is still accepted. It puts the credentials
+into the URL, so it is disabled unless LEGACY_LOGIN_ENABLED=true is set for clients that were not updated yet.
+*/
+func isLegacyLoginEnabled() bool {
+
+	enabled, err := strconv.ParseBool(os.Getenv(constants.EnvKeyLegacyLoginEnabled))
+	return err == nil && enabled
+
+}
+
+/*
+GetPasswordMigrationStatus returns how many of all accounts still have a legacy password hash.
+The legacy login can be disabled once no legacy hashes remain.
+*/
+func GetPasswordMigrationStatus(authToken string) (int64, int64, error) {
+
+	_, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return 0, 0, userError
+	}
+
+	legacy, err := userCollection.CountDocuments(dbContext, bson.M{"password_hash": bson.M{"$not": primitive.Regex{Pattern: "^\\$"}}})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	total, err := userCollection.CountDocuments(dbContext, bson.M{})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return legacy, total, nil
+
+}
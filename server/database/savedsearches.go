@@ -0,0 +1,465 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"yacoid_server/constants"
+	"yacoid_server/mail"
+	"yacoid_server/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrorSavedSearchNotFound = errors.New("SAVED_SEARCH_NOT_FOUND")
+var ErrorTooManySavedSearches = errors.New("TOO_MANY_SAVED_SEARCHES")
+
+const maxSavedSearchesPerUser = 50
+const maxNotifications = 100
+const defaultNotificationDigestInterval = time.Hour
+
+/*
+SavedSearch is a named filter of a user. Query is the query string the filter was compiled from, if any.
+*/
+type SavedSearch struct {
+	ID          primitive.ObjectID      `bson:"_id" json:"id"`
+	UserID      primitive.ObjectID      `bson:"user_id" json:"-"`
+	Name        string                  `bson:"name" json:"name"`
+	Query       *string                 `bson:"query,omitempty" json:"query,omitempty"`
+	Filter      *types.DefinitionFilter `bson:"filter" json:"filter"`
+	NotifyInApp bool                    `bson:"notify_in_app" json:"notifyInApp"`
+	NotifyEmail bool                    `bson:"notify_email" json:"notifyEmail"`
+	CreatedDate time.Time               `bson:"created_date" json:"createdDate"`
+}
+
+/*
+Notification tells a user that a newly approved definition matches one of their saved searches.
+Notifications only meant for email are not shown in the app, EmailPending marks those not mailed yet.
+*/
+type Notification struct {
+	ID              primitive.ObjectID `bson:"_id" json:"id"`
+	UserID          primitive.ObjectID `bson:"user_id" json:"-"`
+	SavedSearchID   primitive.ObjectID `bson:"saved_search_id" json:"savedSearchId"`
+	SavedSearchName string             `bson:"saved_search_name" json:"savedSearchName"`
+	DefinitionID    primitive.ObjectID `bson:"definition_id" json:"definitionId"`
+	DefinitionTitle string             `bson:"definition_title" json:"definitionTitle"`
+	CreatedDate     time.Time          `bson:"created_date" json:"createdDate"`
+	InApp           bool               `bson:"in_app" json:"-"`
+	Read            bool               `bson:"read" json:"read"`
+	EmailPending    bool               `bson:"email_pending" json:"-"`
+}
+
+/*
+CreateSavedSearch saves the filter of the request. Query strings have to be compiled into request.Filter by the caller.
+*/
+func CreateSavedSearch(request *types.SaveSearchRequest, authToken string) (*SavedSearch, error) {
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	count, countError := savedSearchesCollection.CountDocuments(dbContext, bson.M{"user_id": user.ID})
+
+	if countError != nil {
+		return nil, countError
+	}
+
+	if count >= maxSavedSearchesPerUser {
+		return nil, ErrorTooManySavedSearches
+	}
+
+	savedSearch := SavedSearch{
+		ID:          primitive.NewObjectID(),
+		UserID:      user.ID,
+		Name:        strings.TrimSpace(request.Name),
+		Query:       request.Query,
+		Filter:      request.Filter,
+		NotifyInApp: request.NotifyInApp,
+		NotifyEmail: request.NotifyEmail,
+		CreatedDate: time.Now(),
+	}
+
+	if savedSearch.Filter == nil {
+		savedSearch.Filter = &types.DefinitionFilter{}
+	}
+
+	_, err := savedSearchesCollection.InsertOne(dbContext, savedSearch)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &savedSearch, nil
+
+}
+
+func GetSavedSearches(authToken string) ([]*SavedSearch, error) {
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	options := options.Find().SetSort(bson.D{{Key: "created_date", Value: 1}})
+	return getSavedSearches(bson.M{"user_id": user.ID}, options)
+
+}
+
+func DeleteSavedSearch(id string, authToken string) error {
+
+	objectId, objectIdError := primitive.ObjectIDFromHex(id)
+
+	if objectIdError != nil {
+		return InvalidID
+	}
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return userError
+	}
+
+	/* searches of other users are reported as missing, so that their IDs cannot be probed */
+	result, err := savedSearchesCollection.DeleteOne(dbContext, bson.M{"_id": objectId, "user_id": user.ID})
+
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrorSavedSearchNotFound
+	}
+
+	return nil
+
+}
+
+/*
+GetNotifications returns the newest in-app notifications of the user.
+*/
+func GetNotifications(authToken string, unreadOnly bool) ([]*Notification, error) {
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	filter := bson.M{"user_id": user.ID, "in_app": true}
+
+	if unreadOnly {
+		filter["read"] = false
+	}
+
+	options := options.Find().SetSort(bson.D{{Key: "created_date", Value: -1}}).SetLimit(maxNotifications)
+	return getNotifications(filter, options)
+
+}
+
+/*
+MarkNotificationsRead marks the given notifications of the user as read, or all of them if no IDs are given.
+*/
+func MarkNotificationsRead(ids []string, authToken string) error {
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return userError
+	}
+
+	filter := bson.M{"user_id": user.ID, "read": false}
+
+	if len(ids) > 0 {
+
+		objectIds := []primitive.ObjectID{}
+
+		for _, id := range ids {
+
+			objectId, err := primitive.ObjectIDFromHex(id)
+
+			if err != nil {
+				return InvalidID
+			}
+
+			objectIds = append(objectIds, objectId)
+		}
+
+		filter["_id"] = bson.M{"$in": objectIds}
+	}
+
+	_, err := notificationsCollection.UpdateMany(dbContext, filter, bson.M{"$set": bson.M{"read": true}})
+	return err
+
+}
+
+/*
+matchSavedSearches creates notifications for all saved searches matching the approved definition.
+The submitter of the definition is not notified about their own definition.
+*/
+func matchSavedSearches(definitionId primitive.ObjectID) error {
+
+	definition, findError := GetDefinitionByObjectId(definitionId)
+
+	if findError != nil {
+		return findError
+	}
+
+	filter := bson.M{
+		"user_id": bson.M{"$ne": definition.SubmittedBy},
+		"$or":     bson.A{bson.M{"notify_in_app": true}, bson.M{"notify_email": true}},
+	}
+
+	savedSearches, searchesError := getSavedSearches(filter, nil)
+
+	if searchesError != nil {
+		return searchesError
+	}
+
+	now := time.Now()
+	notifications := []interface{}{}
+
+	for _, savedSearch := range savedSearches {
+
+		query, queryError := CreateFilterQuery(savedSearch.Filter)
+
+		if queryError != nil {
+			return queryError
+		}
+
+		query = append(query, bson.E{Key: "_id", Value: definitionId}, bson.E{Key: "approved", Value: true})
+		count, countError := definitionsCollection.CountDocuments(dbContext, query)
+
+		if countError != nil {
+			return countError
+		}
+
+		if count == 0 {
+			continue
+		}
+
+		notifications = append(notifications, Notification{
+			ID:              primitive.NewObjectID(),
+			UserID:          savedSearch.UserID,
+			SavedSearchID:   savedSearch.ID,
+			SavedSearchName: savedSearch.Name,
+			DefinitionID:    definition.ID,
+			DefinitionTitle: definition.Title,
+			CreatedDate:     now,
+			InApp:           savedSearch.NotifyInApp,
+			Read:            false,
+			EmailPending:    savedSearch.NotifyEmail,
+		})
+	}
+
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	_, err := notificationsCollection.InsertMany(dbContext, notifications)
+	return err
+
+}
+
+/*
+notifySavedSearches matches the definition in the background, so that approving does not wait for all saved searches.
+*/
+func notifySavedSearches(definitionId primitive.ObjectID) {
+
+	go func() {
+		if err := matchSavedSearches(definitionId); err != nil {
+			fmt.Println("Could not match saved searches:", err)
+		}
+	}()
+
+}
+
+/*
+StartNotificationDigests mails the pending notifications periodically. Every user receives at most one email per interval,
+which lists all of their new matches. The interval is read from NOTIFICATION_DIGEST_INTERVAL, e.g. "30m".
+*/
+func StartNotificationDigests() {
+
+	interval := defaultNotificationDigestInterval
+
+	if value := os.Getenv(constants.EnvKeyNotificationDigestInterval); len(value) > 0 {
+
+		parsed, err := time.ParseDuration(value)
+
+		if err != nil || parsed <= 0 {
+			panic(fmt.Sprintf("Invalid duration %q in %s\n", value, constants.EnvKeyNotificationDigestInterval))
+		}
+
+		interval = parsed
+	}
+
+	go func() {
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := sendNotificationDigests(); err != nil {
+				fmt.Println("Could not send notification digests:", err)
+			}
+		}
+
+	}()
+
+}
+
+/*
+sendNotificationDigests sends one email per user with all pending notifications. Notifications stay pending if their
+email could not be sent and are retried with the next digest.
+*/
+func sendNotificationDigests() error {
+
+	options := options.Find().SetSort(bson.D{{Key: "created_date", Value: 1}})
+	notifications, err := getNotifications(bson.M{"email_pending": true}, options)
+
+	if err != nil {
+		return err
+	}
+
+	userIds := []primitive.ObjectID{}
+	notificationsByUser := map[primitive.ObjectID][]*Notification{}
+
+	for _, notification := range notifications {
+
+		if _, exists := notificationsByUser[notification.UserID]; !exists {
+			userIds = append(userIds, notification.UserID)
+		}
+
+		notificationsByUser[notification.UserID] = append(notificationsByUser[notification.UserID], notification)
+	}
+
+	for _, userId := range userIds {
+
+		userNotifications := notificationsByUser[userId]
+		user, userError := GetUserById(userId)
+
+		if userError != nil && userError != ErrorUserNotFound {
+			fmt.Println("Could not send notification digest:", userError)
+			continue
+		}
+
+		if user != nil {
+			if mailError := sendNotificationDigest(user, userNotifications); mailError != nil {
+				fmt.Println("Could not send notification digest:", mailError)
+				continue
+			}
+		}
+
+		ids := []primitive.ObjectID{}
+		for _, notification := range userNotifications {
+			ids = append(ids, notification.ID)
+		}
+
+		_, updateError := notificationsCollection.UpdateMany(dbContext, bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$set": bson.M{"email_pending": false}})
+
+		if updateError != nil {
+			return updateError
+		}
+	}
+
+	return nil
+
+}
+
+func sendNotificationDigest(user *User, notifications []*Notification) error {
+
+	items := []mail.TemplateData{}
+
+	for _, notification := range notifications {
+		items = append(items, mail.TemplateData{
+			"DefinitionID":    notification.DefinitionID.Hex(),
+			"DefinitionTitle": notification.DefinitionTitle,
+			"SavedSearchName": notification.SavedSearchName,
+		})
+	}
+
+	data := mail.TemplateData{"FirstName": user.FirstName, "Notifications": items}
+	return sendTemplatedMail(user.Email, user.Language, mail.TemplateNotificationDigest, data)
+
+}
+
+/*
+deleteSavedSearchesOfUser removes the saved searches and notifications of a deleted user.
+*/
+func deleteSavedSearchesOfUser(sessionContext mongo.SessionContext, userId primitive.ObjectID) error {
+
+	for _, collection := range []*mongo.Collection{savedSearchesCollection, notificationsCollection} {
+
+		_, err := collection.DeleteMany(sessionContext, bson.M{"user_id": userId})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+func getSavedSearches(filter interface{}, options *options.FindOptions) ([]*SavedSearch, error) {
+
+	cursor, err := savedSearchesCollection.Find(dbContext, filter, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	savedSearches := []*SavedSearch{}
+
+	for cursor.Next(dbContext) {
+
+		savedSearch := SavedSearch{}
+		err := cursor.Decode(&savedSearch)
+
+		if err != nil {
+			return nil, err
+		}
+
+		savedSearches = append(savedSearches, &savedSearch)
+	}
+
+	return savedSearches, nil
+
+}
+
+func getNotifications(filter interface{}, options *options.FindOptions) ([]*Notification, error) {
+
+	cursor, err := notificationsCollection.Find(dbContext, filter, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	notifications := []*Notification{}
+
+	for cursor.Next(dbContext) {
+
+		notification := Notification{}
+		err := cursor.Decode(&notification)
+
+		if err != nil {
+			return nil, err
+		}
+
+		notifications = append(notifications, &notification)
+	}
+
+	return notifications, nil
+
+}
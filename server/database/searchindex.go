@@ -0,0 +1,513 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"yacoid_server/common"
+	"yacoid_server/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrorInvalidSearchKind = errors.New("INVALID_SEARCH_KIND")
+
+const (
+	SearchKindDefinition = "definition"
+	SearchKindTag        = "tag"
+	SearchKindAuthor     = "author"
+)
+
+var SearchKinds = []string{SearchKindDefinition, SearchKindTag, SearchKindAuthor}
+
+const fuzzyMinScore = 0.5
+const maxFuzzyResults = 1000
+const autocompleteMinScore = 0.6
+const maxAutocompleteResults = 50
+
+/*
+Suggestion is an autocompletion of the search box or one of the author and tag pickers.
+*/
+type Suggestion struct {
+	Kind  string  `json:"kind"`
+	ID    string  `json:"id,omitempty"`
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+}
+
+/*
+searchEntry is a definition, tag or author in the search index. Definitions are found by their title,
+tags and the names of their authors, authors by their names and name variants. The content of definitions
+is not indexed, since long texts contain the trigrams of almost every query.
+*/
+type searchEntry struct {
+	key      string
+	kind     string
+	id       primitive.ObjectID
+	text     string
+	trigrams []map[string]bool
+	tags     []string
+}
+
+type searchMatch struct {
+	entry       *searchEntry
+	containment float64
+	similarity  float64
+}
+
+/*
+trigramIndex is an in-process index of approved definitions, their tags and approved authors, which allows
+typo tolerant and prefix matching that Mongo's $text search does not offer. It is built at startup and
+refreshed whenever one of the indexed entities is approved or changed.
+*/
+type trigramIndex struct {
+	mutex    sync.RWMutex
+	entries  map[string]*searchEntry
+	postings map[string]map[string]*searchEntry
+
+	/* tagDefinitions holds the keys of the indexed definitions using a tag, the tag is removed with its last definition */
+	tagDefinitions map[string]map[string]bool
+}
+
+var searchIndex = newTrigramIndex()
+
+func newTrigramIndex() *trigramIndex {
+	return &trigramIndex{
+		entries:        map[string]*searchEntry{},
+		postings:       map[string]map[string]*searchEntry{},
+		tagDefinitions: map[string]map[string]bool{},
+	}
+}
+
+func createSearchKey(kind string, id string) string {
+	return kind + ":" + id
+}
+
+func (index *trigramIndex) add(kind string, id primitive.ObjectID, key string, text string, fields []string) {
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.insert(kind, id, key, text, fields)
+
+}
+
+/*
+insert replaces the entry of the key. The caller has to hold the write lock.
+*/
+func (index *trigramIndex) insert(kind string, id primitive.ObjectID, key string, text string, fields []string) *searchEntry {
+
+	index.remove(key)
+
+	entry := searchEntry{key: key, kind: kind, id: id, text: text}
+
+	for _, field := range fields {
+
+		trigrams := common.Trigrams(field)
+		entry.trigrams = append(entry.trigrams, trigrams)
+
+		for trigram := range trigrams {
+
+			if index.postings[trigram] == nil {
+				index.postings[trigram] = map[string]*searchEntry{}
+			}
+
+			index.postings[trigram][key] = &entry
+		}
+	}
+
+	index.entries[key] = &entry
+	return &entry
+
+}
+
+/*
+addDefinition indexes a definition and the tags it uses.
+*/
+func (index *trigramIndex) addDefinition(id primitive.ObjectID, title string, fields []string, tags []string) {
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	key := createSearchKey(SearchKindDefinition, id.Hex())
+
+	index.removeDefinition(key)
+	index.insert(SearchKindDefinition, id, key, title, fields).tags = tags
+
+	for _, tag := range tags {
+
+		if index.tagDefinitions[tag] == nil {
+			index.tagDefinitions[tag] = map[string]bool{}
+			index.insert(SearchKindTag, primitive.NilObjectID, createSearchKey(SearchKindTag, tag), tag, []string{tag})
+		}
+
+		index.tagDefinitions[tag][key] = true
+	}
+
+}
+
+/*
+removeDefinition deletes a definition and the tags no other indexed definition uses. The caller has to hold the write lock.
+*/
+func (index *trigramIndex) removeDefinition(key string) {
+
+	entry, exists := index.entries[key]
+
+	if !exists {
+		return
+	}
+
+	for _, tag := range entry.tags {
+
+		delete(index.tagDefinitions[tag], key)
+
+		if len(index.tagDefinitions[tag]) == 0 {
+			delete(index.tagDefinitions, tag)
+			index.remove(createSearchKey(SearchKindTag, tag))
+		}
+	}
+
+	index.remove(key)
+
+}
+
+func (index *trigramIndex) removeDefinitions(ids []primitive.ObjectID) {
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	for _, id := range ids {
+		index.removeDefinition(createSearchKey(SearchKindDefinition, id.Hex()))
+	}
+
+}
+
+/*
+remove deletes an entry. The caller has to hold the write lock.
+*/
+func (index *trigramIndex) remove(key string) {
+
+	entry, exists := index.entries[key]
+
+	if !exists {
+		return
+	}
+
+	for _, trigrams := range entry.trigrams {
+		for trigram := range trigrams {
+
+			delete(index.postings[trigram], key)
+
+			if len(index.postings[trigram]) == 0 {
+				delete(index.postings, trigram)
+			}
+		}
+	}
+
+	delete(index.entries, key)
+
+}
+
+func (index *trigramIndex) removeKeys(keys []string) {
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	for _, key := range keys {
+		index.remove(key)
+	}
+
+}
+
+/*
+search ranks the entries of the given kinds by the share of the query trigrams contained in their best matching field.
+Ties are broken by the similarity of the whole field, so that "intelligence" ranks above "artificial intelligence".
+*/
+func (index *trigramIndex) search(text string, kinds []string, prefix bool, minScore float64, limit int) []*searchMatch {
+
+	queryTrigrams := common.Trigrams(text)
+	if prefix {
+		queryTrigrams = common.PrefixTrigrams(text)
+	}
+
+	if len(queryTrigrams) == 0 {
+		return []*searchMatch{}
+	}
+
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	candidates := map[string]*searchEntry{}
+
+	for trigram := range queryTrigrams {
+		for key, entry := range index.postings[trigram] {
+			if containsString(kinds, entry.kind) {
+				candidates[key] = entry
+			}
+		}
+	}
+
+	matches := []*searchMatch{}
+
+	for _, entry := range candidates {
+
+		best := searchMatch{entry: entry}
+
+		for _, trigrams := range entry.trigrams {
+
+			shared := 0
+			for trigram := range queryTrigrams {
+				if trigrams[trigram] {
+					shared++
+				}
+			}
+
+			containment := float64(shared) / float64(len(queryTrigrams))
+			similarity := float64(shared) / float64(len(queryTrigrams)+len(trigrams)-shared)
+
+			if containment > best.containment || (containment == best.containment && similarity > best.similarity) {
+				best.containment = containment
+				best.similarity = similarity
+			}
+		}
+
+		if best.containment >= minScore {
+			matches = append(matches, &best)
+		}
+	}
+
+	sort.Slice(matches, func(i int, j int) bool {
+		if matches[i].containment != matches[j].containment {
+			return matches[i].containment > matches[j].containment
+		}
+		if matches[i].similarity != matches[j].similarity {
+			return matches[i].similarity > matches[j].similarity
+		}
+		return matches[i].entry.text < matches[j].entry.text
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches
+
+}
+
+/*
+indexedDefinition is an approved definition together with the names of its source's authors.
+*/
+type indexedDefinition struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	Title   string             `bson:"title"`
+	Tags    []string           `bson:"tags"`
+	Authors []*types.Author    `bson:"authors"`
+}
+
+/*
+refreshIndexedDefinitions reindexes the approved definitions matching the filter
+and removes the ones that are not approved (anymore).
+*/
+func refreshIndexedDefinitions(filter bson.M) error {
+
+	pendingIds, err := definitionsCollection.Distinct(dbContext, "_id", bson.M{"$and": bson.A{filter, bson.M{"approved": bson.M{"$ne": true}}}})
+
+	if err != nil {
+		return err
+	}
+
+	removedIds := []primitive.ObjectID{}
+	for _, id := range pendingIds {
+		if objectId, isObjectId := id.(primitive.ObjectID); isObjectId {
+			removedIds = append(removedIds, objectId)
+		}
+	}
+
+	searchIndex.removeDefinitions(removedIds)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": bson.A{filter, bson.M{"approved": true}}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         sourcesCollection.Name(),
+			"localField":   "source",
+			"foreignField": "_id",
+			"as":           "source",
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         authorsCollection.Name(),
+			"localField":   "source.authors",
+			"foreignField": "_id",
+			"as":           "authors",
+		}}},
+		{{Key: "$project", Value: bson.M{"title": 1, "tags": 1, "authors.first_name": 1, "authors.last_name": 1}}},
+	}
+
+	cursor, err := definitionsCollection.Aggregate(dbContext, pipeline)
+
+	if err != nil {
+		return err
+	}
+
+	defer cursor.Close(dbContext)
+
+	for cursor.Next(dbContext) {
+
+		var definition indexedDefinition
+
+		if err := cursor.Decode(&definition); err != nil {
+			return err
+		}
+
+		fields := []string{definition.Title}
+		fields = append(fields, definition.Tags...)
+		for _, author := range definition.Authors {
+			fields = append(fields, author.FullName())
+		}
+
+		searchIndex.addDefinition(definition.ID, definition.Title, fields, definition.Tags)
+	}
+
+	return nil
+
+}
+
+/*
+refreshIndexedAuthors reindexes the given authors and the definitions drawn from their sources.
+Authors that are not approved or do not exist anymore are removed from the index.
+*/
+func refreshIndexedAuthors(ids []primitive.ObjectID) error {
+
+	keys := []string{}
+	for _, id := range ids {
+		keys = append(keys, createSearchKey(SearchKindAuthor, id.Hex()))
+	}
+
+	searchIndex.removeKeys(keys)
+
+	authors, err := getAuthors(bson.M{"_id": bson.M{"$in": ids}, "approved": true}, nil)
+
+	if err != nil {
+		return err
+	}
+
+	for _, author := range authors {
+		indexAuthor(author)
+	}
+
+	sources, sourcesError := getSources(bson.M{"authors": bson.M{"$in": ids}}, nil)
+
+	if sourcesError != nil {
+		return sourcesError
+	}
+
+	sourceIds := []primitive.ObjectID{}
+	for _, source := range sources {
+		sourceIds = append(sourceIds, source.ID)
+	}
+
+	return refreshIndexedDefinitions(bson.M{"source": bson.M{"$in": sourceIds}})
+
+}
+
+func indexAuthor(author *types.Author) {
+
+	fields := []string{author.FullName(), author.LastName + " " + author.FirstName}
+	fields = append(fields, author.NameVariants...)
+
+	searchIndex.add(SearchKindAuthor, author.ID, createSearchKey(SearchKindAuthor, author.ID.Hex()), author.FullName(), fields)
+
+}
+
+/*
+updateSearchIndex runs a refresh after a successful write. The index is only a cache of the collections,
+so a failed refresh is logged instead of failing the request that already changed the data.
+*/
+func updateSearchIndex(refresh func() error) {
+
+	if err := refresh(); err != nil {
+		fmt.Println("Could not update search index:", err)
+	}
+
+}
+
+func buildSearchIndex() error {
+
+	authors, err := getAuthors(bson.M{"approved": true}, nil)
+
+	if err != nil {
+		return err
+	}
+
+	for _, author := range authors {
+		indexAuthor(author)
+	}
+
+	return refreshIndexedDefinitions(bson.M{})
+
+}
+
+func validateSearchKinds(kinds []string) error {
+
+	for _, kind := range kinds {
+		if !containsString(SearchKinds, kind) {
+			return ErrorInvalidSearchKind
+		}
+	}
+
+	return nil
+
+}
+
+/*
+Autocomplete suggests definitions, tags and authors whose title or name starts similar to the query.
+*/
+func Autocomplete(query string, kinds []string, limit int) ([]*Suggestion, error) {
+
+	if len(kinds) == 0 {
+		kinds = SearchKinds
+	}
+
+	if err := validateSearchKinds(kinds); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 || limit > maxAutocompleteResults {
+		return nil, common.ErrorInvalidType
+	}
+
+	suggestions := []*Suggestion{}
+
+	for _, match := range searchIndex.search(query, kinds, true, autocompleteMinScore, limit) {
+
+		suggestion := Suggestion{Kind: match.entry.kind, Text: match.entry.text, Score: match.containment}
+		if !match.entry.id.IsZero() {
+			suggestion.ID = match.entry.id.Hex()
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	return suggestions, nil
+
+}
+
+/*
+findFuzzyDefinitions returns the IDs of the approved definitions whose title, tags or author names match the text best first,
+together with their scores.
+*/
+func findFuzzyDefinitions(text string) ([]primitive.ObjectID, map[primitive.ObjectID]float64) {
+
+	ids := []primitive.ObjectID{}
+	scores := map[primitive.ObjectID]float64{}
+
+	for _, match := range searchIndex.search(text, []string{SearchKindDefinition}, false, fuzzyMinScore, maxFuzzyResults) {
+		ids = append(ids, match.entry.id)
+		scores[match.entry.id] = match.containment
+	}
+
+	return ids, scores
+
+}
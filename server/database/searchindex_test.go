@@ -0,0 +1,91 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func searchTexts(index *trigramIndex, text string, kinds []string, prefix bool) []string {
+
+	texts := []string{}
+	for _, match := range index.search(text, kinds, prefix, fuzzyMinScore, maxFuzzyResults) {
+		texts = append(texts, match.entry.text)
+	}
+
+	return texts
+
+}
+
+func TestTrigramIndexSearch(t *testing.T) {
+
+	index := newTrigramIndex()
+
+	index.addDefinition(primitive.NewObjectID(), "Artificial intelligence", []string{"Artificial intelligence", "computer science", "Alan Turing"}, []string{"computer science"})
+	index.addDefinition(primitive.NewObjectID(), "Intelligence", []string{"Intelligence", "psychology"}, []string{"psychology"})
+	index.addDefinition(primitive.NewObjectID(), "Perception", []string{"Perception", "psychology", "Immanuel Kant"}, []string{"psychology"})
+	index.add(SearchKindAuthor, primitive.NewObjectID(), createSearchKey(SearchKindAuthor, "turing"), "Alan Turing", []string{"Alan Turing", "Turing Alan"})
+
+	tests := []struct {
+		text     string
+		kinds    []string
+		prefix   bool
+		expected []string
+	}{
+		{"intelligence", []string{SearchKindDefinition}, false, []string{"Intelligence", "Artificial intelligence"}},
+		{"inteligence", []string{SearchKindDefinition}, false, []string{"Intelligence", "Artificial intelligence"}},
+		{"percpetion", []string{SearchKindDefinition}, false, []string{"Perception"}},
+		{"turing", []string{SearchKindDefinition}, false, []string{"Artificial intelligence"}},
+		{"kant", []string{SearchKindDefinition}, false, []string{"Perception"}},
+		{"psycho", []string{SearchKindTag}, true, []string{"psychology"}},
+		{"tur", []string{SearchKindAuthor}, true, []string{"Alan Turing"}},
+		{"tur", []string{SearchKindTag}, true, []string{}},
+		{"", SearchKinds, false, []string{}},
+	}
+
+	for _, test := range tests {
+
+		if texts := searchTexts(index, test.text, test.kinds, test.prefix); !reflect.DeepEqual(texts, test.expected) {
+			t.Errorf("search(%q, %v, %v) = %q, expected %q", test.text, test.kinds, test.prefix, texts, test.expected)
+		}
+	}
+
+}
+
+func TestTrigramIndexRemovesUnusedTags(t *testing.T) {
+
+	index := newTrigramIndex()
+
+	perception := primitive.NewObjectID()
+	intelligence := primitive.NewObjectID()
+
+	index.addDefinition(perception, "Perception", []string{"Perception", "psychology"}, []string{"psychology", "philosophy"})
+	index.addDefinition(intelligence, "Intelligence", []string{"Intelligence", "psychology"}, []string{"psychology"})
+
+	for _, tag := range []string{"philosophy", "psychology"} {
+		if texts := searchTexts(index, tag, []string{SearchKindTag}, false); !reflect.DeepEqual(texts, []string{tag}) {
+			t.Fatalf("search(%q) = %q before any change", tag, texts)
+		}
+	}
+
+	/* the perception definition no longer uses philosophy, its last definition */
+	index.addDefinition(perception, "Perception", []string{"Perception", "psychology"}, []string{"psychology"})
+
+	if texts := searchTexts(index, "philosophy", []string{SearchKindTag}, false); len(texts) > 0 {
+		t.Errorf("philosophy is still indexed after its last definition changed: %q", texts)
+	}
+
+	index.removeDefinitions([]primitive.ObjectID{perception})
+
+	if texts := searchTexts(index, "psychology", []string{SearchKindTag}, false); !reflect.DeepEqual(texts, []string{"psychology"}) {
+		t.Errorf("psychology = %q after removing one of its definitions, expected it to stay", texts)
+	}
+
+	index.removeDefinitions([]primitive.ObjectID{intelligence})
+
+	if len(index.entries) > 0 || len(index.postings) > 0 || len(index.tagDefinitions) > 0 {
+		t.Errorf("index is not empty after removing all definitions: %d entries, %d postings, %d tags", len(index.entries), len(index.postings), len(index.tagDefinitions))
+	}
+
+}
@@ -0,0 +1,437 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"yacoid_server/constants"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrorSessionNotFound = errors.New("SESSION_NOT_FOUND")
+var ErrorInvalidSessionConfig = errors.New("INVALID_SESSION_CONFIG")
+
+const defaultSessionIdleTimeout = 14 * 24 * time.Hour
+const defaultSessionMaxAge = 90 * 24 * time.Hour
+
+var sessionIdleTimeout = defaultSessionIdleTimeout
+var sessionMaxAge = defaultSessionMaxAge
+
+/* the last seen date is only written once per interval, so that not every request writes to the database */
+const sessionTouchInterval = time.Minute
+
+/*
+Session is a login of a user on one device. Only the hash of the auth token is stored. A session expires
+after the idle timeout without requests or after the max age since the login, whichever comes first.
+Expired sessions are removed by a TTL index on the expiry date.
+*/
+type Session struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	UserID       primitive.ObjectID `bson:"user_id" json:"-"`
+	TokenHash    string             `bson:"token_hash" json:"-"`
+	CreatedDate  time.Time          `bson:"created_date" json:"createdDate"`
+	LastSeenDate time.Time          `bson:"last_seen_date" json:"lastSeenDate"`
+	ExpiryDate   time.Time          `bson:"expiry_date" json:"expiryDate"`
+	IP           string             `bson:"ip" json:"ip"`
+	UserAgent    string             `bson:"user_agent" json:"userAgent"`
+	/* sessions of token pairs are identified by their current refresh token, see RefreshTokens */
+	Refresh         bool     `bson:"refresh,omitempty" json:"-"`
+	UsedTokenHashes []string `bson:"used_token_hashes,omitempty" json:"-"`
+	Current         bool     `bson:"-" json:"current"`
+}
+
+/*
+Authentication is the user and session an auth token or access token belongs to.
+*/
+type Authentication struct {
+	UserID    primitive.ObjectID
+	SessionID primitive.ObjectID
+}
+
+/*
+LoadSessionConfig reads the idle timeout and the max age of sessions, so that invalid durations are reported
+at startup instead of failing the first login.
+*/
+func LoadSessionConfig() error {
+
+	idleTimeout, err := getSessionDuration(constants.EnvKeySessionIdleTimeout, defaultSessionIdleTimeout)
+
+	if err != nil {
+		return err
+	}
+
+	maxAge, err := getSessionDuration(constants.EnvKeySessionMaxAge, defaultSessionMaxAge)
+
+	if err != nil {
+		return err
+	}
+
+	sessionIdleTimeout = idleTimeout
+	sessionMaxAge = maxAge
+
+	return nil
+
+}
+
+func getSessionDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+
+	value := os.Getenv(key)
+
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("%w: invalid duration %q in %s", ErrorInvalidSessionConfig, value, key)
+	}
+
+	return parsed, nil
+
+}
+
+/*
+getSessionExpiryDate returns when a session expires if it was last seen at the given date.
+*/
+func getSessionExpiryDate(createdDate time.Time, lastSeenDate time.Time) time.Time {
+
+	idleExpiryDate := lastSeenDate.Add(sessionIdleTimeout)
+	absoluteExpiryDate := createdDate.Add(sessionMaxAge)
+
+	if idleExpiryDate.Before(absoluteExpiryDate) {
+		return idleExpiryDate
+	}
+
+	return absoluteExpiryDate
+
+}
+
+func createAuthToken() (string, error) {
+
+	token := make([]byte, 32)
+
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token), nil
+
+}
+
+/*
+createSession logs the user in on a new device. The returned user carries the auth token of the session,
+which is not stored anywhere else.
+*/
+func createSession(user *User, client *AuditClient) (*User, error) {
+
+	token, _, err := insertSession(user, client, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	loggedInUser := *user
+	loggedInUser.AuthToken = token
+
+	return &loggedInUser, nil
+
+}
+
+/*
+insertSession starts a session and returns its token, which is an auth token or, for token pairs, the first refresh token.
+*/
+func insertSession(user *User, client *AuditClient, refresh bool) (string, *Session, error) {
+
+	token, tokenError := createAuthToken()
+
+	if tokenError != nil {
+		return "", nil, tokenError
+	}
+
+	now := time.Now()
+
+	session := Session{
+		ID:           primitive.NewObjectID(),
+		UserID:       user.ID,
+		TokenHash:    hash(token),
+		CreatedDate:  now,
+		LastSeenDate: now,
+		ExpiryDate:   getSessionExpiryDate(now, now),
+		Refresh:      refresh,
+	}
+
+	if client != nil {
+		session.IP = client.IP
+		session.UserAgent = client.UserAgent
+	}
+
+	_, err := sessionsCollection.InsertOne(dbContext, session)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &session, nil
+
+}
+
+/*
+getSessionByAuthToken returns the unexpired session of the token and extends it by the idle timeout.
+*/
+func getSessionByAuthToken(authToken string) (*Session, error) {
+
+	if len(authToken) == 0 {
+		return nil, ErrorInvalidAuthToken
+	}
+
+	now := time.Now()
+
+	var session Session
+	filter := bson.M{"token_hash": hash(authToken), "refresh": bson.M{"$ne": true}, "expiry_date": bson.M{"$gt": now}}
+	err := sessionsCollection.FindOne(dbContext, filter).Decode(&session)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrorInvalidAuthToken
+		}
+		return nil, err
+	}
+
+	if now.Sub(session.LastSeenDate) >= sessionTouchInterval {
+
+		session.LastSeenDate = now
+		session.ExpiryDate = getSessionExpiryDate(session.CreatedDate, now)
+
+		update := bson.M{"$set": bson.M{"last_seen_date": session.LastSeenDate, "expiry_date": session.ExpiryDate}}
+
+		if _, updateError := sessionsCollection.UpdateOne(dbContext, bson.M{"_id": session.ID}, update); updateError != nil {
+			fmt.Println("Could not update session:", updateError)
+		}
+	}
+
+	return &session, nil
+
+}
+
+/*
+Authenticate accepts both the auth tokens of sessions and the access tokens of token pairs.
+*/
+func Authenticate(authToken string) (*Authentication, error) {
+
+	if isAccessToken(authToken) {
+		return parseAccessToken(authToken)
+	}
+
+	session, err := getSessionByAuthToken(authToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authentication{UserID: session.UserID, SessionID: session.ID}, nil
+
+}
+
+/*
+getUserAndAuthentication returns the signed in user together with the session of the token.
+*/
+func getUserAndAuthentication(authToken string) (*User, *Authentication, error) {
+
+	authentication, authError := Authenticate(authToken)
+
+	if authError != nil {
+		return nil, nil, authError
+	}
+
+	user, userError := GetUserById(authentication.UserID)
+
+	if userError != nil {
+		if userError == ErrorUserNotFound {
+			return nil, nil, ErrorInvalidAuthToken
+		}
+		return nil, nil, userError
+	}
+
+	return user, authentication, nil
+
+}
+
+/*
+GetSessions returns the unexpired sessions of the user, the most recently used first.
+*/
+func GetSessions(authToken string) ([]*Session, error) {
+
+	authentication, authError := Authenticate(authToken)
+
+	if authError != nil {
+		return nil, authError
+	}
+
+	filter := bson.M{"user_id": authentication.UserID, "expiry_date": bson.M{"$gt": time.Now()}}
+	options := options.Find().SetSort(bson.D{{Key: "last_seen_date", Value: -1}})
+
+	cursor, err := sessionsCollection.Find(dbContext, filter, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	sessions := []*Session{}
+
+	for cursor.Next(dbContext) {
+
+		session := Session{}
+		err := cursor.Decode(&session)
+
+		if err != nil {
+			return nil, err
+		}
+
+		session.Current = session.ID == authentication.SessionID
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, nil
+
+}
+
+/*
+RevokeSession logs out one session of the user, which may also be the current one.
+*/
+func RevokeSession(id string, authToken string, client *AuditClient) error {
+
+	authentication, authError := Authenticate(authToken)
+
+	if authError != nil {
+		return authError
+	}
+
+	objectId, idError := primitive.ObjectIDFromHex(id)
+
+	if idError != nil {
+		return InvalidID
+	}
+
+	result, err := sessionsCollection.DeleteOne(dbContext, bson.M{"_id": objectId, "user_id": authentication.UserID})
+
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrorSessionNotFound
+	}
+
+	recordAudit(createAuditEntry(client, &authentication.UserID, AuditActionSessionRevoke, AuditTargetUser, authentication.UserID, nil, bson.M{"sessionId": objectId}))
+
+	return nil
+
+}
+
+/*
+RevokeOtherSessions logs out all sessions of the user except the current one and returns how many were revoked.
+*/
+func RevokeOtherSessions(authToken string, client *AuditClient) (int64, error) {
+
+	authentication, authError := Authenticate(authToken)
+
+	if authError != nil {
+		return 0, authError
+	}
+
+	revoked, err := revokeSessionsOfUser(dbContext, authentication.UserID, &authentication.SessionID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	recordAudit(createAuditEntry(client, &authentication.UserID, AuditActionSessionRevoke, AuditTargetUser, authentication.UserID, nil, bson.M{"revoked": revoked}))
+
+	return revoked, nil
+
+}
+
+/*
+revokeSessionsOfUser deletes all sessions of the user except the kept one, which may be nil.
+*/
+func revokeSessionsOfUser(context context.Context, userId primitive.ObjectID, keptSessionId *primitive.ObjectID) (int64, error) {
+
+	filter := bson.M{"user_id": userId}
+
+	if keptSessionId != nil {
+		filter["_id"] = bson.M{"$ne": *keptSessionId}
+	}
+
+	result, err := sessionsCollection.DeleteMany(context, filter)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+
+}
+
+/*
+migrateAuthTokens moves the auth tokens stored in users before sessions existed into sessions,
+so that users stay logged in. The sessions start now, as the login dates are unknown.
+*/
+func migrateAuthTokens() error {
+
+	filter := bson.M{"auth_token": bson.M{"$exists": true}}
+	cursor, err := userCollection.Find(dbContext, filter, options.Find().SetProjection(bson.M{"auth_token": 1}))
+
+	if err != nil {
+		return err
+	}
+
+	defer cursor.Close(dbContext)
+
+	now := time.Now()
+
+	for cursor.Next(dbContext) {
+
+		var user struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			AuthToken string             `bson:"auth_token"`
+		}
+
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		if len(user.AuthToken) > 0 {
+
+			session := Session{
+				ID:           primitive.NewObjectID(),
+				UserID:       user.ID,
+				TokenHash:    hash(user.AuthToken),
+				CreatedDate:  now,
+				LastSeenDate: now,
+				ExpiryDate:   getSessionExpiryDate(now, now),
+			}
+
+			if _, err := sessionsCollection.InsertOne(dbContext, session); err != nil {
+				return err
+			}
+		}
+
+		if _, err := userCollection.UpdateOne(dbContext, bson.M{"_id": user.ID}, bson.M{"$unset": bson.M{"auth_token": ""}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
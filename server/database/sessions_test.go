@@ -0,0 +1,42 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"yacoid_server/constants"
+)
+
+func TestLoadSessionConfig(t *testing.T) {
+
+	tests := []struct {
+		idleTimeout         string
+		maxAge              string
+		expectedIdleTimeout time.Duration
+		expectedMaxAge      time.Duration
+		err                 error
+	}{
+		{"", "", defaultSessionIdleTimeout, defaultSessionMaxAge, nil},
+		{"1h", "720h", time.Hour, 720 * time.Hour, nil},
+		{"14 days", "", 0, 0, ErrorInvalidSessionConfig},
+		{"", "-1h", 0, 0, ErrorInvalidSessionConfig},
+		{"0s", "", 0, 0, ErrorInvalidSessionConfig},
+	}
+
+	t.Cleanup(func() {
+		sessionIdleTimeout, sessionMaxAge = defaultSessionIdleTimeout, defaultSessionMaxAge
+	})
+
+	for _, test := range tests {
+
+		t.Setenv(constants.EnvKeySessionIdleTimeout, test.idleTimeout)
+		t.Setenv(constants.EnvKeySessionMaxAge, test.maxAge)
+
+		err := LoadSessionConfig()
+
+		if !errors.Is(err, test.err) || (err == nil && (sessionIdleTimeout != test.expectedIdleTimeout || sessionMaxAge != test.expectedMaxAge)) {
+			t.Errorf("LoadSessionConfig() with %q, %q = %v, %v, %v, expected %v, %v, %v", test.idleTimeout, test.maxAge, sessionIdleTimeout, sessionMaxAge, err, test.expectedIdleTimeout, test.expectedMaxAge, test.err)
+		}
+	}
+
+}
@@ -1,6 +1,10 @@
 package database
 
 import (
+	"errors"
+	"math"
+	"regexp"
+	"strings"
 	"time"
 	"yacoid_server/common"
 	"yacoid_server/types"
@@ -8,77 +12,146 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func CreateSource(request *types.CreateSourceRequest, authToken string) error {
+var ErrorSourceAlreadyExists = errors.New("SOURCE_ALREADY_EXISTS")
+
+const sourceTitleSimilarityThreshold = 0.65
+
+/*
+CreateSource inserts a new source. If a source with the same ISBN or DOI already exists,
+the existing source is returned together with ErrorSourceAlreadyExists, as long as the user may see it.
+*/
+func CreateSource(request *types.CreateSourceRequest, authToken string) (*types.Source, error) {
 
 	user, userError := GetUserByAuthToken(authToken)
 
 	if userError != nil {
-		return userError
+		return nil, userError
 	}
 
 	var source types.Source
 
+	now := time.Now()
 	source.ID = primitive.NewObjectID()
 	source.SubmittedBy = user.ID
-	source.SubmittedDate = time.Now()
+	source.SubmittedDate = now
+	source.LastSubmitChangeDate = now
+	source.RejectionLog = []*types.Rejection{}
+
+	/* sources submitted by admins do not need to be moderated */
+	if user.Admin {
+		source.Approved = true
+		source.ApprovedBy = &user.ID
+		source.ApprovedDate = &now
+	}
+	source.Title = strings.TrimSpace(request.Title)
+	source.Year = request.Year
+
+	if request.ISBN != nil && len(strings.TrimSpace(*request.ISBN)) > 0 {
+		isbn, isbnError := common.NormalizeISBN(*request.ISBN)
+		if isbnError != nil {
+			return nil, isbnError
+		}
+		source.ISBN = &isbn
+	}
+
+	if request.DOI != nil && len(strings.TrimSpace(*request.DOI)) > 0 {
+		doi, doiError := common.NormalizeDOI(*request.DOI)
+		if doiError != nil {
+			return nil, doiError
+		}
+		source.DOI = &doi
+	}
 
 	authors, idError := stringsToObjectIDs(&request.Authors)
 
 	if idError != nil {
-		return idError
+		return nil, InvalidID
 	}
 
-	authorsExistError := validateAuthorsExist(&authors)
+	authorsUsableError := validateAuthorsUsable(&authors, user)
 
-	if authorsExistError != nil {
-		return authorsExistError
+	if authorsUsableError != nil {
+		return nil, authorsUsableError
 	}
 
 	source.Authors = authors
 
+	existingSource, findError := getSourceByIdentifier(source.ISBN, source.DOI)
+
+	if findError == nil {
+		return visibleSourceOrNil(existingSource, user), ErrorSourceAlreadyExists
+	} else if findError != common.ErrorNotFound {
+		return nil, findError
+	}
+
 	_, err := sourcesCollection.InsertOne(dbContext, source)
 
 	if err != nil {
-		return err
+		if mongo.IsDuplicateKeyError(err) {
+			/* another request inserted the same identifier in the meantime */
+			existingSource, findError := getSourceByIdentifier(source.ISBN, source.DOI)
+			if findError != nil {
+				return nil, findError
+			}
+			return visibleSourceOrNil(existingSource, user), ErrorSourceAlreadyExists
+		}
+		return nil, err
 	}
 
-	return nil
+	publishWebhookEvent(WebhookEventSourceCreated, source)
+
+	return &source, nil
 
 }
 
-func stringsToObjectIDs(stringIds *[]string) ([]primitive.ObjectID, error) {
+func visibleSourceOrNil(source *types.Source, user *User) *types.Source {
 
-	ids := []primitive.ObjectID{}
-	for _, stringId := range *stringIds {
+	if !isVisibleTo(source.Approved, source.SubmittedBy, user) {
+		return nil
+	}
 
-		id, idError := primitive.ObjectIDFromHex(stringId)
+	return source
 
-		if idError != nil {
-			return nil, idError
-		}
+}
 
-		ids = append(ids, id)
+func getSourceByIdentifier(isbn *string, doi *string) (*types.Source, error) {
+
+	identifiers := bson.A{}
+
+	if isbn != nil {
+		identifiers = append(identifiers, bson.M{"isbn": *isbn})
 	}
 
-	return ids, nil
+	if doi != nil {
+		identifiers = append(identifiers, bson.M{"doi": *doi})
+	}
+
+	if len(identifiers) == 0 {
+		return nil, common.ErrorNotFound
+	}
+
+	return getSource(bson.M{"$or": identifiers})
 
 }
 
-func validateAuthorsExist(ids *[]primitive.ObjectID) error {
+func stringsToObjectIDs(stringIds *[]string) ([]primitive.ObjectID, error) {
 
-	for _, id := range *ids {
+	ids := []primitive.ObjectID{}
+	for _, stringId := range *stringIds {
 
-		_, err := GetAuthor(id)
+		id, idError := primitive.ObjectIDFromHex(stringId)
 
-		if err != nil {
-			return err
+		if idError != nil {
+			return nil, InvalidID
 		}
 
+		ids = append(ids, id)
 	}
 
-	return nil
+	return ids, nil
 
 }
 
@@ -87,7 +160,7 @@ func GetSourceById(stringId string) (*types.Source, error) {
 	id, idError := primitive.ObjectIDFromHex(stringId)
 
 	if idError != nil {
-		return nil, idError
+		return nil, InvalidID
 	}
 
 	return GetSource(id)
@@ -97,6 +170,11 @@ func GetSourceById(stringId string) (*types.Source, error) {
 func GetSource(id primitive.ObjectID) (*types.Source, error) {
 
 	filter := bson.M{"_id": id}
+	return getSource(filter)
+
+}
+
+func getSource(filter interface{}) (*types.Source, error) {
 
 	result := sourcesCollection.FindOne(dbContext, filter)
 
@@ -117,3 +195,655 @@ func GetSource(id primitive.ObjectID) (*types.Source, error) {
 	return &source, nil
 
 }
+
+func getSources(filter interface{}, options *options.FindOptions) ([]*types.Source, error) {
+
+	cursor, err := sourcesCollection.Find(dbContext, filter, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	sources := []*types.Source{}
+
+	for cursor.Next(dbContext) {
+
+		source := types.Source{}
+		err := cursor.Decode(&source)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sources = append(sources, &source)
+	}
+
+	return sources, nil
+
+}
+
+/*
+PopulateSources resolves the author references of the given sources. Missing authors and authors
+the user may not see are left out.
+*/
+func PopulateSources(sources []*types.Source, user *User) ([]*types.PopulatedSource, error) {
+
+	authorIds := []primitive.ObjectID{}
+	for _, source := range sources {
+		authorIds = append(authorIds, source.Authors...)
+	}
+
+	authors, findError := getAuthors(withVisibility(bson.M{"_id": bson.M{"$in": authorIds}}, user), nil)
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	authorsById := map[primitive.ObjectID]*types.Author{}
+	for _, author := range authors {
+		authorsById[author.ID] = author
+	}
+
+	populatedSources := []*types.PopulatedSource{}
+
+	for _, source := range sources {
+
+		populatedSource := types.PopulatedSource{
+			ID:            source.ID,
+			SubmittedBy:   source.SubmittedBy,
+			SubmittedDate: source.SubmittedDate,
+			Approved:      source.Approved,
+			Authors:       []*types.Author{},
+			Title:         source.Title,
+			Year:          source.Year,
+			ISBN:          source.ISBN,
+			DOI:           source.DOI,
+		}
+
+		for _, authorId := range source.Authors {
+			if author, exists := authorsById[authorId]; exists {
+				populatedSource.Authors = append(populatedSource.Authors, author)
+			}
+		}
+
+		populatedSources = append(populatedSources, &populatedSource)
+	}
+
+	return populatedSources, nil
+
+}
+
+/*
+GetPopulatedSourceById returns the source with populated authors. Sources that are not approved yet
+are only found by their submitter and admins.
+*/
+func GetPopulatedSourceById(stringId string, authToken string) (*types.PopulatedSource, error) {
+
+	source, findError := GetSourceById(stringId)
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	user := GetOptionalUser(authToken)
+
+	if !isVisibleTo(source.Approved, source.SubmittedBy, user) {
+		return nil, common.ErrorNotFound
+	}
+
+	populatedSources, populateError := PopulateSources([]*types.Source{source}, user)
+
+	if populateError != nil {
+		return nil, populateError
+	}
+
+	return populatedSources[0], nil
+
+}
+
+/*
+createSourceSearchQuery matches every word of the search against the title or the name of one of the authors.
+*/
+func createSourceSearchQuery(search *string, year *int) (bson.M, error) {
+
+	query := bson.A{}
+
+	if search != nil {
+		for _, word := range strings.Fields(*search) {
+
+			authorQuery := createAuthorSearchQuery(&word)
+			authors, findError := getAuthors(authorQuery, nil)
+
+			if findError != nil {
+				return nil, findError
+			}
+
+			authorIds := []primitive.ObjectID{}
+			for _, author := range authors {
+				authorIds = append(authorIds, author.ID)
+			}
+
+			query = append(query, bson.M{"$or": bson.A{
+				bson.M{"title": primitive.Regex{Pattern: regexp.QuoteMeta(word), Options: "i"}},
+				bson.M{"authors": bson.M{"$in": authorIds}},
+			}})
+		}
+	}
+
+	if year != nil {
+		query = append(query, bson.M{"year": *year})
+	}
+
+	if len(query) == 0 {
+		return bson.M{}, nil
+	}
+
+	return bson.M{"$and": query}, nil
+
+}
+
+/*
+GetSources returns one page of sources with populated authors together with the total page count.
+*/
+func GetSources(pageSize int, page int, search *string, year *int, authToken string) ([]*types.PopulatedSource, int64, error) {
+
+	if pageSize <= 0 || pageSize > maxPageSize || page <= 0 {
+		return nil, 0, common.ErrorInvalidType
+	}
+
+	searchQuery, queryError := createSourceSearchQuery(search, year)
+
+	if queryError != nil {
+		return nil, 0, queryError
+	}
+
+	user := GetOptionalUser(authToken)
+	filter := withVisibility(searchQuery, user)
+
+	options := options.Find()
+	options.SetSort(bson.D{{Key: "title", Value: 1}})
+	options.SetLimit(int64(pageSize))
+	options.SetSkip(int64((page - 1) * pageSize))
+
+	sources, findError := getSources(filter, options)
+
+	if findError != nil {
+		return nil, 0, findError
+	}
+
+	populatedSources, populateError := PopulateSources(sources, user)
+
+	if populateError != nil {
+		return nil, 0, populateError
+	}
+
+	count, countError := sourcesCollection.CountDocuments(dbContext, filter)
+
+	if countError != nil {
+		return nil, 0, countError
+	}
+
+	pageCount := int64(math.Ceil(float64(count) / float64(pageSize)))
+
+	return populatedSources, pageCount, nil
+
+}
+
+/*
+UpdateSource changes the given fields of a source. Only the submitter of the source or an admin is allowed to do so.
+Empty ISBN or DOI values remove the identifier.
+*/
+func UpdateSource(request *types.UpdateSourceRequest, authToken string) (*types.PopulatedSource, error) {
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	source, findError := GetSourceById(request.ID)
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	if source.SubmittedBy != user.ID && user.Admin == false {
+		return nil, ErrorNotEnoughPermissions
+	}
+
+	/* approved sources can only be changed by admins, otherwise the moderation could be bypassed */
+	if source.Approved && user.Admin == false {
+		return nil, ErrorSourceAlreadyApproved
+	}
+
+	set := bson.D{}
+	unset := bson.D{}
+
+	if source.SubmittedBy == user.ID {
+		set = append(set, bson.E{Key: "last_submit_change_date", Value: time.Now()})
+	}
+
+	if request.Title != nil {
+		set = append(set, bson.E{Key: "title", Value: strings.TrimSpace(*request.Title)})
+	}
+
+	if request.Year != nil {
+		set = append(set, bson.E{Key: "year", Value: *request.Year})
+	}
+
+	if request.ISBN != nil {
+		if len(strings.TrimSpace(*request.ISBN)) == 0 {
+			unset = append(unset, bson.E{Key: "isbn", Value: ""})
+		} else {
+			isbn, isbnError := common.NormalizeISBN(*request.ISBN)
+			if isbnError != nil {
+				return nil, isbnError
+			}
+			set = append(set, bson.E{Key: "isbn", Value: isbn})
+		}
+	}
+
+	if request.DOI != nil {
+		if len(strings.TrimSpace(*request.DOI)) == 0 {
+			unset = append(unset, bson.E{Key: "doi", Value: ""})
+		} else {
+			doi, doiError := common.NormalizeDOI(*request.DOI)
+			if doiError != nil {
+				return nil, doiError
+			}
+			set = append(set, bson.E{Key: "doi", Value: doi})
+		}
+	}
+
+	if request.Authors != nil {
+
+		authors, idError := stringsToObjectIDs(request.Authors)
+
+		if idError != nil {
+			return nil, InvalidID
+		}
+
+		authorsUsableError := validateAuthorsUsable(&authors, user)
+
+		if authorsUsableError != nil {
+			return nil, authorsUsableError
+		}
+
+		set = append(set, bson.E{Key: "authors", Value: authors})
+	}
+
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+
+	if len(update) > 0 {
+
+		_, updateError := sourcesCollection.UpdateByID(dbContext, source.ID, update)
+
+		if updateError != nil {
+			if mongo.IsDuplicateKeyError(updateError) {
+				return nil, ErrorSourceAlreadyExists
+			}
+			return nil, updateError
+		}
+
+		updateSearchIndex(func() error {
+			return refreshIndexedDefinitions(bson.M{"source": source.ID})
+		})
+	}
+
+	return GetPopulatedSourceById(request.ID, authToken)
+
+}
+
+/*
+DeleteSource deletes a source as long as no definition references it. Otherwise the referencing definitions
+are returned together with ErrorDeletionBlocked.
+*/
+func DeleteSource(stringId string, authToken string) ([]*Blocker, error) {
+
+	_, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	source, findError := GetSourceById(stringId)
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	return deleteIfUnreferenced(sourcesCollection, source.ID, findSourceBlockers)
+
+}
+
+type SourceDuplicateGroup struct {
+	Sources []*types.PopulatedSource `json:"sources"`
+}
+
+/*
+findSourceGroups groups the sources matching the filter by the given key and returns the ID, title, year
+and authors of the sources in every group with more than one source. Sources with several values of
+an array key (like authors) are part of several groups.
+*/
+func findSourceGroups(filter bson.M, unwind string, key interface{}) ([][]*types.Source, error) {
+
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: filter}},
+	}
+
+	if len(unwind) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$unwind", Value: unwind}})
+	}
+
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":     key,
+			"sources": bson.M{"$push": bson.M{"_id": "$_id", "title": "$title", "year": "$year", "authors": "$authors"}},
+			"count":   bson.M{"$sum": 1},
+		}}},
+		bson.D{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	)
+
+	cursor, err := sourcesCollection.Aggregate(dbContext, pipeline)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	groups := [][]*types.Source{}
+
+	for cursor.Next(dbContext) {
+
+		var group struct {
+			Sources []*types.Source `bson:"sources"`
+		}
+
+		if err := cursor.Decode(&group); err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, group.Sources)
+	}
+
+	return groups, nil
+
+}
+
+/*
+FindDuplicateSources groups sources that are likely the same publication: same ISBN/DOI, or a similar title
+with a matching year and at least one shared author. The database groups the sources by identifier and by author,
+so that only the titles of sources sharing an author are compared with each other.
+*/
+func FindDuplicateSources(authToken string) ([]*SourceDuplicateGroup, error) {
+
+	user, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	/* union find over the IDs of all candidates */
+	parents := map[primitive.ObjectID]primitive.ObjectID{}
+
+	var root func(id primitive.ObjectID) primitive.ObjectID
+	root = func(id primitive.ObjectID) primitive.ObjectID {
+		parent, exists := parents[id]
+		if !exists {
+			parents[id] = id
+			return id
+		}
+		if parent != id {
+			parents[id] = root(parent)
+		}
+		return parents[id]
+	}
+
+	for _, field := range []string{"isbn", "doi"} {
+
+		groups, findError := findSourceGroups(bson.M{field: bson.M{"$type": "string"}}, "", "$"+field)
+
+		if findError != nil {
+			return nil, findError
+		}
+
+		for _, group := range groups {
+			for _, source := range group[1:] {
+				parents[root(source.ID)] = root(group[0].ID)
+			}
+		}
+	}
+
+	authorGroups, findError := findSourceGroups(bson.M{}, "$authors", "$authors")
+
+	if findError != nil {
+		return nil, findError
+	}
+
+	for _, group := range authorGroups {
+		for i := 0; i < len(group); i++ {
+			for j := i + 1; j < len(group); j++ {
+				if isSimilarSource(group[i], group[j]) {
+					parents[root(group[j].ID)] = root(group[i].ID)
+				}
+			}
+		}
+	}
+
+	ids := []primitive.ObjectID{}
+	for id := range parents {
+		ids = append(ids, id)
+	}
+
+	sources, sourcesError := getSources(bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+
+	if sourcesError != nil {
+		return nil, sourcesError
+	}
+
+	populatedSources, populateError := PopulateSources(sources, user)
+
+	if populateError != nil {
+		return nil, populateError
+	}
+
+	groupsByRoot := map[primitive.ObjectID]*SourceDuplicateGroup{}
+	groups := []*SourceDuplicateGroup{}
+
+	for _, source := range populatedSources {
+
+		group, exists := groupsByRoot[root(source.ID)]
+		if !exists {
+			group = &SourceDuplicateGroup{Sources: []*types.PopulatedSource{}}
+			groupsByRoot[root(source.ID)] = group
+			groups = append(groups, group)
+		}
+
+		group.Sources = append(group.Sources, source)
+	}
+
+	duplicates := []*SourceDuplicateGroup{}
+	for _, group := range groups {
+		if len(group.Sources) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+
+	return duplicates, nil
+
+}
+
+/*
+isSimilarSource reports whether two sources of the same author have similar titles and, if both have one, the same year.
+Edition suffixes, punctuation and typos only change a few trigrams of the title.
+*/
+func isSimilarSource(a *types.Source, b *types.Source) bool {
+
+	if a.Year != nil && b.Year != nil && *a.Year != *b.Year {
+		return false
+	}
+
+	return common.TrigramSimilarity(a.Title, b.Title) >= sourceTitleSimilarityThreshold
+
+}
+
+/*
+MergeSources merges the given sources into the target source. Every definition referencing one of the
+merged sources is repointed to the target, authors are combined and missing identifiers are taken over.
+*/
+func MergeSources(targetId string, sourceIds []string, authToken string, client *AuditClient) (*types.PopulatedSource, error) {
+
+	user, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	targetObjectId, targetIdError := primitive.ObjectIDFromHex(targetId)
+
+	if targetIdError != nil {
+		return nil, InvalidID
+	}
+
+	ids, idError := stringsToObjectIDs(&sourceIds)
+
+	if idError != nil {
+		return nil, InvalidID
+	}
+
+	for _, id := range ids {
+		if id == targetObjectId {
+			return nil, common.ValidationError
+		}
+	}
+
+	var before, after bson.M
+
+	mergeError := runInTransaction(func(sessionContext mongo.SessionContext) error {
+
+		/* read inside the transaction, so that concurrent changes of the sources are not overwritten */
+		var target types.Source
+		targetError := sourcesCollection.FindOne(sessionContext, bson.M{"_id": targetObjectId}).Decode(&target)
+
+		if targetError != nil {
+			if targetError == mongo.ErrNoDocuments {
+				return common.ErrorNotFound
+			}
+			return targetError
+		}
+
+		cursor, findError := sourcesCollection.Find(sessionContext, bson.M{"_id": bson.M{"$in": ids}})
+
+		if findError != nil {
+			return findError
+		}
+
+		sources := []*types.Source{}
+
+		if err := cursor.All(sessionContext, &sources); err != nil {
+			return err
+		}
+
+		if len(sources) != len(ids) {
+			return common.ErrorNotFound
+		}
+
+		authors := []primitive.ObjectID{}
+		set := bson.M{}
+
+		for _, source := range sources {
+
+			authors = append(authors, source.Authors...)
+
+			/* only identifiers the target is missing are taken over */
+			if target.Year == nil && set["year"] == nil && source.Year != nil {
+				set["year"] = *source.Year
+			}
+			if target.ISBN == nil && set["isbn"] == nil && source.ISBN != nil {
+				set["isbn"] = *source.ISBN
+			}
+			if target.DOI == nil && set["doi"] == nil && source.DOI != nil {
+				set["doi"] = *source.DOI
+			}
+		}
+
+		_, definitionsError := definitionsCollection.UpdateMany(sessionContext,
+			bson.M{"source": bson.M{"$in": ids}},
+			bson.M{"$set": bson.M{"source": target.ID}},
+		)
+
+		if definitionsError != nil {
+			return definitionsError
+		}
+
+		/* delete first, so the taken over identifiers do not violate the unique indexes */
+		_, deleteError := sourcesCollection.DeleteMany(sessionContext, bson.M{"_id": bson.M{"$in": ids}})
+
+		if deleteError != nil {
+			return deleteError
+		}
+
+		update := bson.M{"$addToSet": bson.M{"authors": bson.M{"$each": authors}}}
+
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+
+		_, updateError := sourcesCollection.UpdateByID(sessionContext, target.ID, update)
+
+		if updateError != nil {
+			return updateError
+		}
+
+		mergedAuthors := append([]primitive.ObjectID{}, target.Authors...)
+		for _, author := range authors {
+			if !containsObjectID(mergedAuthors, author) {
+				mergedAuthors = append(mergedAuthors, author)
+			}
+		}
+
+		before = bson.M{"authors": target.Authors}
+		after = bson.M{"authors": mergedAuthors, "mergedSourceIds": ids}
+
+		for field, value := range set {
+			after[field] = value
+		}
+
+		return nil
+
+	})
+
+	if mergeError != nil {
+		return nil, mergeError
+	}
+
+	recordAudit(createAuditEntry(client, &user.ID, AuditActionSourceMerge, AuditTargetSource, targetObjectId, before, after))
+
+	updateSearchIndex(func() error {
+		return refreshIndexedDefinitions(bson.M{"source": targetObjectId})
+	})
+
+	return GetPopulatedSourceById(targetId, authToken)
+
+}
+
+func containsObjectID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+
+	for _, current := range ids {
+		if current == id {
+			return true
+		}
+	}
+
+	return false
+
+}
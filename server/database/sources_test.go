@@ -0,0 +1,41 @@
+package database
+
+import (
+	"testing"
+	"yacoid_server/types"
+)
+
+func TestIsSimilarSource(t *testing.T) {
+
+	year := func(year int) *int { return &year }
+
+	tests := []struct {
+		titleA   string
+		yearA    *int
+		titleB   string
+		yearB    *int
+		expected bool
+	}{
+		{"Gödel, Escher, Bach", year(1979), "Gödel, Escher, Bach", year(1979), true},
+		{"Gödel, Escher, Bach", year(1979), "Goedel Escher Bach", year(1979), true},
+		{"Artificial Intelligence: A Modern Approach", year(2009), "Artificial Intelligence - A Modern Approach (3rd edition)", year(2009), true},
+		{"Introduction to Algorithms", year(2001), "Introduction to Algorithms, Second Edition", year(2001), true},
+		{"Computing Machinery and Intelligence", year(1950), "Computing Machinery and Inteligence", year(1950), true},
+		{"Computing Machinery and Intelligence", year(1950), "Computing Machinery and Intelligence", nil, true},
+		{"Computing Machinery and Intelligence", year(1950), "Computing Machinery and Intelligence", year(1951), false},
+		{"Computing Machinery and Intelligence", year(1950), "On Computable Numbers", year(1950), false},
+		{"Minds, Brains, and Programs", nil, "Minds and Machines", nil, false},
+		{"Critique of Pure Reason", nil, "Critique of Practical Reason", nil, false},
+	}
+
+	for _, test := range tests {
+
+		a := types.Source{Title: test.titleA, Year: test.yearA}
+		b := types.Source{Title: test.titleB, Year: test.yearB}
+
+		if similar := isSimilarSource(&a, &b); similar != test.expected {
+			t.Errorf("isSimilarSource(%q, %q) = %v, expected %v", test.titleA, test.titleB, similar, test.expected)
+		}
+	}
+
+}
@@ -0,0 +1,343 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"yacoid_server/constants"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrorInvalidRefreshToken = errors.New("INVALID_REFRESH_TOKEN")
+var ErrorRefreshTokenReused = errors.New("REFRESH_TOKEN_REUSED")
+var ErrorInvalidTokenConfig = errors.New("INVALID_TOKEN_CONFIG")
+
+const defaultAccessTokenLifetime = 15 * time.Minute
+const accessTokenIssuer = "yacoid"
+const minAccessTokenKeyLength = 32
+
+/* the hashes of used refresh tokens are kept to detect their reuse, the oldest are dropped beyond this number */
+const maxUsedRefreshTokens = 100
+
+/*
+Access tokens are JWTs signed with HMAC-SHA256. They are validated without a database round trip,
+so revoking their session only takes effect once they expired, after ACCESS_TOKEN_LIFETIME.
+ACCESS_TOKEN_KEYS holds comma separated "<key id>:<secret>" pairs. New tokens are signed with the first key,
+the others are only used to validate tokens, so that a key can be rotated by prepending a new one and
+removing the old one after the lifetime of the tokens has passed.
+*/
+type accessTokenKey struct {
+	ID     string
+	Secret []byte
+}
+
+var accessTokenKeys []accessTokenKey
+var accessTokenLifetime = defaultAccessTokenLifetime
+
+type accessTokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+type accessTokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	SessionID string `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+/*
+TokenPair is returned by the token login and every refresh. The refresh token can only be used once.
+*/
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+/*
+LoadAccessTokenKeys reads the signing keys and the lifetime of access tokens. Without keys, a random key is used,
+so that access tokens become invalid when the server restarts.
+*/
+func LoadAccessTokenKeys() error {
+
+	if value := os.Getenv(constants.EnvKeyAccessTokenLifetime); len(value) > 0 {
+
+		lifetime, err := time.ParseDuration(value)
+
+		if err != nil || lifetime <= 0 {
+			return fmt.Errorf("%w: invalid duration %q in %s", ErrorInvalidTokenConfig, value, constants.EnvKeyAccessTokenLifetime)
+		}
+
+		accessTokenLifetime = lifetime
+	}
+
+	value := os.Getenv(constants.EnvKeyAccessTokenKeys)
+
+	if len(value) == 0 {
+
+		fmt.Println("No " + constants.EnvKeyAccessTokenKeys + " configured, access tokens are only valid until the server restarts")
+
+		secret := make([]byte, minAccessTokenKeyLength)
+
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+
+		accessTokenKeys = []accessTokenKey{{ID: "generated", Secret: secret}}
+		return nil
+	}
+
+	keys := []accessTokenKey{}
+
+	for _, entry := range strings.Split(value, ",") {
+
+		id, secret, found := strings.Cut(strings.TrimSpace(entry), ":")
+
+		if !found || len(id) == 0 || len(secret) < minAccessTokenKeyLength {
+			return fmt.Errorf("%w: %s must contain \"<key id>:<secret>\" pairs with secrets of at least %d characters", ErrorInvalidTokenConfig, constants.EnvKeyAccessTokenKeys, minAccessTokenKeyLength)
+		}
+
+		for _, key := range keys {
+			if key.ID == id {
+				return fmt.Errorf("%w: duplicate key id %q in %s", ErrorInvalidTokenConfig, id, constants.EnvKeyAccessTokenKeys)
+			}
+		}
+
+		keys = append(keys, accessTokenKey{ID: id, Secret: []byte(secret)})
+	}
+
+	accessTokenKeys = keys
+	return nil
+
+}
+
+func signAccessTokenPart(key accessTokenKey, signingInput string) string {
+
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+}
+
+func createAccessToken(userId primitive.ObjectID, sessionId primitive.ObjectID) (string, error) {
+
+	if len(accessTokenKeys) == 0 {
+		return "", ErrorInvalidTokenConfig
+	}
+
+	key := accessTokenKeys[0]
+	now := time.Now()
+
+	header, err := json.Marshal(accessTokenHeader{Algorithm: "HS256", Type: "JWT", KeyID: key.ID})
+
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(accessTokenClaims{
+		Issuer:    accessTokenIssuer,
+		Subject:   userId.Hex(),
+		SessionID: sessionId.Hex(),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(accessTokenLifetime).Unix(),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signingInput + "." + signAccessTokenPart(key, signingInput), nil
+
+}
+
+func isAccessToken(authToken string) bool {
+	return strings.Count(authToken, ".") == 2
+}
+
+/*
+parseAccessToken checks the signature and the expiry of an access token and returns its user and session.
+*/
+func parseAccessToken(accessToken string) (*Authentication, error) {
+
+	parts := strings.Split(accessToken, ".")
+
+	if len(parts) != 3 {
+		return nil, ErrorInvalidAuthToken
+	}
+
+	headerJson, headerError := base64.RawURLEncoding.DecodeString(parts[0])
+	claimsJson, claimsError := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if headerError != nil || claimsError != nil {
+		return nil, ErrorInvalidAuthToken
+	}
+
+	var header accessTokenHeader
+
+	if err := json.Unmarshal(headerJson, &header); err != nil || header.Algorithm != "HS256" {
+		return nil, ErrorInvalidAuthToken
+	}
+
+	var key *accessTokenKey
+
+	for index := range accessTokenKeys {
+		if accessTokenKeys[index].ID == header.KeyID {
+			key = &accessTokenKeys[index]
+		}
+	}
+
+	if key == nil {
+		return nil, ErrorInvalidAuthToken
+	}
+
+	signature := signAccessTokenPart(*key, parts[0]+"."+parts[1])
+
+	if !hmac.Equal([]byte(signature), []byte(parts[2])) {
+		return nil, ErrorInvalidAuthToken
+	}
+
+	var claims accessTokenClaims
+
+	if err := json.Unmarshal(claimsJson, &claims); err != nil || claims.Issuer != accessTokenIssuer {
+		return nil, ErrorInvalidAuthToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrorInvalidAuthToken
+	}
+
+	userId, userIdError := primitive.ObjectIDFromHex(claims.Subject)
+	sessionId, sessionIdError := primitive.ObjectIDFromHex(claims.SessionID)
+
+	if userIdError != nil || sessionIdError != nil {
+		return nil, ErrorInvalidAuthToken
+	}
+
+	return &Authentication{UserID: userId, SessionID: sessionId}, nil
+
+}
+
+func createTokenPair(userId primitive.ObjectID, sessionId primitive.ObjectID, refreshToken string) (*TokenPair, error) {
+
+	accessToken, err := createAccessToken(userId, sessionId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTokenLifetime.Seconds()),
+	}, nil
+
+}
+
+/*
+LoginWithTokens checks the credentials like Login, but starts a session that is used with a token pair
+instead of a single auth token.
+*/
+func LoginWithTokens(email string, password string, client *AuditClient) (*User, *TokenPair, error) {
+
+	user, err := checkCredentials(email, password)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, session, err := insertSession(user, client, true)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokens, err := createTokenPair(user.ID, session.ID, refreshToken)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, tokens, nil
+
+}
+
+/*
+RefreshTokens exchanges a refresh token for a new token pair. Every refresh token can only be used once: if a used one
+is presented again, it was probably stolen, so the whole session is revoked, which logs out both the thief and the user.
+*/
+func RefreshTokens(refreshToken string, client *AuditClient) (*TokenPair, error) {
+
+	if len(refreshToken) == 0 {
+		return nil, ErrorInvalidRefreshToken
+	}
+
+	newRefreshToken, tokenError := createAuthToken()
+
+	if tokenError != nil {
+		return nil, tokenError
+	}
+
+	now := time.Now()
+	tokenHash := hash(refreshToken)
+
+	filter := bson.M{"token_hash": tokenHash, "refresh": true, "expiry_date": bson.M{"$gt": now}}
+	update := bson.M{
+		"$set":  bson.M{"token_hash": hash(newRefreshToken), "last_seen_date": now},
+		"$push": bson.M{"used_token_hashes": bson.M{"$each": bson.A{tokenHash}, "$slice": -maxUsedRefreshTokens}},
+	}
+
+	if client != nil {
+		update["$set"].(bson.M)["ip"] = client.IP
+		update["$set"].(bson.M)["user_agent"] = client.UserAgent
+	}
+
+	var session Session
+	err := sessionsCollection.FindOneAndUpdate(dbContext, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&session)
+
+	if err == mongo.ErrNoDocuments {
+
+		var reusedSession Session
+		reuseError := sessionsCollection.FindOneAndDelete(dbContext, bson.M{"used_token_hashes": tokenHash}).Decode(&reusedSession)
+
+		if reuseError == nil {
+			recordAudit(createAuditEntry(client, nil, AuditActionRefreshTokenReuse, AuditTargetUser, reusedSession.UserID, nil, bson.M{"sessionId": reusedSession.ID}))
+			return nil, ErrorRefreshTokenReused
+		}
+
+		if reuseError != mongo.ErrNoDocuments {
+			return nil, reuseError
+		}
+
+		return nil, ErrorInvalidRefreshToken
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	expiryDate := getSessionExpiryDate(session.CreatedDate, now)
+
+	if _, err := sessionsCollection.UpdateOne(dbContext, bson.M{"_id": session.ID}, bson.M{"$set": bson.M{"expiry_date": expiryDate}}); err != nil {
+		return nil, err
+	}
+
+	return createTokenPair(session.UserID, session.ID, newRefreshToken)
+
+}
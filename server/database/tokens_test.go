@@ -0,0 +1,212 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"yacoid_server/constants"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testAccessTokenKey = accessTokenKey{ID: "current", Secret: []byte(strings.Repeat("c", minAccessTokenKeyLength))}
+var rotatedAccessTokenKey = accessTokenKey{ID: "rotated", Secret: []byte(strings.Repeat("r", minAccessTokenKeyLength))}
+
+func encodeTokenPart(t *testing.T, value interface{}) string {
+
+	encoded, err := json.Marshal(value)
+
+	if err != nil {
+		t.Fatalf("json.Marshal(%v) failed: %v", value, err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(encoded)
+
+}
+
+func signTestToken(t *testing.T, key accessTokenKey, header accessTokenHeader, claims accessTokenClaims) string {
+
+	signingInput := encodeTokenPart(t, header) + "." + encodeTokenPart(t, claims)
+	return signingInput + "." + signAccessTokenPart(key, signingInput)
+
+}
+
+func setAccessTokenConfig(t *testing.T, keys []accessTokenKey, lifetime time.Duration) {
+
+	previousKeys, previousLifetime := accessTokenKeys, accessTokenLifetime
+	t.Cleanup(func() {
+		accessTokenKeys, accessTokenLifetime = previousKeys, previousLifetime
+	})
+
+	accessTokenKeys, accessTokenLifetime = keys, lifetime
+
+}
+
+func TestParseAccessToken(t *testing.T) {
+
+	userId, sessionId := primitive.NewObjectID(), primitive.NewObjectID()
+	now := time.Now()
+
+	header := accessTokenHeader{Algorithm: "HS256", Type: "JWT", KeyID: testAccessTokenKey.ID}
+	claims := accessTokenClaims{Issuer: accessTokenIssuer, Subject: userId.Hex(), SessionID: sessionId.Hex(), IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+
+	valid := signTestToken(t, testAccessTokenKey, header, claims)
+	parts := strings.Split(valid, ".")
+
+	otherClaims := claims
+	otherClaims.Subject = primitive.NewObjectID().Hex()
+
+	expiredClaims := claims
+	expiredClaims.ExpiresAt = now.Add(-time.Second).Unix()
+
+	foreignClaims := claims
+	foreignClaims.Issuer = "someone-else"
+
+	noneHeader := header
+	noneHeader.Algorithm = "none"
+
+	otherAlgorithmHeader := header
+	otherAlgorithmHeader.Algorithm = "HS512"
+
+	unknownKeyHeader := header
+	unknownKeyHeader.KeyID = "unknown"
+
+	rotatedHeader := header
+	rotatedHeader.KeyID = rotatedAccessTokenKey.ID
+
+	/* flip the first character of the signature */
+	flipped := "A"
+	if parts[2][0] == 'A' {
+		flipped = "B"
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"valid", valid, true},
+		{"tampered payload", parts[0] + "." + encodeTokenPart(t, otherClaims) + "." + parts[2], false},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + flipped + parts[2][1:], false},
+		{"missing signature", parts[0] + "." + parts[1] + ".", false},
+		{"alg none", encodeTokenPart(t, noneHeader) + "." + parts[1] + ".", false},
+		{"alg HS512", signTestToken(t, testAccessTokenKey, otherAlgorithmHeader, claims), false},
+		{"expired", signTestToken(t, testAccessTokenKey, header, expiredClaims), false},
+		{"other issuer", signTestToken(t, testAccessTokenKey, header, foreignClaims), false},
+		{"unknown kid", signTestToken(t, testAccessTokenKey, unknownKeyHeader, claims), false},
+		{"rotated-out key", signTestToken(t, rotatedAccessTokenKey, rotatedHeader, claims), false},
+		{"kid of another key", signTestToken(t, rotatedAccessTokenKey, header, claims), false},
+		{"malformed", "not.a.token", false},
+	}
+
+	setAccessTokenConfig(t, []accessTokenKey{testAccessTokenKey}, time.Minute)
+
+	for _, test := range tests {
+
+		authentication, err := parseAccessToken(test.token)
+
+		if test.valid && (err != nil || authentication.UserID != userId || authentication.SessionID != sessionId) {
+			t.Errorf("parseAccessToken(%s) = %v, %v, expected user %s and session %s", test.name, authentication, err, userId.Hex(), sessionId.Hex())
+		}
+
+		if !test.valid && !errors.Is(err, ErrorInvalidAuthToken) {
+			t.Errorf("parseAccessToken(%s) = %v, %v, expected %v", test.name, authentication, err, ErrorInvalidAuthToken)
+		}
+	}
+
+}
+
+func TestAccessTokenKeyRotation(t *testing.T) {
+
+	userId, sessionId := primitive.NewObjectID(), primitive.NewObjectID()
+
+	setAccessTokenConfig(t, []accessTokenKey{rotatedAccessTokenKey}, time.Minute)
+	oldToken, err := createAccessToken(userId, sessionId)
+
+	if err != nil {
+		t.Fatalf("createAccessToken() failed: %v", err)
+	}
+
+	/* a new key is prepended, tokens of the old key stay valid until it is removed */
+	accessTokenKeys = []accessTokenKey{testAccessTokenKey, rotatedAccessTokenKey}
+	newToken, err := createAccessToken(userId, sessionId)
+
+	if err != nil {
+		t.Fatalf("createAccessToken() failed: %v", err)
+	}
+
+	if _, err := parseAccessToken(oldToken); err != nil {
+		t.Errorf("parseAccessToken() of a token of the previous key = %v, expected it to be valid", err)
+	}
+
+	if _, err := parseAccessToken(newToken); err != nil {
+		t.Errorf("parseAccessToken() of a token of the new key = %v, expected it to be valid", err)
+	}
+
+	accessTokenKeys = []accessTokenKey{testAccessTokenKey}
+
+	if _, err := parseAccessToken(oldToken); !errors.Is(err, ErrorInvalidAuthToken) {
+		t.Errorf("parseAccessToken() of a token of a removed key = %v, expected %v", err, ErrorInvalidAuthToken)
+	}
+
+	if _, err := parseAccessToken(newToken); err != nil {
+		t.Errorf("parseAccessToken() of a token of the new key = %v, expected it to be valid", err)
+	}
+
+	accessTokenLifetime = -time.Minute
+	expiredToken, err := createAccessToken(userId, sessionId)
+
+	if err != nil {
+		t.Fatalf("createAccessToken() failed: %v", err)
+	}
+
+	if _, err := parseAccessToken(expiredToken); !errors.Is(err, ErrorInvalidAuthToken) {
+		t.Errorf("parseAccessToken() of an expired token = %v, expected %v", err, ErrorInvalidAuthToken)
+	}
+
+}
+
+func TestLoadAccessTokenKeys(t *testing.T) {
+
+	secret := strings.Repeat("s", minAccessTokenKeyLength)
+
+	tests := []struct {
+		keys     string
+		lifetime string
+		expected []string
+		err      error
+	}{
+		{"", "", []string{"generated"}, nil},
+		{"2024:" + secret, "", []string{"2024"}, nil},
+		{"2025:" + secret + ", 2024:" + secret, "5m", []string{"2025", "2024"}, nil},
+		{"2024" + secret, "", nil, ErrorInvalidTokenConfig},
+		{":" + secret, "", nil, ErrorInvalidTokenConfig},
+		{"2024:short", "", nil, ErrorInvalidTokenConfig},
+		{"2024:" + secret + ",2024:" + secret, "", nil, ErrorInvalidTokenConfig},
+		{"2024:" + secret + ",", "", nil, ErrorInvalidTokenConfig},
+		{"2024:" + secret, "15 minutes", nil, ErrorInvalidTokenConfig},
+		{"2024:" + secret, "-5m", nil, ErrorInvalidTokenConfig},
+	}
+
+	for _, test := range tests {
+
+		setAccessTokenConfig(t, nil, defaultAccessTokenLifetime)
+		t.Setenv(constants.EnvKeyAccessTokenKeys, test.keys)
+		t.Setenv(constants.EnvKeyAccessTokenLifetime, test.lifetime)
+
+		err := LoadAccessTokenKeys()
+
+		ids := []string{}
+		for _, key := range accessTokenKeys {
+			ids = append(ids, key.ID)
+		}
+
+		if !errors.Is(err, test.err) || (err == nil && strings.Join(ids, ",") != strings.Join(test.expected, ",")) {
+			t.Errorf("LoadAccessTokenKeys() with %q, %q = %v, %v, expected %v, %v", test.keys, test.lifetime, ids, err, test.expected, test.err)
+		}
+	}
+
+}
@@ -1,10 +1,12 @@
 package database
 
 import (
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"time"
 	"yacoid_server/common"
+	"yacoid_server/mail"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
@@ -12,7 +14,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	gomail "gopkg.in/gomail.v2"
 )
 
 type User struct {
@@ -22,14 +23,17 @@ type User struct {
 	FirstName                        string             `bson:"first_name" json:"firstName" validate:"required,min=1"`
 	LastName                         string             `bson:"last_name" json:"lastName" validate:"required,min=1"`
 	Email                            string             `bson:"email" json:"email" validate:"required,email"`
+	EmailVerified                    bool               `bson:"email_verified" json:"emailVerified"`
 	PasswordHash                     string             `bson:"password_hash" json:"passwordHash" validate:"required"`
 	PasswordSalt                     string             `bson:"password_salt" json:"passwordSalt"`
-	AuthToken                        string             `bson:"auth_token,omitempty" json:"authToken,omitempty"`
+	AuthToken                        string             `bson:"-" json:"authToken,omitempty"`
 	PasswordResetToken               *string            `bson:"password_reset_token,omitempty" json:"-"`
 	PasswordResetTokenExpiryDate     *time.Time         `bson:"password_reset_token_expiry_date,omitempty" json:"-"`
 	PendingEmail                     *string            `bson:"pending_email,omitempty" json:"pendingEmail,omitempty"`
 	EmailVerificationToken           *string            `bson:"email_verification_token,omitempty" json:"-"`
 	EmailVerificationTokenExpiryDate *time.Time         `bson:"email_verification_token_expiry_date,omitempty" json:"-"`
+	EmailOptOuts                     []string           `bson:"email_opt_outs,omitempty" json:"emailOptOuts,omitempty"`
+	Language                         string             `bson:"language,omitempty" json:"language,omitempty" validate:"omitempty,oneof=de en"`
 }
 
 func (user *User) Validate(validate *validator.Validate) []string {
@@ -47,64 +51,146 @@ var ErrorPasswordResetExpiryDateExceeded = errors.New("PASSWORD_RESET_EXPIRY_DAT
 var ErrorEmailVerificationToken = errors.New("EMAIL_VERIFICATION_TOKEN_ERROR")
 var ErrorChangePasswordToken = errors.New("CHANGE_PASSWORD_TOKEN_ERROR")
 
-func Login(email string, passwordHash string) (*User, error) {
+const passwordResetValidDays = 7
+const emailVerificationValidDays = 1
 
-	fmt.Println(email, passwordHash)
-	user, err := GetUserByEmail(email)
+/*
+Login checks the password against the hash stored on the server. Legacy password hashes are the password itself,
+they are replaced by a server-side hash on the first successful login.
+*/
+func Login(email string, password string, client *AuditClient) (*User, error) {
+
+	user, err := checkCredentials(email, password)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if isCorrectPassword(user, passwordHash) {
+	return createSession(user, client)
 
-		newUser, authError := UpdateUserAuth(user)
-		if authError != nil {
-			return nil, authError
+}
+
+func checkCredentials(email string, password string) (*User, error) {
+
+	user, err := GetUserByEmail(email)
+
+	if err != nil {
+		if err == ErrorUserNotFound {
+			verifyDummyPassword(password)
+			return nil, ErrorInvalidCredentials
 		}
+		return nil, err
+	}
 
-		return newUser, nil
+	var correct, needsRehash bool
+
+	if isLegacyPasswordHash(user.PasswordHash) {
+		correct = subtle.ConstantTimeCompare([]byte(user.PasswordHash), []byte(password)) == 1
+		needsRehash = true
 	} else {
+
+		var verifyError error
+		correct, needsRehash, verifyError = verifyPassword(user.PasswordHash, password)
+
+		if verifyError != nil {
+			return nil, verifyError
+		}
+	}
+
+	if !correct {
 		return nil, ErrorInvalidCredentials
 	}
 
+	if needsRehash {
+
+		/* a failed migration is retried on the next login, it must not prevent this one */
+		if passwordHash, hashError := hashPassword(password); hashError != nil {
+			fmt.Println("Could not rehash password:", hashError)
+		} else if _, updateError := userCollection.UpdateOne(dbContext, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"password_hash": passwordHash}}); updateError != nil {
+			fmt.Println("Could not rehash password:", updateError)
+		}
+	}
+
+	return user, nil
+
 }
 
-func GetPasswordSalt(email string) (*string, error) {
+/*
+LegacyLogin checks a challenge derived from the password salt, which only works for accounts with a legacy password hash.
+*/
+func LegacyLogin(email string, challenge string, client *AuditClient) (*User, error) {
+
+	if !isLegacyLoginEnabled() {
+		return nil, ErrorLegacyLoginRetired
+	}
 
 	user, err := GetUserByEmail(email)
 
 	if err != nil {
-		if err == ErrorUserNotFound {
-			randomID := seededUUID(email) // send a fake uuid to not expose if an user does not exist
-			return &randomID, nil
-		}
 		return nil, err
 	}
 
+	if !isLegacyPasswordHash(user.PasswordHash) || !isCorrectPassword(user, challenge) {
+		return nil, ErrorInvalidCredentials
+	}
+
+	return createSession(user, client)
+
+}
+
+func GetPasswordSalt(email string) (*string, error) {
+
+	if !isLegacyLoginEnabled() {
+		return nil, ErrorLegacyLoginRetired
+	}
+
+	user, err := GetUserByEmail(email)
+
+	if err != nil && err != ErrorUserNotFound {
+		return nil, err
+	}
+
+	/* send a fake uuid to not expose if an user does not exist or was migrated already */
+	if user == nil || !isLegacyPasswordHash(user.PasswordHash) {
+		randomID := seededUUID(email)
+		return &randomID, nil
+	}
+
 	return &user.PasswordSalt, nil
 
 }
 
 func Logout(authToken string) error {
 
-	user, findError := GetUserByAuthToken(authToken)
-	if findError != nil {
-		if findError == mongo.ErrNoDocuments {
+	if len(authToken) == 0 {
+		return ErrorUserNotLoggedIn
+	}
+
+	filter := bson.M{"token_hash": hash(authToken), "refresh": bson.M{"$ne": true}}
+
+	/* the access token itself stays valid until it expires, but it cannot be refreshed anymore */
+	if isAccessToken(authToken) {
+
+		authentication, authError := parseAccessToken(authToken)
+
+		if authError != nil {
 			return ErrorUserNotLoggedIn
 		}
-		return findError
+
+		filter = bson.M{"_id": authentication.SessionID}
 	}
 
-	filter := bson.M{"_id": user.ID}
-	update := bson.M{
-		"$set": bson.M{"auth_token": nil},
+	result, err := sessionsCollection.DeleteOne(dbContext, filter)
+
+	if err != nil {
+		return err
 	}
 
-	var currentUser User
-	err := userCollection.FindOneAndUpdate(dbContext, filter, update).Decode(&currentUser)
+	if result.DeletedCount == 0 {
+		return ErrorUserNotLoggedIn
+	}
 
-	return err
+	return nil
 
 }
 
@@ -121,57 +207,40 @@ func Register(user User) (*User, error) {
 		return nil, ErrorUserAlreadyExists
 	}
 
-	user.ID = primitive.NewObjectID()
-	user.Admin = false
-	user.RegistrationDate = time.Now()
-	user.PasswordSalt = uuid.NewString()
-	_, err := userCollection.InsertOne(dbContext, &user)
-
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
-}
-
-func UpdateUserAuth(user *User) (*User, error) {
-
-	/*currentUser, authError := GetUserById(user.ID)
-
-	if authError != nil {
-
-		if authError == mongo.ErrNoDocuments {
-			return nil, UnknownError
-		}
+	passwordHash, hashError := hashPassword(user.PasswordHash)
 
-		return nil, authError
+	if hashError != nil {
+		return nil, hashError
 	}
 
-	if currentUser.AuthToken != "" {
-		return nil, UserAlreadyLoggedInError
-	}*/
-
-	filter := bson.M{"_id": user.ID}
-
-	id := uuid.NewString()
-
-	update := bson.M{
-		"$set": bson.M{"auth_token": id},
-	}
+	user.ID = primitive.NewObjectID()
+	user.Admin = false
+	user.RegistrationDate = time.Now()
+	user.PasswordHash = passwordHash
+	user.PasswordSalt = ""
+	user.EmailVerified = false
 
-	after := options.After
-	opt := options.FindOneAndUpdateOptions{
-		ReturnDocument: &after,
-	}
+	emailVerificationToken := uuid.NewString()
+	emailVerificationTokenExpiryDate := time.Now().Add(time.Hour * 24 * emailVerificationValidDays)
+	user.PendingEmail = nil
+	user.EmailVerificationToken = &emailVerificationToken
+	user.EmailVerificationTokenExpiryDate = &emailVerificationTokenExpiryDate
 
-	var newUser User
-	err := userCollection.FindOneAndUpdate(dbContext, filter, update, &opt).Decode(&newUser)
+	_, err := userCollection.InsertOne(dbContext, &user)
 
 	if err != nil {
+		if isDuplicateKeyErrorOnIndex(err, userEmailIndex) {
+			return nil, ErrorEmailAlreadyTaken
+		}
 		return nil, err
 	}
 
-	return &newUser, nil
+	/* the account exists regardless, a lost mail can be sent again with ResendEmailVerification */
+	if mailError := SendEmailVerification(&user, user.Email, emailVerificationToken); mailError != nil {
+		fmt.Println("Could not send email verification:", mailError)
+	}
 
+	return &user, nil
 }
 
 func UpdateExpoPushToken(authToken string, expoPushToken string) error {
@@ -198,12 +267,12 @@ func UpdateExpoPushToken(authToken string, expoPushToken string) error {
 
 }
 
-func InitiatePasswordReset(email string) (*string, error) {
+func InitiatePasswordReset(email string, client *AuditClient) error {
 
 	user, findError := GetUserByEmail(email)
 
 	if findError != nil {
-		return nil, findError
+		return findError
 	}
 
 	filter := bson.M{"_id": user.ID}
@@ -211,7 +280,7 @@ func InitiatePasswordReset(email string) (*string, error) {
 	passwordResetToken := uuid.NewString()
 
 	update := bson.M{
-		"$set": bson.M{"password_reset_token": passwordResetToken, "password_reset_token_expiry_date": time.Now().Add(time.Minute * 60 * 24 * 7)},
+		"$set": bson.M{"password_reset_token": passwordResetToken, "password_reset_token_expiry_date": time.Now().Add(time.Hour * 24 * passwordResetValidDays)},
 	}
 
 	after := options.After
@@ -223,20 +292,30 @@ func InitiatePasswordReset(email string) (*string, error) {
 	err := userCollection.FindOneAndUpdate(dbContext, filter, update, &opt).Decode(&updatedUser)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	sendPasswordResetEmail(email, passwordResetToken)
+	/* the request is anonymous, anyone knowing the email address can start a reset */
+	recordAudit(createAuditEntry(client, nil, AuditActionPasswordResetRequest, AuditTargetUser, user.ID, nil, nil))
+
+	mailError := sendPasswordResetEmail(user, passwordResetToken)
 
-	return &passwordResetToken, nil
+	if mailError != nil {
+		return mailError
+	}
+
+	return nil
 
 }
 
-func sendPasswordResetEmail(email string, passwordResetToken string) error {
-	return sendMail(email, "Password zurücksetzen", "<b>Klicke auf diesen Link, um dein Passwort zurückzusetzen:<b><br/><a href=\"http://localhost:3000/reset_password/"+passwordResetToken+"\">Passwort zurücksetzen</a>")
+func sendPasswordResetEmail(user *User, passwordResetToken string) error {
+
+	data := mail.TemplateData{"FirstName": user.FirstName, "Token": passwordResetToken, "ValidDays": passwordResetValidDays}
+	return sendTemplatedMail(user.Email, user.Language, mail.TemplatePasswordReset, data)
+
 }
 
-func ResetPassword(passwordResetToken string, passwordHash string) error {
+func ResetPassword(passwordResetToken string, passwordHash string, client *AuditClient) error {
 
 	user, findError := GetUserByPasswordResetToken(passwordResetToken)
 
@@ -248,12 +327,17 @@ func ResetPassword(passwordResetToken string, passwordHash string) error {
 		return ErrorPasswordResetExpiryDateExceeded
 	}
 
+	newPasswordHash, hashError := hashPassword(passwordHash)
+
+	if hashError != nil {
+		return hashError
+	}
+
 	filter := bson.M{"_id": user.ID}
 
 	update := bson.M{
-		"$set": bson.M{"password_reset_token": nil, "password_reset_token_expiry_date": nil, "password_hash": passwordHash},
+		"$set": bson.M{"password_reset_token": nil, "password_reset_token_expiry_date": nil, "password_hash": newPasswordHash},
 	}
-	fmt.Println("AC", update)
 
 	after := options.After
 	opt := options.FindOneAndUpdateOptions{
@@ -267,6 +351,13 @@ func ResetPassword(passwordResetToken string, passwordHash string) error {
 		return err
 	}
 
+	/* whoever knew the old password must not stay logged in */
+	if _, revokeError := revokeSessionsOfUser(dbContext, user.ID, nil); revokeError != nil {
+		fmt.Println("Could not revoke sessions:", revokeError)
+	}
+
+	recordAudit(createAuditEntry(client, &user.ID, AuditActionPasswordReset, AuditTargetUser, user.ID, nil, nil))
+
 	return nil
 
 }
@@ -287,16 +378,8 @@ func GetUserByEmail(email string) (*User, error) {
 
 func GetUserByAuthToken(authToken string) (*User, error) {
 
-	filter := bson.M{"auth_token": authToken}
-	user, err := GetUserByFilter(filter)
-
-	if err != nil {
-		if err == ErrorUserNotFound {
-			return nil, ErrorInvalidAuthToken
-		}
-		return nil, err
-	}
-	return user, nil
+	user, _, err := getUserAndAuthentication(authToken)
+	return user, err
 
 }
 
@@ -389,19 +472,23 @@ type ChangeAccountDataResponse struct {
 	FirstName         *UpdateState `bson:"first_name,omitempty" json:"firstName,omitempty"`
 	LastName          *UpdateState `bson:"last_name,omitempty" json:"lastName,omitempty"`
 	City              *UpdateState `bson:"city,omitempty" json:"city,omitempty"`
+	Language          *UpdateState `bson:"language,omitempty" json:"language,omitempty"`
 	EmailVerification *UpdateState `bson:"email_verification,omitempty" json:"emailVerification,omitempty"`
 	ChangePassword    *UpdateState `bson:"change_password,omitempty" json:"changePassword,omitempty"`
 }
 
-func ChangeAccountData(authToken string, firstName *string, lastName *string, email *string, city *string, currentPassword *string, newPassword *string) (*ChangeAccountDataResponse, error) {
+func ChangeAccountData(authToken string, firstName *string, lastName *string, email *string, city *string, language *string, currentPassword *string, newPassword *string, client *AuditClient) (*ChangeAccountDataResponse, error) {
 
-	user, userError := GetUserByAuthToken(authToken)
-	fmt.Println("USER", user, userError)
+	user, authentication, userError := getUserAndAuthentication(authToken)
 
 	if userError != nil {
 		return nil, userError
 	}
 
+	if language != nil && !mail.IsSupportedLanguage(*language) {
+		return nil, mail.ErrorUnsupportedLanguage
+	}
+
 	var response ChangeAccountDataResponse
 
 	options := options.FindOneAndUpdate()
@@ -414,49 +501,60 @@ func ChangeAccountData(authToken string, firstName *string, lastName *string, em
 	inputs = append(inputs, UpdateEntry{field: "first_name", value: firstName})
 	inputs = append(inputs, UpdateEntry{field: "last_name", value: lastName})
 	inputs = append(inputs, UpdateEntry{field: "city", value: city})
-	fmt.Println("INPUTS", inputs)
+	inputs = append(inputs, UpdateEntry{field: "language", value: language})
 	updateEntries := CreateUpdateDocument(inputs)
 
 	/* Handle email change */
 	var emailVerificationToken *string
 	if email != nil {
-		fmt.Println("SET EMAIL")
 		temp := uuid.NewString()
 		emailVerificationToken = &temp
 		updateEntries = append(updateEntries, bson.E{Key: "pending_email", Value: email})
 		updateEntries = append(updateEntries, bson.E{Key: "email_verification_token", Value: emailVerificationToken})
-		updateEntries = append(updateEntries, bson.E{Key: "email_verification_token_expiry_date", Value: time.Now().Add(time.Minute * 60 * 24 * 1)})
+		updateEntries = append(updateEntries, bson.E{Key: "email_verification_token_expiry_date", Value: time.Now().Add(time.Hour * 24 * emailVerificationValidDays)})
 	}
 
 	/* Handle password change */
 	if currentPassword != nil && newPassword != nil {
-		fmt.Println("SET PASSWORD")
-
 		if isCorrectPassword(user, *currentPassword) {
-			fmt.Println("NEW_PASSWORD")
-			updateEntries = append(updateEntries, bson.E{Key: "password_hash", Value: newPassword})
-			response.ChangePassword = &UpdateState{Success: true}
+			newPasswordHash, hashError := hashPassword(*newPassword)
+
+			if hashError != nil {
+				errorText := hashError.Error()
+				response.ChangePassword = &UpdateState{Success: false, Error: &errorText}
+			} else {
+				updateEntries = append(updateEntries, bson.E{Key: "password_hash", Value: newPasswordHash})
+				response.ChangePassword = &UpdateState{Success: true}
+			}
 		} else {
-			fmt.Println("INVALID_CREDENTIALS")
 			errorText := ErrorInvalidCredentials.Error()
 			response.ChangePassword = &UpdateState{Success: false, Error: &errorText}
 		}
 
 	}
 
-	fmt.Println("UPDATE_ENTRIES", updateEntries, emailVerificationToken)
-
 	if len(updateEntries) > 0 {
 		update := bson.D{{Key: "$set", Value: updateEntries}}
-		fmt.Println("UPDATE", update)
 
 		var document bson.D
 		unmarshalError := userCollection.FindOneAndUpdate(dbContext, filter, update, options).Decode(&document)
-		fmt.Println(unmarshalError, document)
 
 		if unmarshalError != nil {
 			return nil, unmarshalError
 		}
+
+		if email != nil {
+			recordAudit(createAuditEntry(client, &user.ID, AuditActionEmailChange, AuditTargetUser, user.ID, bson.M{"email": user.Email}, bson.M{"pendingEmail": *email}))
+		}
+
+		if response.ChangePassword != nil && response.ChangePassword.Success {
+
+			if _, revokeError := revokeSessionsOfUser(dbContext, user.ID, &authentication.SessionID); revokeError != nil {
+				fmt.Println("Could not revoke sessions:", revokeError)
+			}
+
+			recordAudit(createAuditEntry(client, &user.ID, AuditActionPasswordChange, AuditTargetUser, user.ID, nil, nil))
+		}
 	}
 
 	if firstName != nil {
@@ -471,12 +569,16 @@ func ChangeAccountData(authToken string, firstName *string, lastName *string, em
 		response.City = &UpdateState{Success: true}
 	}
 
+	if language != nil {
+		response.Language = &UpdateState{Success: true}
+	}
+
 	if email != nil {
 		if emailVerificationToken == nil {
 			errorText := ErrorEmailVerificationToken.Error()
 			response.EmailVerification = &UpdateState{Success: false, Error: &errorText}
 		} else {
-			emailError := SendEmailVerification(*email, *emailVerificationToken)
+			emailError := SendEmailVerification(user, *email, *emailVerificationToken)
 			if emailError == nil {
 				response.EmailVerification = &UpdateState{Success: true}
 			} else {
@@ -486,41 +588,39 @@ func ChangeAccountData(authToken string, firstName *string, lastName *string, em
 		}
 	}
 
-	fmt.Println("RESPONSE", response)
-
 	return &response, nil
 
 }
 
-func isCorrectPassword(user *User, passwordHash string) bool {
-	return hash(user.PasswordSalt+user.PasswordHash) == passwordHash
-}
+/*
+isCorrectPassword checks the password of a signed in user. For accounts with a legacy password hash,
+both the challenge of the legacy login and the legacy hash itself are accepted.
+*/
+func isCorrectPassword(user *User, password string) bool {
 
-func SendEmailVerification(email string, emailVerificationToken string) error {
-	return sendMail(email, "E-Mail bestätigen", "<b>Klicke auf diesen Link, um deine E-Mail zu bestätigen:<b><br/><a href=\"http://localhost:3000/verify_email/"+emailVerificationToken+"\">Email bestätigen</a>")
-}
-
-func sendMail(email string, subject string, htmlBody string) error {
+	if isLegacyPasswordHash(user.PasswordHash) {
+		challenge := hash(user.PasswordSalt + user.PasswordHash)
+		return subtle.ConstantTimeCompare([]byte(challenge), []byte(password)) == 1 ||
+			subtle.ConstantTimeCompare([]byte(user.PasswordHash), []byte(password)) == 1
+	}
 
-	mail := gomail.NewMessage()
-	mail.SetHeader("From", "help@yacoid.de")
-	mail.SetHeader("To", email)
-	mail.SetHeader("Subject", subject)
-	mail.SetBody("text/html", htmlBody)
+	correct, _, err := verifyPassword(user.PasswordHash, password)
+	return err == nil && correct
 
-	dialer := gomail.NewDialer("localhost", 2525, "email", "password")
+}
 
-	if err := dialer.DialAndSend(mail); err != nil {
-		return err
-	}
+/*
+SendEmailVerification mails the verification link to the given address, which is not yet the address of the user.
+*/
+func SendEmailVerification(user *User, email string, emailVerificationToken string) error {
 
-	return nil
+	data := mail.TemplateData{"FirstName": user.FirstName, "Token": emailVerificationToken, "ValidDays": emailVerificationValidDays}
+	return sendTemplatedMail(email, user.Language, mail.TemplateEmailVerification, data)
 
 }
 
-func DeleteUser(authToken string, passwordHash string, reason string) error {
+func DeleteUser(authToken string, passwordHash string, reason string, client *AuditClient) error {
 
-	fmt.Println(authToken, passwordHash, reason)
 	user, findError := GetUserByAuthToken(authToken)
 
 	if findError != nil {
@@ -529,12 +629,39 @@ func DeleteUser(authToken string, passwordHash string, reason string) error {
 
 	if isCorrectPassword(user, passwordHash) {
 
-		filter := bson.M{"_id": user.ID}
+		/* the user is only removed together with all references to it */
+		return runInTransaction(func(sessionContext mongo.SessionContext) error {
 
-		var result bson.D
-		err := userCollection.FindOneAndDelete(dbContext, filter).Decode(&result)
+			anonymizeError := anonymizeUserReferences(sessionContext, user.ID)
 
-		return err
+			if anonymizeError != nil {
+				return anonymizeError
+			}
+
+			savedSearchesError := deleteSavedSearchesOfUser(sessionContext, user.ID)
+
+			if savedSearchesError != nil {
+				return savedSearchesError
+			}
+
+			if _, revokeError := revokeSessionsOfUser(sessionContext, user.ID, nil); revokeError != nil {
+				return revokeError
+			}
+
+			/* the entry is part of the transaction, a deletion without trail must not happen */
+			auditEntry := createAuditEntry(client, &user.ID, AuditActionUserDelete, AuditTargetUser, user.ID, nil, bson.M{"reason": reason})
+			auditError := insertAuditEntry(sessionContext, auditEntry)
+
+			if auditError != nil {
+				return auditError
+			}
+
+			filter := bson.M{"_id": user.ID}
+
+			var result bson.D
+			return userCollection.FindOneAndDelete(sessionContext, filter).Decode(&result)
+
+		})
 
 	} else {
 		return ErrorInvalidCredentials
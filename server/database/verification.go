@@ -0,0 +1,158 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+	"yacoid_server/constants"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrorInvalidEmailVerificationToken = errors.New("INVALID_EMAIL_VERIFICATION_TOKEN")
+var ErrorEmailVerificationExpiryDateExceeded = errors.New("EMAIL_VERIFICATION_EXPIRY_DATE_EXCEEDED")
+var ErrorEmailAlreadyVerified = errors.New("EMAIL_ALREADY_VERIFIED")
+var ErrorEmailAlreadyTaken = errors.New("EMAIL_ALREADY_TAKEN")
+var ErrorEmailNotVerified = errors.New("EMAIL_NOT_VERIFIED")
+
+/*
+requiresVerifiedEmail reports whether only users with a verified email address may submit definitions.
+*/
+func requiresVerifiedEmail() bool {
+
+	required, err := strconv.ParseBool(os.Getenv(constants.EnvKeyRequireVerifiedEmail))
+	return err == nil && required
+
+}
+
+/*
+createEmailVerification stores a new verification token for the user, replacing a previous one.
+A pending email is verified as the new address of the user, without one the current address is verified.
+*/
+func createEmailVerification(user *User) (string, error) {
+
+	token := uuid.NewString()
+
+	update := bson.M{
+		"$set": bson.M{"email_verification_token": token, "email_verification_token_expiry_date": time.Now().Add(time.Hour * 24 * emailVerificationValidDays)},
+	}
+
+	_, err := userCollection.UpdateOne(dbContext, bson.M{"_id": user.ID}, update)
+
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+
+}
+
+/*
+VerifyEmail consumes a verification token. For a changed address, the pending email becomes the email of the user,
+unless another account registered or verified the address in the meantime.
+*/
+func VerifyEmail(emailVerificationToken string, client *AuditClient) (*User, error) {
+
+	user, findError := GetUserByFilter(bson.M{"email_verification_token": emailVerificationToken})
+
+	if findError != nil {
+		if findError == ErrorUserNotFound {
+			return nil, ErrorInvalidEmailVerificationToken
+		}
+		return nil, findError
+	}
+
+	if user.EmailVerificationTokenExpiryDate == nil || time.Now().After(*user.EmailVerificationTokenExpiryDate) {
+		return nil, ErrorEmailVerificationExpiryDateExceeded
+	}
+
+	set := bson.M{"email_verified": true}
+
+	if user.PendingEmail != nil {
+
+		owner, ownerError := GetUserByEmail(*user.PendingEmail)
+
+		if ownerError != nil && ownerError != ErrorUserNotFound {
+			return nil, ownerError
+		}
+
+		if owner != nil && owner.ID != user.ID {
+			return nil, ErrorEmailAlreadyTaken
+		}
+
+		set["email"] = *user.PendingEmail
+	}
+
+	/* the token is part of the filter, so that it can only be used once */
+	filter := bson.M{"_id": user.ID, "email_verification_token": emailVerificationToken}
+	update := bson.M{
+		"$set":   set,
+		"$unset": bson.M{"pending_email": "", "email_verification_token": "", "email_verification_token_expiry_date": ""},
+	}
+
+	var verifiedUser User
+	err := userCollection.FindOneAndUpdate(dbContext, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&verifiedUser)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrorInvalidEmailVerificationToken
+		}
+		/* another account may have taken the address since it was checked above */
+		if isDuplicateKeyErrorOnIndex(err, userEmailIndex) {
+			return nil, ErrorEmailAlreadyTaken
+		}
+		return nil, err
+	}
+
+	recordAudit(createAuditEntry(client, &user.ID, AuditActionEmailVerify, AuditTargetUser, user.ID, bson.M{"email": user.Email, "emailVerified": user.EmailVerified}, bson.M{"email": verifiedUser.Email, "emailVerified": true}))
+
+	return &verifiedUser, nil
+
+}
+
+/*
+ResendEmailVerification sends a new verification link for the pending email or, if there is none, for the unverified address of the user.
+*/
+func ResendEmailVerification(authToken string) error {
+
+	user, userError := GetUserByAuthToken(authToken)
+
+	if userError != nil {
+		return userError
+	}
+
+	email := user.Email
+
+	if user.PendingEmail != nil {
+		email = *user.PendingEmail
+	} else if user.EmailVerified {
+		return ErrorEmailAlreadyVerified
+	}
+
+	token, err := createEmailVerification(user)
+
+	if err != nil {
+		return err
+	}
+
+	return SendEmailVerification(user, email, token)
+
+}
+
+/*
+migrateEmailVerification marks users registered before email addresses were verified as verified,
+so that they are not locked out when verified addresses are required.
+*/
+func migrateEmailVerification() error {
+
+	filter := bson.M{"email_verified": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"email_verified": true}}
+
+	_, err := userCollection.UpdateMany(dbContext, filter, update)
+	return err
+
+}
@@ -0,0 +1,719 @@
+package database
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"yacoid_server/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrorWebhookNotFound = errors.New("WEBHOOK_NOT_FOUND")
+var ErrorWebhookDeliveryNotFound = errors.New("WEBHOOK_DELIVERY_NOT_FOUND")
+var ErrorInvalidWebhookEvent = errors.New("INVALID_WEBHOOK_EVENT")
+var ErrorInvalidWebhookURL = errors.New("INVALID_WEBHOOK_URL")
+var ErrorWebhookInactive = errors.New("WEBHOOK_INACTIVE")
+
+const (
+	WebhookEventDefinitionSubmitted = "definition.submitted"
+	WebhookEventDefinitionApproved  = "definition.approved"
+	WebhookEventDefinitionRejected  = "definition.rejected"
+	WebhookEventDefinitionChanged   = "definition.changed"
+	WebhookEventAuthorCreated       = "author.created"
+	WebhookEventSourceCreated       = "source.created"
+)
+
+var WebhookEvents = []string{
+	WebhookEventDefinitionSubmitted,
+	WebhookEventDefinitionApproved,
+	WebhookEventDefinitionRejected,
+	WebhookEventDefinitionChanged,
+	WebhookEventAuthorCreated,
+	WebhookEventSourceCreated,
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+/*
+The receiver verifies a delivery by computing the HMAC-SHA256 of the raw request body with the secret of the webhook
+and comparing it to the hex digest in the signature header, which is prefixed with "sha256=".
+*/
+const (
+	WebhookSignatureHeader = "X-Yacoid-Signature-256"
+	WebhookEventHeader     = "X-Yacoid-Event"
+	WebhookDeliveryHeader  = "X-Yacoid-Delivery"
+)
+
+const maxWebhookAttempts = 8
+const webhookRetryBaseDelay = 30 * time.Second
+const webhookTimeout = 10 * time.Second
+const webhookPollInterval = 15 * time.Second
+const maxWebhookDeliveries = 100
+
+/*
+webhookClient only connects to public addresses, which is checked again for every connection,
+since the DNS entry of a host may change after the webhook was validated. Proxies are not used,
+because the check would only see the address of the proxy.
+*/
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: webhookTimeout, Control: checkWebhookConnection}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+	},
+}
+
+/* shared address space of carrier-grade NATs, which net.IP.IsPrivate does not cover */
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+type Webhook struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	URL         string             `bson:"url" json:"url"`
+	Events      []string           `bson:"events" json:"events"`
+	Secret      string             `bson:"secret" json:"-"`
+	Active      bool               `bson:"active" json:"active"`
+	CreatedBy   primitive.ObjectID `bson:"created_by" json:"createdBy"`
+	CreatedDate time.Time          `bson:"created_date" json:"createdDate"`
+}
+
+/*
+WebhookDelivery is an entry of the delivery log. Failed attempts are retried with exponential backoff
+until the delivery succeeds or maxWebhookAttempts is reached. Redeliveries are logged as new deliveries.
+*/
+type WebhookDelivery struct {
+	ID              primitive.ObjectID  `bson:"_id" json:"id"`
+	WebhookID       primitive.ObjectID  `bson:"webhook_id" json:"webhookId"`
+	Event           string              `bson:"event" json:"event"`
+	Payload         string              `bson:"payload" json:"payload"`
+	Status          string              `bson:"status" json:"status"`
+	Attempts        int                 `bson:"attempts" json:"attempts"`
+	ResponseStatus  *int                `bson:"response_status,omitempty" json:"responseStatus,omitempty"`
+	LastError       *string             `bson:"last_error,omitempty" json:"lastError,omitempty"`
+	CreatedDate     time.Time           `bson:"created_date" json:"createdDate"`
+	LastAttemptDate *time.Time          `bson:"last_attempt_date,omitempty" json:"lastAttemptDate,omitempty"`
+	NextAttemptDate *time.Time          `bson:"next_attempt_date,omitempty" json:"nextAttemptDate,omitempty"`
+	RedeliveryOf    *primitive.ObjectID `bson:"redelivery_of,omitempty" json:"redeliveryOf,omitempty"`
+}
+
+type webhookPayload struct {
+	Event string      `json:"event"`
+	Date  time.Time   `json:"date"`
+	Data  interface{} `json:"data"`
+}
+
+func validateWebhookEvents(events []string) error {
+
+	for _, event := range events {
+		if !containsString(WebhookEvents, event) {
+			return ErrorInvalidWebhookEvent
+		}
+	}
+
+	return nil
+
+}
+
+/*
+validateWebhookURL requires a http(s) URL whose host resolves to public addresses only, so that webhooks
+cannot be used to reach the loopback interface, the private network or cloud metadata services like 169.254.169.254.
+*/
+func validateWebhookURL(webhookUrl string) error {
+
+	parsed, err := url.Parse(webhookUrl)
+
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Hostname()) == 0 {
+		return ErrorInvalidWebhookURL
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrorInvalidWebhookURL
+	}
+
+	ips := []net.IP{net.ParseIP(host)}
+
+	if ips[0] == nil {
+
+		ips, err = net.LookupIP(host)
+
+		if err != nil || len(ips) == 0 {
+			return ErrorInvalidWebhookURL
+		}
+	}
+
+	for _, ip := range ips {
+		if !isPublicWebhookIP(ip) {
+			return ErrorInvalidWebhookURL
+		}
+	}
+
+	return nil
+
+}
+
+func isPublicWebhookIP(ip net.IP) bool {
+
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() &&
+		!sharedAddressSpace.Contains(ip)
+
+}
+
+/*
+checkWebhookConnection refuses connections of the webhook client to addresses that are not public.
+*/
+func checkWebhookConnection(network string, address string, connection syscall.RawConn) error {
+
+	host, _, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !isPublicWebhookIP(ip) {
+		return ErrorInvalidWebhookURL
+	}
+
+	return nil
+
+}
+
+func createWebhookSecret() (string, error) {
+
+	secret := make([]byte, 32)
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+
+}
+
+/*
+SignWebhookPayload returns the value of the signature header for the payload.
+*/
+func SignWebhookPayload(secret string, payload []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+}
+
+/*
+CreateWebhook subscribes the URL to the events. The secret is only returned here and cannot be read again later.
+*/
+func CreateWebhook(request *types.CreateWebhookRequest, authToken string) (*Webhook, string, error) {
+
+	user, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, "", userError
+	}
+
+	if err := validateWebhookURL(request.URL); err != nil {
+		return nil, "", err
+	}
+
+	if err := validateWebhookEvents(request.Events); err != nil {
+		return nil, "", err
+	}
+
+	secret, secretError := createWebhookSecret()
+
+	if secretError != nil {
+		return nil, "", secretError
+	}
+
+	webhook := Webhook{
+		ID:          primitive.NewObjectID(),
+		URL:         request.URL,
+		Events:      request.Events,
+		Secret:      secret,
+		Active:      true,
+		CreatedBy:   user.ID,
+		CreatedDate: time.Now(),
+	}
+
+	_, err := webhooksCollection.InsertOne(dbContext, webhook)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &webhook, secret, nil
+
+}
+
+func GetWebhooks(authToken string) ([]*Webhook, error) {
+
+	_, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	options := options.Find().SetSort(bson.D{{Key: "created_date", Value: 1}})
+	return getWebhooks(bson.M{}, options)
+
+}
+
+func UpdateWebhook(request *types.UpdateWebhookRequest, authToken string) (*Webhook, error) {
+
+	_, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	id, idError := primitive.ObjectIDFromHex(request.ID)
+
+	if idError != nil {
+		return nil, InvalidID
+	}
+
+	updateEntries := bson.D{}
+
+	if request.URL != nil {
+
+		if err := validateWebhookURL(*request.URL); err != nil {
+			return nil, err
+		}
+
+		updateEntries = append(updateEntries, bson.E{Key: "url", Value: *request.URL})
+	}
+
+	if request.Events != nil {
+
+		if err := validateWebhookEvents(*request.Events); err != nil {
+			return nil, err
+		}
+
+		updateEntries = append(updateEntries, bson.E{Key: "events", Value: *request.Events})
+	}
+
+	if request.Active != nil {
+		updateEntries = append(updateEntries, bson.E{Key: "active", Value: *request.Active})
+	}
+
+	if len(updateEntries) == 0 {
+		return getWebhook(bson.M{"_id": id})
+	}
+
+	var webhook Webhook
+	options := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := webhooksCollection.FindOneAndUpdate(dbContext, bson.M{"_id": id}, bson.M{"$set": updateEntries}, options).Decode(&webhook)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrorWebhookNotFound
+		}
+		return nil, err
+	}
+
+	return &webhook, nil
+
+}
+
+/*
+DeleteWebhook removes the webhook together with its delivery log.
+*/
+func DeleteWebhook(webhookId string, authToken string) error {
+
+	_, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return userError
+	}
+
+	id, idError := primitive.ObjectIDFromHex(webhookId)
+
+	if idError != nil {
+		return InvalidID
+	}
+
+	return runInTransaction(func(sessionContext mongo.SessionContext) error {
+
+		/* the deliveries go first, so that none is left without its webhook if the second write fails */
+		_, err := webhookDeliveriesCollection.DeleteMany(sessionContext, bson.M{"webhook_id": id})
+
+		if err != nil {
+			return err
+		}
+
+		result, err := webhooksCollection.DeleteOne(sessionContext, bson.M{"_id": id})
+
+		if err != nil {
+			return err
+		}
+
+		if result.DeletedCount == 0 {
+			return ErrorWebhookNotFound
+		}
+
+		return nil
+
+	})
+
+}
+
+/*
+GetWebhookDeliveries returns the newest deliveries of the webhook, newest first.
+*/
+func GetWebhookDeliveries(webhookId string, authToken string) ([]*WebhookDelivery, error) {
+
+	_, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	id, idError := primitive.ObjectIDFromHex(webhookId)
+
+	if idError != nil {
+		return nil, InvalidID
+	}
+
+	if _, err := getWebhook(bson.M{"_id": id}); err != nil {
+		return nil, err
+	}
+
+	options := options.Find().SetSort(bson.D{{Key: "created_date", Value: -1}}).SetLimit(maxWebhookDeliveries)
+	return getWebhookDeliveries(bson.M{"webhook_id": id}, options)
+
+}
+
+/*
+RedeliverWebhookDelivery sends the payload of a previous delivery again as a new delivery.
+*/
+func RedeliverWebhookDelivery(deliveryId string, authToken string) (*WebhookDelivery, error) {
+
+	_, userError := getAdmin(authToken)
+
+	if userError != nil {
+		return nil, userError
+	}
+
+	id, idError := primitive.ObjectIDFromHex(deliveryId)
+
+	if idError != nil {
+		return nil, InvalidID
+	}
+
+	var original WebhookDelivery
+	err := webhookDeliveriesCollection.FindOne(dbContext, bson.M{"_id": id}).Decode(&original)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrorWebhookDeliveryNotFound
+		}
+		return nil, err
+	}
+
+	webhook, webhookError := getWebhook(bson.M{"_id": original.WebhookID})
+
+	if webhookError != nil {
+		return nil, webhookError
+	}
+
+	if !webhook.Active {
+		return nil, ErrorWebhookInactive
+	}
+
+	delivery := createWebhookDelivery(original.WebhookID, original.Event, original.Payload)
+	delivery.RedeliveryOf = &original.ID
+
+	if _, err := webhookDeliveriesCollection.InsertOne(dbContext, delivery); err != nil {
+		return nil, err
+	}
+
+	go attemptWebhookDelivery(delivery.ID)
+
+	return delivery, nil
+
+}
+
+func createWebhookDelivery(webhookId primitive.ObjectID, event string, payload string) *WebhookDelivery {
+
+	now := time.Now()
+
+	return &WebhookDelivery{
+		ID:              primitive.NewObjectID(),
+		WebhookID:       webhookId,
+		Event:           event,
+		Payload:         payload,
+		Status:          WebhookDeliveryPending,
+		Attempts:        0,
+		CreatedDate:     now,
+		NextAttemptDate: &now,
+	}
+
+}
+
+/*
+publishWebhookEvent queues a delivery for every active webhook subscribed to the event and sends them in the background.
+Webhooks must never fail the request that triggered the event, so errors are only logged.
+*/
+func publishWebhookEvent(event string, data interface{}) {
+
+	payload, err := json.Marshal(webhookPayload{Event: event, Date: time.Now(), Data: data})
+
+	if err != nil {
+		fmt.Println("Could not publish webhook event:", err)
+		return
+	}
+
+	go func() {
+
+		webhooks, err := getWebhooks(bson.M{"active": true, "events": event}, nil)
+
+		if err != nil {
+			fmt.Println("Could not publish webhook event:", err)
+			return
+		}
+
+		for _, webhook := range webhooks {
+
+			delivery := createWebhookDelivery(webhook.ID, event, string(payload))
+
+			if _, err := webhookDeliveriesCollection.InsertOne(dbContext, delivery); err != nil {
+				fmt.Println("Could not publish webhook event:", err)
+				continue
+			}
+
+			attemptWebhookDelivery(delivery.ID)
+		}
+
+	}()
+
+}
+
+/*
+StartWebhookDeliveries periodically retries the pending deliveries whose backoff has expired.
+*/
+func StartWebhookDeliveries() {
+
+	go func() {
+
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+
+			filter := bson.M{"status": WebhookDeliveryPending, "next_attempt_date": bson.M{"$lte": time.Now()}}
+			options := options.Find().SetSort(bson.D{{Key: "next_attempt_date", Value: 1}}).SetLimit(maxWebhookDeliveries)
+			deliveries, err := getWebhookDeliveries(filter, options)
+
+			if err != nil {
+				fmt.Println("Could not retry webhook deliveries:", err)
+				continue
+			}
+
+			for _, delivery := range deliveries {
+				attemptWebhookDelivery(delivery.ID)
+			}
+		}
+
+	}()
+
+}
+
+/*
+attemptWebhookDelivery sends a due delivery once. The delivery is claimed by moving its next attempt behind
+the request timeout first, so that the immediate attempt and the retry loop never send it twice at the same time.
+*/
+func attemptWebhookDelivery(deliveryId primitive.ObjectID) {
+
+	now := time.Now()
+	lease := now.Add(2 * webhookTimeout)
+
+	filter := bson.M{"_id": deliveryId, "status": WebhookDeliveryPending, "next_attempt_date": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"next_attempt_date": lease}}
+
+	var delivery WebhookDelivery
+	options := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := webhookDeliveriesCollection.FindOneAndUpdate(dbContext, filter, update, options).Decode(&delivery)
+
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			fmt.Println("Could not claim webhook delivery:", err)
+		}
+		return
+	}
+
+	var responseStatus *int
+	var deliveryError error
+
+	webhook, webhookError := getWebhook(bson.M{"_id": delivery.WebhookID})
+
+	if webhookError == nil && !webhook.Active {
+		webhookError = ErrorWebhookInactive
+	}
+
+	if webhookError != nil {
+		deliveryError = webhookError
+	} else {
+		responseStatus, deliveryError = sendWebhookDelivery(webhook, &delivery)
+	}
+
+	attempts := delivery.Attempts + 1
+	set := bson.M{"attempts": attempts, "last_attempt_date": now}
+	unset := bson.M{}
+
+	if responseStatus != nil {
+		set["response_status"] = *responseStatus
+	}
+
+	switch {
+	case deliveryError == nil:
+		set["status"] = WebhookDeliveryDelivered
+		unset["next_attempt_date"] = ""
+		unset["last_error"] = ""
+	case webhookError != nil || attempts >= maxWebhookAttempts:
+		set["status"] = WebhookDeliveryFailed
+		set["last_error"] = deliveryError.Error()
+		unset["next_attempt_date"] = ""
+	default:
+		set["last_error"] = deliveryError.Error()
+		set["next_attempt_date"] = now.Add(webhookRetryBaseDelay * time.Duration(1<<(attempts-1)))
+	}
+
+	result := bson.M{"$set": set}
+	if len(unset) > 0 {
+		result["$unset"] = unset
+	}
+
+	if _, err := webhookDeliveriesCollection.UpdateOne(dbContext, bson.M{"_id": delivery.ID}, result); err != nil {
+		fmt.Println("Could not update webhook delivery:", err)
+	}
+
+}
+
+func sendWebhookDelivery(webhook *Webhook, delivery *WebhookDelivery) (*int, error) {
+
+	payload := []byte(delivery.Payload)
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(payload))
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "YACOID-Webhooks")
+	request.Header.Set(WebhookEventHeader, delivery.Event)
+	request.Header.Set(WebhookDeliveryHeader, delivery.ID.Hex())
+	request.Header.Set(WebhookSignatureHeader, SignWebhookPayload(webhook.Secret, payload))
+
+	response, err := webhookClient.Do(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	status := response.StatusCode
+
+	if status < 200 || status >= 300 {
+		return &status, fmt.Errorf("unexpected response status %d", status)
+	}
+
+	return &status, nil
+
+}
+
+func getWebhook(filter interface{}) (*Webhook, error) {
+
+	var webhook Webhook
+	err := webhooksCollection.FindOne(dbContext, filter).Decode(&webhook)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrorWebhookNotFound
+		}
+		return nil, err
+	}
+
+	return &webhook, nil
+
+}
+
+func getWebhooks(filter interface{}, options *options.FindOptions) ([]*Webhook, error) {
+
+	cursor, err := webhooksCollection.Find(dbContext, filter, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	webhooks := []*Webhook{}
+
+	for cursor.Next(dbContext) {
+
+		webhook := Webhook{}
+		err := cursor.Decode(&webhook)
+
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	return webhooks, nil
+
+}
+
+func getWebhookDeliveries(filter interface{}, options *options.FindOptions) ([]*WebhookDelivery, error) {
+
+	cursor, err := webhookDeliveriesCollection.Find(dbContext, filter, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(dbContext)
+
+	deliveries := []*WebhookDelivery{}
+
+	for cursor.Next(dbContext) {
+
+		delivery := WebhookDelivery{}
+		err := cursor.Decode(&delivery)
+
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	return deliveries, nil
+
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"net"
+	"testing"
+)
+
+func TestValidateWebhookURL(t *testing.T) {
+
+	tests := []struct {
+		input string
+		err   error
+	}{
+		{"https://93.184.216.34/hooks", nil},
+		{"http://93.184.216.34:8080/hooks?token=1", nil},
+		{"https://[2606:2800:220:1:248:1893:25c8:1946]/hooks", nil},
+		{"ftp://93.184.216.34/hooks", ErrorInvalidWebhookURL},
+		{"https:///hooks", ErrorInvalidWebhookURL},
+		{"not a url", ErrorInvalidWebhookURL},
+		{"http://localhost:8080/hooks", ErrorInvalidWebhookURL},
+		{"http://LOCALHOST./hooks", ErrorInvalidWebhookURL},
+		{"http://api.localhost/hooks", ErrorInvalidWebhookURL},
+		{"http://127.0.0.1/hooks", ErrorInvalidWebhookURL},
+		{"http://127.1.2.3/hooks", ErrorInvalidWebhookURL},
+		{"http://[::1]/hooks", ErrorInvalidWebhookURL},
+		{"http://0.0.0.0/hooks", ErrorInvalidWebhookURL},
+		{"http://169.254.169.254/latest/meta-data/", ErrorInvalidWebhookURL},
+		{"http://[fe80::1]/hooks", ErrorInvalidWebhookURL},
+		{"http://10.0.0.5/hooks", ErrorInvalidWebhookURL},
+		{"http://172.16.0.1/hooks", ErrorInvalidWebhookURL},
+		{"http://192.168.1.1/hooks", ErrorInvalidWebhookURL},
+		{"http://100.64.0.1/hooks", ErrorInvalidWebhookURL},
+		{"http://[fd00::1]/hooks", ErrorInvalidWebhookURL},
+		{"http://[::ffff:127.0.0.1]/hooks", ErrorInvalidWebhookURL},
+		{"http://[::ffff:169.254.169.254]/hooks", ErrorInvalidWebhookURL},
+		{"http://224.0.0.1/hooks", ErrorInvalidWebhookURL},
+	}
+
+	for _, test := range tests {
+
+		if err := validateWebhookURL(test.input); err != test.err {
+			t.Errorf("validateWebhookURL(%q) = %v, expected %v", test.input, err, test.err)
+		}
+	}
+
+}
+
+func TestCheckWebhookConnection(t *testing.T) {
+
+	tests := []struct {
+		address string
+		err     error
+	}{
+		{"93.184.216.34:443", nil},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", nil},
+		{"127.0.0.1:80", ErrorInvalidWebhookURL},
+		{"169.254.169.254:80", ErrorInvalidWebhookURL},
+		{"[::1]:443", ErrorInvalidWebhookURL},
+		{"192.168.0.10:8080", ErrorInvalidWebhookURL},
+	}
+
+	for _, test := range tests {
+
+		if err := checkWebhookConnection("tcp", test.address, nil); err != test.err {
+			t.Errorf("checkWebhookConnection(%q) = %v, expected %v", test.address, err, test.err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+
+	defer listener.Close()
+
+	if _, err := webhookClient.Get("http://" + listener.Addr().String() + "/hooks"); err == nil {
+		t.Errorf("webhook client connected to %s", listener.Addr())
+	}
+
+}
@@ -0,0 +1,374 @@
+package graphql
+
+import (
+	"context"
+	"yacoid_server/database"
+
+	gql "github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type contextKey struct{}
+
+/*
+Context holds everything that belongs to a single GraphQL request: the auth token of the caller and
+the loaders, which cache every entity loaded during the request so that it is fetched at most once.
+*/
+type Context struct {
+	AuthToken string
+
+	user       *database.User
+	userLoaded bool
+
+	authors     *loader[primitive.ObjectID]
+	sources     *loader[primitive.ObjectID]
+	definitions *loader[primitive.ObjectID]
+	users       *loader[primitive.ObjectID]
+
+	sourceDefinitions *loader[primitive.ObjectID]
+	authorSources     *loader[primitive.ObjectID]
+	tagDefinitions    *loader[string]
+
+	tagCounts map[string]int
+}
+
+func NewContext(authToken string) *Context {
+
+	context := &Context{AuthToken: authToken}
+
+	context.authors = newLoader(context, fetchAuthors)
+	context.sources = newLoader(context, fetchSources)
+	context.definitions = newLoader(context, fetchDefinitions)
+	context.users = newLoader(context, fetchUsers)
+	context.sourceDefinitions = newLoader(context, fetchSourceDefinitions)
+	context.authorSources = newLoader(context, fetchAuthorSources)
+	context.tagDefinitions = newLoader(context, fetchTagDefinitions)
+
+	return context
+
+}
+
+/*
+User returns the authenticated user or nil for anonymous requests.
+*/
+func (context *Context) User() *database.User {
+
+	if !context.userLoaded {
+		context.user = database.GetOptionalUser(context.AuthToken)
+		context.userLoaded = true
+	}
+
+	return context.user
+
+}
+
+func withContext(parent context.Context, requestContext *Context) context.Context {
+	return context.WithValue(parent, contextKey{}, requestContext)
+}
+
+func getContext(params gql.ResolveParams) *Context {
+	return params.Context.Value(contextKey{}).(*Context)
+}
+
+/*
+Thunk is resolved by the executor after all fields of the current level were resolved.
+*/
+type Thunk = func() (interface{}, error)
+
+/*
+loader batches the lookups of one kind of entity. Resolvers register the keys they need and return a thunk.
+The executor resolves thunks breadth-first, so by the time the first thunk of a level runs, the keys of all
+parents of that level are registered and are fetched with a single query.
+Entities that do not exist or are not visible to the caller are cached as nil.
+*/
+type loader[K comparable] struct {
+	context *Context
+	cache   map[K]interface{}
+	pending []K
+	fetch   func(context *Context, keys []K) (map[K]interface{}, error)
+}
+
+func newLoader[K comparable](context *Context, fetch func(context *Context, keys []K) (map[K]interface{}, error)) *loader[K] {
+	return &loader[K]{context: context, cache: map[K]interface{}{}, fetch: fetch}
+}
+
+func (loader *loader[K]) register(keys []K) {
+
+	for _, key := range keys {
+		if _, cached := loader.cache[key]; !cached {
+			loader.pending = append(loader.pending, key)
+		}
+	}
+
+}
+
+/*
+fetchPending loads all distinct registered keys that are not cached yet with a single query.
+*/
+func (loader *loader[K]) fetchPending() error {
+
+	if len(loader.pending) == 0 {
+		return nil
+	}
+
+	missing := []K{}
+	requested := map[K]bool{}
+	for _, key := range loader.pending {
+		if _, cached := loader.cache[key]; !cached && !requested[key] {
+			missing = append(missing, key)
+			requested[key] = true
+		}
+	}
+
+	loader.pending = nil
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	fetched, err := loader.fetch(loader.context, missing)
+
+	if err != nil {
+		return err
+	}
+
+	for _, key := range missing {
+		loader.cache[key] = fetched[key]
+	}
+
+	return nil
+
+}
+
+/*
+load returns a thunk resolving to the entity of the key.
+*/
+func (loader *loader[K]) load(key K) Thunk {
+
+	loader.register([]K{key})
+
+	return func() (interface{}, error) {
+
+		if err := loader.fetchPending(); err != nil {
+			return nil, err
+		}
+
+		return loader.cache[key], nil
+
+	}
+
+}
+
+/*
+loadMany returns a thunk resolving to the existing, visible entities of the keys in their order.
+*/
+func (loader *loader[K]) loadMany(keys []K) Thunk {
+
+	loader.register(keys)
+
+	return func() (interface{}, error) {
+
+		if err := loader.fetchPending(); err != nil {
+			return nil, err
+		}
+
+		entities := []interface{}{}
+		for _, key := range keys {
+			if entity := loader.cache[key]; entity != nil {
+				entities = append(entities, entity)
+			}
+		}
+
+		return entities, nil
+
+	}
+
+}
+
+/*
+prime adds already loaded entities to the cache.
+*/
+func (loader *loader[K]) prime(key K, entity interface{}) {
+	loader.cache[key] = entity
+}
+
+func fetchAuthors(context *Context, ids []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+
+	authors, err := database.GetAuthorsByIds(ids, context.User())
+
+	if err != nil {
+		return nil, err
+	}
+
+	entities := map[primitive.ObjectID]interface{}{}
+	for _, author := range authors {
+		entities[author.ID] = author
+	}
+
+	return entities, nil
+
+}
+
+func fetchSources(context *Context, ids []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+
+	sources, err := database.GetSourcesByIds(ids, context.User())
+
+	if err != nil {
+		return nil, err
+	}
+
+	entities := map[primitive.ObjectID]interface{}{}
+	for _, source := range sources {
+		entities[source.ID] = source
+	}
+
+	return entities, nil
+
+}
+
+func fetchDefinitions(context *Context, ids []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+
+	definitions, err := database.GetDefinitionsByIds(ids, context.User())
+
+	if err != nil {
+		return nil, err
+	}
+
+	entities := map[primitive.ObjectID]interface{}{}
+	for _, definition := range definitions {
+		entities[definition.ID] = definition
+	}
+
+	return entities, nil
+
+}
+
+func fetchUsers(context *Context, ids []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+
+	users, err := database.GetPublicUsersByIds(ids)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entities := map[primitive.ObjectID]interface{}{}
+	for _, user := range users {
+		entities[user.ID] = user
+	}
+
+	return entities, nil
+
+}
+
+/*
+fetchSourceDefinitions loads the definitions drawn from each of the sources.
+*/
+func fetchSourceDefinitions(context *Context, sourceIds []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+
+	definitions, err := database.GetDefinitionsBySourceIds(sourceIds, context.User())
+
+	if err != nil {
+		return nil, err
+	}
+
+	definitionsBySource := map[primitive.ObjectID]interface{}{}
+	for _, sourceId := range sourceIds {
+		definitionsBySource[sourceId] = []interface{}{}
+	}
+
+	for _, definition := range definitions {
+		context.definitions.prime(definition.ID, definition)
+		definitionsBySource[definition.Source] = append(definitionsBySource[definition.Source].([]interface{}), definition)
+	}
+
+	return definitionsBySource, nil
+
+}
+
+/*
+fetchAuthorSources loads the sources listing each of the authors.
+*/
+func fetchAuthorSources(context *Context, authorIds []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+
+	sources, err := database.GetSourcesByAuthorIds(authorIds, context.User())
+
+	if err != nil {
+		return nil, err
+	}
+
+	sourcesByAuthor := map[primitive.ObjectID]interface{}{}
+	for _, authorId := range authorIds {
+		sourcesByAuthor[authorId] = []interface{}{}
+	}
+
+	for _, source := range sources {
+		context.sources.prime(source.ID, source)
+		for _, authorId := range source.Authors {
+			if authorSources, requested := sourcesByAuthor[authorId]; requested {
+				sourcesByAuthor[authorId] = append(authorSources.([]interface{}), source)
+			}
+		}
+	}
+
+	return sourcesByAuthor, nil
+
+}
+
+/*
+fetchTagDefinitions loads the definitions using each of the tags, newest approvals first.
+*/
+func fetchTagDefinitions(context *Context, names []string) (map[string]interface{}, error) {
+
+	definitions, err := database.GetDefinitionsByTags(names, context.User())
+
+	if err != nil {
+		return nil, err
+	}
+
+	definitionsByTag := map[string]interface{}{}
+	for _, name := range names {
+		definitionsByTag[name] = []interface{}{}
+	}
+
+	for _, definition := range definitions {
+
+		context.definitions.prime(definition.ID, definition)
+
+		if definition.Tags == nil {
+			continue
+		}
+
+		for _, tag := range *definition.Tags {
+			if tagDefinitions, requested := definitionsByTag[tag]; requested {
+				definitionsByTag[tag] = append(tagDefinitions.([]interface{}), definition)
+			}
+		}
+	}
+
+	return definitionsByTag, nil
+
+}
+
+/*
+getTagCounts loads the number of approved definitions per tag once per request.
+*/
+func (context *Context) getTagCounts() (map[string]int, error) {
+
+	if context.tagCounts != nil {
+		return context.tagCounts, nil
+	}
+
+	tags, err := database.GetTags()
+
+	if err != nil {
+		return nil, err
+	}
+
+	context.tagCounts = map[string]int{}
+	for _, tag := range tags {
+		context.tagCounts[tag.Name] = tag.Count
+	}
+
+	return context.tagCounts, nil
+
+}
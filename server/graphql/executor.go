@@ -0,0 +1,129 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	gql "github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+var ErrorMaxDepthExceeded = errors.New("MAX_DEPTH_EXCEEDED")
+
+const maxDepth = 12
+
+/*
+Execute parses, validates and executes a query. Only query operations are supported and selections
+may not be nested deeper than maxDepth, counting the fields of fragments where they are spread.
+*/
+func (schema *Schema) Execute(requestContext *Context, query string, operationName string, variables map[string]interface{}) *gql.Result {
+
+	document, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(query), Name: "GraphQL request"}),
+	})
+
+	if err != nil {
+		return &gql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+
+	validation := gql.ValidateDocument(&schema.schema, document, gql.SpecifiedRules)
+
+	if !validation.IsValid {
+		return &gql.Result{Errors: validation.Errors}
+	}
+
+	if depthError := checkDepth(document, operationName); depthError != nil {
+		return &gql.Result{Errors: gqlerrors.FormatErrors(depthError)}
+	}
+
+	return gql.Execute(gql.ExecuteParams{
+		Schema:        schema.schema,
+		AST:           document,
+		OperationName: operationName,
+		Args:          variables,
+		Context:       withContext(context.Background(), requestContext),
+	})
+
+}
+
+/*
+checkDepth reports ErrorMaxDepthExceeded if the selected operation nests fields deeper than maxDepth.
+Documents with ambiguous operations are left to the executor, which rejects them.
+*/
+func checkDepth(document *ast.Document, operationName string) error {
+
+	fragments := map[string]*ast.FragmentDefinition{}
+	var operation *ast.OperationDefinition
+	operations := 0
+
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			operations++
+			if operationName == "" || (definition.Name != nil && definition.Name.Value == operationName) {
+				operation = definition
+			}
+		}
+	}
+
+	if operation == nil || (operationName == "" && operations > 1) {
+		return nil
+	}
+
+	if selectionDepth(operation.SelectionSet, fragments, map[string]bool{}) > maxDepth {
+		return ErrorMaxDepthExceeded
+	}
+
+	return nil
+
+}
+
+/*
+selectionDepth returns the number of nested field levels of a selection set. Introspection fields are
+resolved from the schema without touching the database and do not count below their own level.
+Fragments already being expanded are skipped, cycles are rejected by the validation before.
+*/
+func selectionDepth(selectionSet *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, expanding map[string]bool) int {
+
+	if selectionSet == nil {
+		return 0
+	}
+
+	depth := 0
+
+	for _, selection := range selectionSet.Selections {
+
+		nested := 0
+
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name.Value, "__") {
+				nested = 1
+			} else {
+				nested = 1 + selectionDepth(selection.SelectionSet, fragments, expanding)
+			}
+		case *ast.InlineFragment:
+			nested = selectionDepth(selection.SelectionSet, fragments, expanding)
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			if fragment, exists := fragments[name]; exists && !expanding[name] {
+				expanding[name] = true
+				nested = selectionDepth(fragment.SelectionSet, fragments, expanding)
+				delete(expanding, name)
+			}
+		}
+
+		if nested > depth {
+			depth = nested
+		}
+	}
+
+	return depth
+
+}
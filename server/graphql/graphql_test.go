@@ -0,0 +1,358 @@
+package graphql
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"yacoid_server/database"
+	"yacoid_server/types"
+
+	"github.com/graphql-go/graphql/testutil"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+testStore replaces the database for the loaders. Like the database loaders it only returns entities
+that are approved or visible to the user of the request.
+*/
+type testStore struct {
+	definitions map[primitive.ObjectID]*database.Definition
+	sources     map[primitive.ObjectID]*types.Source
+	authors     map[primitive.ObjectID]*types.Author
+	fetches     map[string][]int
+}
+
+func isVisible(approved bool, submittedBy primitive.ObjectID, user *database.User) bool {
+	return approved || (user != nil && (user.Admin || submittedBy == user.ID))
+}
+
+func (store *testStore) newContext(user *database.User) *Context {
+
+	context := NewContext("")
+	context.user = user
+	context.userLoaded = true
+
+	context.definitions = newLoader(context, func(context *Context, ids []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+		store.fetches["definitions"] = append(store.fetches["definitions"], len(ids))
+		entities := map[primitive.ObjectID]interface{}{}
+		for _, id := range ids {
+			if definition, exists := store.definitions[id]; exists && isVisible(definition.Approved, definition.SubmittedBy, context.User()) {
+				entities[id] = definition
+			}
+		}
+		return entities, nil
+	})
+
+	context.sources = newLoader(context, func(context *Context, ids []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+		store.fetches["sources"] = append(store.fetches["sources"], len(ids))
+		entities := map[primitive.ObjectID]interface{}{}
+		for _, id := range ids {
+			if source, exists := store.sources[id]; exists && isVisible(source.Approved, source.SubmittedBy, context.User()) {
+				entities[id] = source
+			}
+		}
+		return entities, nil
+	})
+
+	context.authors = newLoader(context, func(context *Context, ids []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+		store.fetches["authors"] = append(store.fetches["authors"], len(ids))
+		entities := map[primitive.ObjectID]interface{}{}
+		for _, id := range ids {
+			if author, exists := store.authors[id]; exists && isVisible(author.Approved, author.SubmittedBy, context.User()) {
+				entities[id] = author
+			}
+		}
+		return entities, nil
+	})
+
+	context.sourceDefinitions = newLoader(context, func(context *Context, ids []primitive.ObjectID) (map[primitive.ObjectID]interface{}, error) {
+		store.fetches["sourceDefinitions"] = append(store.fetches["sourceDefinitions"], len(ids))
+		entities := map[primitive.ObjectID]interface{}{}
+		for _, id := range ids {
+			definitions := []interface{}{}
+			for _, definition := range store.definitions {
+				if definition.Source == id && isVisible(definition.Approved, definition.SubmittedBy, context.User()) {
+					definitions = append(definitions, definition)
+				}
+			}
+			entities[id] = definitions
+		}
+		return entities, nil
+	})
+
+	return context
+
+}
+
+/*
+newTestStore creates an approved definition drawn from a pending source of the submitter,
+which lists an approved and a pending author.
+*/
+func newTestStore() (*testStore, *database.User, map[string]primitive.ObjectID) {
+
+	submitter := &database.User{ID: primitive.NewObjectID()}
+
+	ids := map[string]primitive.ObjectID{
+		"definition":      primitive.NewObjectID(),
+		"otherDefinition": primitive.NewObjectID(),
+		"source":          primitive.NewObjectID(),
+		"approvedSource":  primitive.NewObjectID(),
+		"approvedAuthor":  primitive.NewObjectID(),
+		"pendingAuthor":   primitive.NewObjectID(),
+	}
+
+	store := &testStore{
+		definitions: map[primitive.ObjectID]*database.Definition{
+			ids["definition"]:      {ID: ids["definition"], Title: "Pending source", Approved: true, Source: ids["source"]},
+			ids["otherDefinition"]: {ID: ids["otherDefinition"], Title: "Approved source", Approved: true, Source: ids["approvedSource"]},
+		},
+		sources: map[primitive.ObjectID]*types.Source{
+			ids["source"]:         {ID: ids["source"], Title: "Pending", SubmittedBy: submitter.ID, Authors: []primitive.ObjectID{ids["approvedAuthor"], ids["pendingAuthor"]}},
+			ids["approvedSource"]: {ID: ids["approvedSource"], Title: "Approved", Approved: true, Authors: []primitive.ObjectID{ids["pendingAuthor"]}},
+		},
+		authors: map[primitive.ObjectID]*types.Author{
+			ids["approvedAuthor"]: {ID: ids["approvedAuthor"], LastName: "Approved", Approved: true},
+			ids["pendingAuthor"]:  {ID: ids["pendingAuthor"], LastName: "Pending", SubmittedBy: submitter.ID},
+		},
+		fetches: map[string][]int{},
+	}
+
+	return store, submitter, ids
+
+}
+
+func executeToJSON(t *testing.T, context *Context, query string) (string, []string) {
+
+	result := NewSchema().Execute(context, query, "", nil)
+
+	data, err := json.Marshal(result.Data)
+
+	if err != nil {
+		t.Fatalf("could not marshal result: %v", err)
+	}
+
+	messages := []string{}
+	for _, resultError := range result.Errors {
+		messages = append(messages, resultError.Message)
+	}
+
+	return string(data), messages
+
+}
+
+func TestPendingEntitiesVisibility(t *testing.T) {
+
+	store, submitter, ids := newTestStore()
+
+	query := `{
+		definition(id: "` + ids["definition"].Hex() + `") { title source { title authors { lastName } } }
+		other: definition(id: "` + ids["otherDefinition"].Hex() + `") { source { title authors { lastName } } }
+	}`
+
+	tests := []struct {
+		name     string
+		user     *database.User
+		expected string
+	}{
+		{
+			"anonymous",
+			nil,
+			`{"definition":{"source":null,"title":"Pending source"},"other":{"source":{"authors":[],"title":"Approved"}}}`,
+		},
+		{
+			"other user",
+			&database.User{ID: primitive.NewObjectID()},
+			`{"definition":{"source":null,"title":"Pending source"},"other":{"source":{"authors":[],"title":"Approved"}}}`,
+		},
+		{
+			"submitter",
+			submitter,
+			`{"definition":{"source":{"authors":[{"lastName":"Approved"},{"lastName":"Pending"}],"title":"Pending"},"title":"Pending source"},"other":{"source":{"authors":[{"lastName":"Pending"}],"title":"Approved"}}}`,
+		},
+		{
+			"admin",
+			&database.User{ID: primitive.NewObjectID(), Admin: true},
+			`{"definition":{"source":{"authors":[{"lastName":"Approved"},{"lastName":"Pending"}],"title":"Pending"},"title":"Pending source"},"other":{"source":{"authors":[{"lastName":"Pending"}],"title":"Approved"}}}`,
+		},
+	}
+
+	for _, test := range tests {
+
+		data, errors := executeToJSON(t, store.newContext(test.user), query)
+
+		if data != test.expected || len(errors) > 0 {
+			t.Errorf("%s: got %s, %v, expected %s", test.name, data, errors, test.expected)
+		}
+	}
+
+}
+
+func TestLoadersBatchEachLevel(t *testing.T) {
+
+	store, submitter, ids := newTestStore()
+
+	query := `{
+		a: definition(id: "` + ids["definition"].Hex() + `") { source { authors { id } definitions { id } } }
+		b: definition(id: "` + ids["otherDefinition"].Hex() + `") { source { authors { id } definitions { id } } }
+	}`
+
+	_, errors := executeToJSON(t, store.newContext(submitter), query)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	expected := map[string][]int{"definitions": {2}, "sources": {2}, "authors": {2}, "sourceDefinitions": {2}}
+
+	for loader, fetches := range expected {
+		if len(store.fetches[loader]) != 1 || store.fetches[loader][0] != fetches[0] {
+			t.Errorf("%s fetches = %v, expected %v", loader, store.fetches[loader], fetches)
+		}
+	}
+
+}
+
+/*
+nestedQuery selects definition.source.definitions.source... until the query has the given depth.
+*/
+func nestedQuery(id primitive.ObjectID, depth int) string {
+
+	selection, closing := "", ""
+	for level := 2; level < depth; level++ {
+		if level%2 == 0 {
+			selection += "source { "
+		} else {
+			selection += "definitions { "
+		}
+		closing += " }"
+	}
+
+	return `{ definition(id: "` + id.Hex() + `") { ` + selection + "id" + closing + ` } }`
+
+}
+
+func TestMaxDepth(t *testing.T) {
+
+	store, _, ids := newTestStore()
+
+	fragmentQuery := `{ definition(id: "` + ids["otherDefinition"].Hex() + `") { ...Deep } }
+		fragment Deep on Definition { source { definitions { source { definitions { source { definitions { source { definitions { source { definitions { source { id } } } } } } } } } } } }`
+
+	tests := []struct {
+		name     string
+		query    string
+		exceeded bool
+	}{
+		{"at the limit", nestedQuery(ids["otherDefinition"], maxDepth), false},
+		{"above the limit", nestedQuery(ids["otherDefinition"], maxDepth+1), true},
+		{"through fragments", fragmentQuery, true},
+		{"introspection", testutil.IntrospectionQuery, false},
+	}
+
+	for _, test := range tests {
+
+		_, errors := executeToJSON(t, store.newContext(nil), test.query)
+
+		exceeded := len(errors) == 1 && errors[0] == ErrorMaxDepthExceeded.Error()
+
+		if exceeded != test.exceeded || (!test.exceeded && len(errors) > 0) {
+			t.Errorf("%s: errors = %v, expected exceeded = %v", test.name, errors, test.exceeded)
+		}
+	}
+
+}
+
+func TestInvalidDocuments(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		query   string
+		message string
+	}{
+		{"fragment cycle", `{ tags { ...A } } fragment A on Tag { definitions { tags { ...A } } }`, `Cannot spread fragment "A" within itself.`},
+		{"unknown field", `{ definitions { password } }`, `Cannot query field "password" on type "Definition".`},
+		{"missing argument", `{ definition { id } }`, `Field "definition" argument "id" of type "ID!" is required but not provided.`},
+		{"mutation", `mutation { definition(id: "1") { id } }`, "Schema is not configured for mutations"},
+		{"syntax error", `{ definition(id: "1") { id }`, "Syntax Error"},
+	}
+
+	for _, test := range tests {
+
+		_, errors := executeToJSON(t, NewContext(""), test.query)
+
+		if len(errors) == 0 || !strings.Contains(errors[0], test.message) {
+			t.Errorf("%s: errors = %v, expected %q", test.name, errors, test.message)
+		}
+	}
+
+}
+
+func TestIntrospection(t *testing.T) {
+
+	result := NewSchema().Execute(NewContext(""), testutil.IntrospectionQuery, "", nil)
+
+	if len(result.Errors) > 0 {
+		t.Fatalf("introspection failed: %v", result.Errors)
+	}
+
+	data, _ := json.Marshal(result.Data)
+
+	for _, typeName := range []string{"Query", "Definition", "Source", "Author", "Tag", "User"} {
+		if !strings.Contains(string(data), `"name":"`+typeName+`"`) {
+			t.Errorf("introspection is missing the type %s", typeName)
+		}
+	}
+
+	typeQuery := `{ __type(name: "Source") { fields { name } } }`
+	expected := `{"__type":{"fields":[{"name":"id"},{"name":"title"},{"name":"year"},{"name":"isbn"},{"name":"doi"},{"name":"approved"},{"name":"authors"},{"name":"definitions"}]}}`
+
+	typeResult := NewSchema().Execute(NewContext(""), typeQuery, "", nil)
+	typeData, _ := json.Marshal(typeResult.Data)
+
+	if len(typeResult.Errors) > 0 || !sameFieldNames(string(typeData), expected) {
+		t.Errorf("__type(Source) = %s, %v, expected the fields of %s", typeData, typeResult.Errors, expected)
+	}
+
+}
+
+/*
+sameFieldNames compares two __type results independently of the order of the fields.
+*/
+func sameFieldNames(actual string, expected string) bool {
+
+	names := func(data string) map[string]bool {
+
+		var result struct {
+			Type struct {
+				Fields []struct {
+					Name string `json:"name"`
+				} `json:"fields"`
+			} `json:"__type"`
+		}
+
+		json.Unmarshal([]byte(data), &result)
+
+		names := map[string]bool{}
+		for _, field := range result.Type.Fields {
+			names[field.Name] = true
+		}
+
+		return names
+
+	}
+
+	actualNames, expectedNames := names(actual), names(expected)
+
+	if len(actualNames) != len(expectedNames) {
+		return false
+	}
+
+	for name := range expectedNames {
+		if !actualNames[name] {
+			return false
+		}
+	}
+
+	return true
+
+}
@@ -0,0 +1,570 @@
+package graphql
+
+import (
+	"errors"
+	"yacoid_server/database"
+	"yacoid_server/types"
+
+	gql "github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrorInvalidArgument = errors.New("INVALID_ARGUMENT")
+
+const maxPageSize = 100
+
+/*
+Tag is a tag of definitions. Tags are no documents of their own, they only exist on definitions.
+*/
+type Tag struct {
+	Name string `json:"name"`
+}
+
+/*
+Schema is the executable YACOID graph.
+*/
+type Schema struct {
+	schema gql.Schema
+}
+
+/*
+NewSchema creates the schema of the YACOID graph:
+
+	type Query {
+		definition(id: ID!): Definition
+		definitions(page: Int = 1, pageSize: Int = 10, search: String, tags: [String]): [Definition]
+		newestDefinitions(limit: Int = 4): [Definition]
+		author(id: ID, slug: String): Author
+		authors(page: Int = 1, pageSize: Int = 10, search: String): [Author]
+		source(id: ID!): Source
+		sources(page: Int = 1, pageSize: Int = 10, search: String, year: Int): [Source]
+		tag(name: String!): Tag
+		tags: [Tag]
+	}
+
+Fields referencing other entities return thunks of the request loaders, so that each level of a query
+loads its references with one query per entity type. Pending entities are only visible to their submitter and admins.
+*/
+func NewSchema() *Schema {
+
+	var definitionType, sourceType, authorType, tagType *gql.Object
+
+	userType := gql.NewObject(gql.ObjectConfig{Name: "User", Fields: gql.Fields{
+		"id":        {Type: gql.ID, Resolve: resolveID},
+		"firstName": {Type: gql.String},
+		"lastName":  {Type: gql.String},
+	}})
+
+	definitionType = gql.NewObject(gql.ObjectConfig{Name: "Definition", Fields: gql.FieldsThunk(func() gql.Fields {
+		return gql.Fields{
+			"id":             {Type: gql.ID, Resolve: resolveID},
+			"title":          {Type: gql.String},
+			"content":        {Type: gql.String},
+			"publishingDate": {Type: gql.DateTime},
+			"submittedDate":  {Type: gql.DateTime},
+			"approved":       {Type: gql.Boolean},
+			"approvedDate":   {Type: gql.DateTime},
+			"tags":           {Type: gql.NewList(tagType), Resolve: resolveDefinitionTags},
+			"source":         {Type: sourceType, Resolve: resolveDefinitionSource},
+			"submittedBy":    {Type: userType, Resolve: resolveDefinitionUser(func(definition *database.Definition) *primitive.ObjectID { return &definition.SubmittedBy })},
+			"approvedBy":     {Type: userType, Resolve: resolveDefinitionUser(func(definition *database.Definition) *primitive.ObjectID { return definition.ApprovedBy })},
+		}
+	})})
+
+	sourceType = gql.NewObject(gql.ObjectConfig{Name: "Source", Fields: gql.FieldsThunk(func() gql.Fields {
+		return gql.Fields{
+			"id":          {Type: gql.ID, Resolve: resolveID},
+			"title":       {Type: gql.String},
+			"year":        {Type: gql.Int},
+			"isbn":        {Type: gql.String},
+			"doi":         {Type: gql.String},
+			"approved":    {Type: gql.Boolean},
+			"authors":     {Type: gql.NewList(authorType), Resolve: resolveSourceAuthors},
+			"definitions": {Type: gql.NewList(definitionType), Resolve: resolveSourceDefinitions},
+		}
+	})})
+
+	authorType = gql.NewObject(gql.ObjectConfig{Name: "Author", Fields: gql.FieldsThunk(func() gql.Fields {
+		return gql.Fields{
+			"id":           {Type: gql.ID, Resolve: resolveID},
+			"slugId":       {Type: gql.String},
+			"firstName":    {Type: gql.String},
+			"lastName":     {Type: gql.String},
+			"orcid":        {Type: gql.String},
+			"wikidataId":   {Type: gql.String},
+			"nameVariants": {Type: gql.NewList(gql.String)},
+			"birthYear":    {Type: gql.Int},
+			"deathYear":    {Type: gql.Int},
+			"approved":     {Type: gql.Boolean},
+			"sources":      {Type: gql.NewList(sourceType), Resolve: resolveAuthorSources},
+		}
+	})})
+
+	tagType = gql.NewObject(gql.ObjectConfig{Name: "Tag", Fields: gql.FieldsThunk(func() gql.Fields {
+		return gql.Fields{
+			"name":            {Type: gql.String},
+			"definitionCount": {Type: gql.Int, Resolve: resolveTagDefinitionCount},
+			"definitions": {
+				Type:    gql.NewList(definitionType),
+				Args:    gql.FieldConfigArgument{"limit": {Type: gql.Int, DefaultValue: 10}},
+				Resolve: resolveTagDefinitions,
+			},
+		}
+	})})
+
+	pageArgs := func(extra gql.FieldConfigArgument) gql.FieldConfigArgument {
+
+		args := gql.FieldConfigArgument{
+			"page":     {Type: gql.Int, DefaultValue: 1},
+			"pageSize": {Type: gql.Int, DefaultValue: 10},
+			"search":   {Type: gql.String},
+		}
+
+		for name, arg := range extra {
+			args[name] = arg
+		}
+
+		return args
+
+	}
+
+	queryType := gql.NewObject(gql.ObjectConfig{Name: "Query", Fields: gql.Fields{
+		"definition": {
+			Type:    definitionType,
+			Args:    gql.FieldConfigArgument{"id": {Type: gql.NewNonNull(gql.ID)}},
+			Resolve: resolveDefinition,
+		},
+		"definitions": {
+			Type:    gql.NewList(definitionType),
+			Args:    pageArgs(gql.FieldConfigArgument{"tags": {Type: gql.NewList(gql.String)}}),
+			Resolve: resolveDefinitions,
+		},
+		"newestDefinitions": {
+			Type:    gql.NewList(definitionType),
+			Args:    gql.FieldConfigArgument{"limit": {Type: gql.Int, DefaultValue: 4}},
+			Resolve: resolveNewestDefinitions,
+		},
+		"author": {
+			Type:    authorType,
+			Args:    gql.FieldConfigArgument{"id": {Type: gql.ID}, "slug": {Type: gql.String}},
+			Resolve: resolveAuthor,
+		},
+		"authors": {
+			Type:    gql.NewList(authorType),
+			Args:    pageArgs(nil),
+			Resolve: resolveAuthors,
+		},
+		"source": {
+			Type:    sourceType,
+			Args:    gql.FieldConfigArgument{"id": {Type: gql.NewNonNull(gql.ID)}},
+			Resolve: resolveSource,
+		},
+		"sources": {
+			Type:    gql.NewList(sourceType),
+			Args:    pageArgs(gql.FieldConfigArgument{"year": {Type: gql.Int}}),
+			Resolve: resolveSources,
+		},
+		"tag": {
+			Type:    tagType,
+			Args:    gql.FieldConfigArgument{"name": {Type: gql.NewNonNull(gql.String)}},
+			Resolve: resolveTag,
+		},
+		"tags": {
+			Type:    gql.NewList(tagType),
+			Resolve: resolveTags,
+		},
+	}})
+
+	schema, err := gql.NewSchema(gql.SchemaConfig{Query: queryType})
+
+	if err != nil {
+		panic(err)
+	}
+
+	return &Schema{schema: schema}
+
+}
+
+/* argument helpers */
+
+func idArgument(params gql.ResolveParams, name string) (*primitive.ObjectID, error) {
+
+	value, isString := params.Args[name].(string)
+
+	if !isString {
+		return nil, nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(value)
+
+	if err != nil {
+		return nil, database.InvalidID
+	}
+
+	return &id, nil
+
+}
+
+func stringArgument(params gql.ResolveParams, name string) *string {
+
+	if value, isString := params.Args[name].(string); isString {
+		return &value
+	}
+
+	return nil
+
+}
+
+func limitArgument(params gql.ResolveParams) (int, error) {
+
+	limit, _ := params.Args["limit"].(int)
+
+	if limit < 1 || limit > maxPageSize {
+		return 0, ErrorInvalidArgument
+	}
+
+	return limit, nil
+
+}
+
+func pageArguments(params gql.ResolveParams) (int, int, error) {
+
+	page, _ := params.Args["page"].(int)
+	pageSize, _ := params.Args["pageSize"].(int)
+
+	if page < 1 || pageSize < 1 || pageSize > maxPageSize {
+		return 0, 0, ErrorInvalidArgument
+	}
+
+	return page, pageSize, nil
+
+}
+
+func list[T any](items []T) []interface{} {
+
+	values := []interface{}{}
+	for _, item := range items {
+		values = append(values, item)
+	}
+
+	return values
+
+}
+
+/* property resolvers */
+
+/*
+resolveID returns the hex representation of the ID of any entity.
+*/
+func resolveID(params gql.ResolveParams) (interface{}, error) {
+
+	switch source := params.Source.(type) {
+	case *database.Definition:
+		return source.ID.Hex(), nil
+	case *types.Source:
+		return source.ID.Hex(), nil
+	case *types.Author:
+		return source.ID.Hex(), nil
+	case *types.PublicUser:
+		return source.ID.Hex(), nil
+	}
+
+	return nil, nil
+
+}
+
+/* query resolvers */
+
+func resolveDefinition(params gql.ResolveParams) (interface{}, error) {
+
+	id, err := idArgument(params, "id")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return getContext(params).definitions.load(*id), nil
+
+}
+
+func resolveDefinitions(params gql.ResolveParams) (interface{}, error) {
+
+	context := getContext(params)
+
+	page, pageSize, pageError := pageArguments(params)
+
+	if pageError != nil {
+		return nil, pageError
+	}
+
+	filter := types.DefinitionFilter{Title: stringArgument(params, "search")}
+
+	if tagValues, exists := params.Args["tags"].([]interface{}); exists {
+
+		tags := []string{}
+		for _, tagValue := range tagValues {
+			if tag, isString := tagValue.(string); isString {
+				tags = append(tags, tag)
+			}
+		}
+
+		filter.Tags = &tags
+	}
+
+	definitions, err := database.FindDefinitions(pageSize, page, &filter, context.User())
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, definition := range definitions {
+		context.definitions.prime(definition.ID, definition)
+	}
+
+	return list(definitions), nil
+
+}
+
+func resolveNewestDefinitions(params gql.ResolveParams) (interface{}, error) {
+
+	context := getContext(params)
+
+	limit, limitError := limitArgument(params)
+
+	if limitError != nil {
+		return nil, limitError
+	}
+
+	expandedDefinitions, err := database.GetNewestDefinitions(limit, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := []*database.Definition{}
+	for _, expandedDefinition := range expandedDefinitions {
+		definition := expandedDefinition.Definition
+		context.definitions.prime(definition.ID, &definition)
+		definitions = append(definitions, &definition)
+	}
+
+	return list(definitions), nil
+
+}
+
+func resolveAuthor(params gql.ResolveParams) (interface{}, error) {
+
+	context := getContext(params)
+
+	idOrSlug := stringArgument(params, "id")
+
+	if idOrSlug == nil {
+		idOrSlug = stringArgument(params, "slug")
+	}
+
+	if idOrSlug == nil {
+		return nil, ErrorInvalidArgument
+	}
+
+	author, err := database.GetAuthorByIdOrSlug(*idOrSlug, context.AuthToken)
+
+	if err != nil {
+		return nil, nil
+	}
+
+	context.authors.prime(author.ID, author)
+	return author, nil
+
+}
+
+func resolveAuthors(params gql.ResolveParams) (interface{}, error) {
+
+	context := getContext(params)
+
+	page, pageSize, pageError := pageArguments(params)
+
+	if pageError != nil {
+		return nil, pageError
+	}
+
+	authors, _, err := database.GetAuthors(pageSize, page, stringArgument(params, "search"), context.AuthToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, author := range authors {
+		context.authors.prime(author.ID, author)
+	}
+
+	return list(authors), nil
+
+}
+
+func resolveSource(params gql.ResolveParams) (interface{}, error) {
+
+	id, err := idArgument(params, "id")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return getContext(params).sources.load(*id), nil
+
+}
+
+func resolveSources(params gql.ResolveParams) (interface{}, error) {
+
+	context := getContext(params)
+
+	page, pageSize, pageError := pageArguments(params)
+
+	if pageError != nil {
+		return nil, pageError
+	}
+
+	var year *int
+	if yearValue, exists := params.Args["year"].(int); exists {
+		year = &yearValue
+	}
+
+	sources, err := database.FindSources(pageSize, page, stringArgument(params, "search"), year, context.User())
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, source := range sources {
+		context.sources.prime(source.ID, source)
+	}
+
+	return list(sources), nil
+
+}
+
+func resolveTag(params gql.ResolveParams) (interface{}, error) {
+
+	name := stringArgument(params, "name")
+
+	tagCounts, err := getContext(params).getTagCounts()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, exists := tagCounts[*name]; !exists {
+		return nil, nil
+	}
+
+	return &Tag{Name: *name}, nil
+
+}
+
+func resolveTags(params gql.ResolveParams) (interface{}, error) {
+
+	context := getContext(params)
+
+	tags, err := database.GetTags()
+
+	if err != nil {
+		return nil, err
+	}
+
+	context.tagCounts = map[string]int{}
+	result := []*Tag{}
+
+	for _, tag := range tags {
+		context.tagCounts[tag.Name] = tag.Count
+		result = append(result, &Tag{Name: tag.Name})
+	}
+
+	return list(result), nil
+
+}
+
+/* relation resolvers */
+
+func resolveDefinitionTags(params gql.ResolveParams) (interface{}, error) {
+
+	tags := []*Tag{}
+	if definitionTags := params.Source.(*database.Definition).Tags; definitionTags != nil {
+		for _, name := range *definitionTags {
+			tags = append(tags, &Tag{Name: name})
+		}
+	}
+
+	return list(tags), nil
+
+}
+
+func resolveDefinitionSource(params gql.ResolveParams) (interface{}, error) {
+	return getContext(params).sources.load(params.Source.(*database.Definition).Source), nil
+}
+
+func resolveDefinitionUser(getter func(definition *database.Definition) *primitive.ObjectID) gql.FieldResolveFn {
+
+	return func(params gql.ResolveParams) (interface{}, error) {
+
+		id := getter(params.Source.(*database.Definition))
+
+		if id == nil {
+			return nil, nil
+		}
+
+		return getContext(params).users.load(*id), nil
+
+	}
+
+}
+
+func resolveSourceAuthors(params gql.ResolveParams) (interface{}, error) {
+	return getContext(params).authors.loadMany(params.Source.(*types.Source).Authors), nil
+}
+
+func resolveSourceDefinitions(params gql.ResolveParams) (interface{}, error) {
+	return getContext(params).sourceDefinitions.load(params.Source.(*types.Source).ID), nil
+}
+
+func resolveAuthorSources(params gql.ResolveParams) (interface{}, error) {
+	return getContext(params).authorSources.load(params.Source.(*types.Author).ID), nil
+}
+
+func resolveTagDefinitionCount(params gql.ResolveParams) (interface{}, error) {
+
+	tagCounts, err := getContext(params).getTagCounts()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tagCounts[params.Source.(*Tag).Name], nil
+
+}
+
+func resolveTagDefinitions(params gql.ResolveParams) (interface{}, error) {
+
+	limit, limitError := limitArgument(params)
+
+	if limitError != nil {
+		return nil, limitError
+	}
+
+	definitions := getContext(params).tagDefinitions.load(params.Source.(*Tag).Name)
+
+	return func() (interface{}, error) {
+
+		tagDefinitions, err := definitions()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if values := tagDefinitions.([]interface{}); len(values) > limit {
+			return values[:limit], nil
+		}
+
+		return tagDefinitions, nil
+
+	}, nil
+
+}
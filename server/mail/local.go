@@ -0,0 +1,101 @@
+package mail
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+LogMailer writes every message to the writer instead of sending it, which is handy during development.
+*/
+type LogMailer struct {
+	From   string
+	Writer io.Writer
+	mutex  sync.Mutex
+}
+
+func (mailer *LogMailer) Send(message *Message) error {
+
+	content, err := message.Render(mailer.From)
+
+	if err != nil {
+		return err
+	}
+
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	_, err = fmt.Fprintf(mailer.Writer, "----- mail to %s -----\n%s\n----- end of mail -----\n", message.To, content)
+	return err
+
+}
+
+/*
+FileMailer stores every message as an .eml file in the directory, which can be opened with any mail client.
+*/
+type FileMailer struct {
+	From      string
+	Directory string
+}
+
+func (mailer *FileMailer) Send(message *Message) error {
+
+	content, err := message.Render(mailer.From)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(mailer.Directory, 0755); err != nil {
+		return err
+	}
+
+	name := time.Now().UTC().Format("20060102T150405.000") + "-" + uuid.NewString() + ".eml"
+	return os.WriteFile(filepath.Join(mailer.Directory, name), content, 0644)
+
+}
+
+/*
+MemoryMailer keeps the sent messages in memory, so that tests can inspect them. It cannot be selected
+via MAIL_DRIVER, since nothing could read the messages of a running server.
+*/
+type MemoryMailer struct {
+	messages []*Message
+	mutex    sync.Mutex
+}
+
+func (mailer *MemoryMailer) Send(message *Message) error {
+
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	copied := *message
+	mailer.messages = append(mailer.messages, &copied)
+
+	return nil
+
+}
+
+func (mailer *MemoryMailer) Messages() []*Message {
+
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	return append([]*Message{}, mailer.messages...)
+
+}
+
+func (mailer *MemoryMailer) Reset() {
+
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	mailer.messages = nil
+
+}
@@ -0,0 +1,120 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"yacoid_server/constants"
+
+	gomail "gopkg.in/gomail.v2"
+)
+
+var ErrorInvalidMailConfig = errors.New("INVALID_MAIL_CONFIG")
+
+const (
+	DriverSMTP = "smtp"
+	DriverLog  = "log"
+	DriverFile = "file"
+)
+
+/*
+Message is an email to a single recipient. The text body is optional, if it is set the message is sent
+as multipart/alternative with both bodies.
+*/
+type Message struct {
+	To       string
+	Subject  string
+	HTMLBody string
+	TextBody string
+}
+
+/*
+Mailer delivers messages. Send returns once the message was handed over, failed messages are retried by the outbox.
+*/
+type Mailer interface {
+	Send(message *Message) error
+}
+
+/*
+Render serializes the message as MIME, as it would be transmitted via SMTP.
+*/
+func (message *Message) Render(from string) ([]byte, error) {
+
+	mail := gomail.NewMessage()
+	mail.SetHeader("From", from)
+	mail.SetHeader("To", message.To)
+	mail.SetHeader("Subject", message.Subject)
+
+	if len(message.TextBody) > 0 {
+		mail.SetBody("text/plain", message.TextBody)
+		mail.AddAlternative("text/html", message.HTMLBody)
+	} else {
+		mail.SetBody("text/html", message.HTMLBody)
+	}
+
+	var buffer bytes.Buffer
+
+	if _, err := mail.WriteTo(&buffer); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+
+}
+
+func getEnv(key string, defaultValue string) string {
+
+	if value := os.Getenv(key); len(value) > 0 {
+		return value
+	}
+
+	return defaultValue
+
+}
+
+/*
+NewMailerFromEnv creates the mailer selected by MAIL_DRIVER. Without configuration, mails are sent
+to an SMTP server on localhost:2525 without authentication, like a local mail catcher.
+*/
+func NewMailerFromEnv() (Mailer, error) {
+
+	from := getEnv(constants.EnvKeyMailFrom, "help@yacoid.de")
+
+	switch driver := getEnv(constants.EnvKeyMailDriver, DriverSMTP); driver {
+
+	case DriverSMTP:
+
+		port, err := strconv.Atoi(getEnv(constants.EnvKeySMTPPort, "2525"))
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not a number", ErrorInvalidMailConfig, constants.EnvKeySMTPPort)
+		}
+
+		security := getEnv(constants.EnvKeySMTPSecurity, SecurityNone)
+
+		if security != SecurityNone && security != SecuritySTARTTLS && security != SecurityTLS {
+			return nil, fmt.Errorf("%w: %s must be none, starttls or tls", ErrorInvalidMailConfig, constants.EnvKeySMTPSecurity)
+		}
+
+		return &SMTPMailer{
+			From:     from,
+			Host:     getEnv(constants.EnvKeySMTPHost, "localhost"),
+			Port:     port,
+			Username: os.Getenv(constants.EnvKeySMTPUsername),
+			Password: os.Getenv(constants.EnvKeySMTPPassword),
+			Security: security,
+		}, nil
+
+	case DriverLog:
+		return &LogMailer{From: from, Writer: os.Stdout}, nil
+
+	case DriverFile:
+		return &FileMailer{From: from, Directory: getEnv(constants.EnvKeyMailDirectory, "mails")}, nil
+
+	default:
+		return nil, fmt.Errorf("%w: unknown %s %q", ErrorInvalidMailConfig, constants.EnvKeyMailDriver, driver)
+	}
+
+}
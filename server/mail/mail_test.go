@@ -0,0 +1,109 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"yacoid_server/constants"
+)
+
+func TestRenderTemplates(t *testing.T) {
+
+	mailer := &MemoryMailer{}
+
+	for _, language := range Languages {
+		for _, name := range TemplateNames {
+
+			message, err := RenderTemplate("erika@example.com", name, language, TemplateSamples[name])
+
+			if err != nil {
+				t.Errorf("RenderTemplate(%q, %q) failed: %v", name, language, err)
+				continue
+			}
+
+			if len(message.Subject) == 0 || strings.Contains(message.Subject, "\n") {
+				t.Errorf("RenderTemplate(%q, %q) has the subject %q, expected a single line", name, language, message.Subject)
+			}
+
+			if !strings.Contains(message.HTMLBody, "Erika") || !strings.Contains(message.TextBody, "Erika") {
+				t.Errorf("RenderTemplate(%q, %q) does not greet the recipient", name, language)
+			}
+
+			if err := mailer.Send(message); err != nil {
+				t.Errorf("Send(%q, %q) failed: %v", name, language, err)
+			}
+		}
+	}
+
+	if sent := len(mailer.Messages()); sent != len(Languages)*len(TemplateNames) {
+		t.Errorf("MemoryMailer kept %d messages, expected %d", sent, len(Languages)*len(TemplateNames))
+	}
+
+	mailer.Reset()
+
+	if sent := len(mailer.Messages()); sent != 0 {
+		t.Errorf("MemoryMailer kept %d messages after Reset, expected none", sent)
+	}
+
+}
+
+func TestRenderTemplateErrors(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		language string
+		data     TemplateData
+		err      error
+	}{
+		{"unknown", "", nil, ErrorUnknownTemplate},
+		{TemplatePasswordReset, "fr", TemplateSamples[TemplatePasswordReset], ErrorUnsupportedLanguage},
+	}
+
+	for _, test := range tests {
+
+		_, err := RenderTemplate("erika@example.com", test.name, test.language, test.data)
+
+		if !errors.Is(err, test.err) {
+			t.Errorf("RenderTemplate(%q, %q) = %v, expected %v", test.name, test.language, err, test.err)
+		}
+	}
+
+	if _, err := RenderTemplate("erika@example.com", TemplatePasswordReset, "", TemplateData{}); err == nil {
+		t.Errorf("RenderTemplate(%q) without data succeeded, expected a missing key error", TemplatePasswordReset)
+	}
+
+}
+
+func TestNewMailerFromEnv(t *testing.T) {
+
+	tests := []struct {
+		driver   string
+		port     string
+		security string
+		expected string
+		err      error
+	}{
+		{"", "", "", "*mail.SMTPMailer", nil},
+		{DriverSMTP, "587", SecuritySTARTTLS, "*mail.SMTPMailer", nil},
+		{DriverSMTP, "smtp", "", "", ErrorInvalidMailConfig},
+		{DriverSMTP, "", "ssl", "", ErrorInvalidMailConfig},
+		{DriverLog, "", "", "*mail.LogMailer", nil},
+		{DriverFile, "", "", "*mail.FileMailer", nil},
+		{"memory", "", "", "", ErrorInvalidMailConfig},
+	}
+
+	for _, test := range tests {
+
+		t.Setenv(constants.EnvKeyMailDriver, test.driver)
+		t.Setenv(constants.EnvKeySMTPPort, test.port)
+		t.Setenv(constants.EnvKeySMTPSecurity, test.security)
+
+		mailer, err := NewMailerFromEnv()
+
+		if !errors.Is(err, test.err) || (err == nil && fmt.Sprintf("%T", mailer) != test.expected) {
+			t.Errorf("NewMailerFromEnv() with %q, %q, %q = %T, %v, expected %s, %v", test.driver, test.port, test.security, mailer, err, test.expected, test.err)
+		}
+	}
+
+}
@@ -0,0 +1,112 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+const (
+	SecurityNone     = "none"
+	SecuritySTARTTLS = "starttls"
+	SecurityTLS      = "tls"
+)
+
+const smtpTimeout = 30 * time.Second
+
+var ErrorSTARTTLSUnsupported = errors.New("SMTP_STARTTLS_UNSUPPORTED")
+
+/*
+SMTPMailer sends messages via SMTP. With SecurityTLS the connection is encrypted from the start (usually port 465),
+with SecuritySTARTTLS it is upgraded before authenticating and the server has to support it (usually port 587).
+*/
+type SMTPMailer struct {
+	From     string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Security string
+}
+
+func (mailer *SMTPMailer) Send(message *Message) error {
+
+	content, renderError := message.Render(mailer.From)
+
+	if renderError != nil {
+		return renderError
+	}
+
+	address := net.JoinHostPort(mailer.Host, strconv.Itoa(mailer.Port))
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	tlsConfig := &tls.Config{ServerName: mailer.Host}
+
+	var connection net.Conn
+	var err error
+
+	if mailer.Security == SecurityTLS {
+		connection, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		connection, err = dialer.Dial("tcp", address)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	connection.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(connection, mailer.Host)
+
+	if err != nil {
+		connection.Close()
+		return err
+	}
+
+	defer client.Close()
+
+	if mailer.Security == SecuritySTARTTLS {
+
+		if supported, _ := client.Extension("STARTTLS"); !supported {
+			return ErrorSTARTTLSUnsupported
+		}
+
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if len(mailer.Username) > 0 {
+		if err := client.Auth(smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(mailer.From); err != nil {
+		return err
+	}
+
+	if err := client.Rcpt(message.To); err != nil {
+		return err
+	}
+
+	writer, err := client.Data()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(content); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+
+}
@@ -0,0 +1,176 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"yacoid_server/common"
+)
+
+var ErrorUnknownTemplate = errors.New("UNKNOWN_MAIL_TEMPLATE")
+var ErrorUnsupportedLanguage = errors.New("UNSUPPORTED_LANGUAGE")
+
+const (
+	TemplatePasswordReset      = "password_reset"
+	TemplateEmailVerification  = "email_verification"
+	TemplateSubmissionReceipt  = "submission_receipt"
+	TemplateDefinitionApproved = "definition_approved"
+	TemplateDefinitionRejected = "definition_rejected"
+	TemplateNotificationDigest = "notification_digest"
+)
+
+var TemplateNames = []string{
+	TemplatePasswordReset,
+	TemplateEmailVerification,
+	TemplateSubmissionReceipt,
+	TemplateDefinitionApproved,
+	TemplateDefinitionRejected,
+	TemplateNotificationDigest,
+}
+
+/* Users without a language preference get German mails, like before the templates were localized. */
+const DefaultLanguage = "de"
+
+var Languages = []string{"de", "en"}
+
+/*
+TemplateData holds the values a template is rendered with. BaseURL and Language are always set by RenderTemplate.
+*/
+type TemplateData map[string]interface{}
+
+/*
+The templates of a language consist of the shared layout, the common blocks of the language (greeting and footer)
+and the template itself, which defines the blocks "subject" (text template only) and "content".
+*/
+//go:embed templates
+var templateFiles embed.FS
+
+type mailTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+var templates = loadTemplates()
+
+func loadTemplates() map[string]*mailTemplate {
+
+	loaded := map[string]*mailTemplate{}
+
+	for _, language := range Languages {
+		for _, name := range TemplateNames {
+
+			prefix := "templates/" + language + "/"
+
+			html := htmltemplate.Must(htmltemplate.New(name).Option("missingkey=error").ParseFS(templateFiles, "templates/layout.html", prefix+"common.html", prefix+name+".html"))
+			text := texttemplate.Must(texttemplate.New(name).Option("missingkey=error").ParseFS(templateFiles, "templates/layout.txt", prefix+"common.txt", prefix+name+".txt"))
+
+			loaded[language+"/"+name] = &mailTemplate{html: html, text: text}
+		}
+	}
+
+	return loaded
+
+}
+
+func IsSupportedLanguage(language string) bool {
+
+	for _, supported := range Languages {
+		if supported == language {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+/*
+RenderTemplate creates the message of a template in the given language. An empty language means the default language.
+*/
+func RenderTemplate(to string, name string, language string, data TemplateData) (*Message, error) {
+
+	if len(language) == 0 {
+		language = DefaultLanguage
+	}
+
+	if !IsSupportedLanguage(language) {
+		return nil, ErrorUnsupportedLanguage
+	}
+
+	template, found := templates[language+"/"+name]
+
+	if !found {
+		return nil, ErrorUnknownTemplate
+	}
+
+	values := TemplateData{}
+
+	for key, value := range data {
+		values[key] = value
+	}
+
+	values["BaseURL"] = common.GetPublicBaseUrl()
+	values["Language"] = language
+
+	var subject, text, html bytes.Buffer
+
+	if err := template.text.ExecuteTemplate(&subject, "subject", values); err != nil {
+		return nil, err
+	}
+
+	if err := template.text.ExecuteTemplate(&text, "layout", values); err != nil {
+		return nil, err
+	}
+
+	if err := template.html.ExecuteTemplate(&html, "layout", values); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		To:       to,
+		Subject:  strings.TrimSpace(subject.String()),
+		HTMLBody: html.String(),
+		TextBody: strings.TrimSpace(text.String()) + "\n",
+	}, nil
+
+}
+
+/*
+TemplateSamples are the values the templates are previewed with.
+*/
+var TemplateSamples = map[string]TemplateData{
+	TemplatePasswordReset: {
+		"FirstName": "Erika",
+		"Token":     "00000000-0000-0000-0000-000000000000",
+		"ValidDays": 7,
+	},
+	TemplateEmailVerification: {
+		"FirstName": "Erika",
+		"Token":     "00000000-0000-0000-0000-000000000000",
+		"ValidDays": 1,
+	},
+	TemplateSubmissionReceipt: {
+		"FirstName":       "Erika",
+		"DefinitionTitle": "Lorem ipsum",
+	},
+	TemplateDefinitionApproved: {
+		"FirstName":       "Erika",
+		"DefinitionID":    "000000000000000000000000",
+		"DefinitionTitle": "Lorem ipsum",
+	},
+	TemplateDefinitionRejected: {
+		"FirstName":       "Erika",
+		"DefinitionTitle": "Lorem ipsum",
+		"Rejection":       "Die Quelle fehlt.",
+	},
+	TemplateNotificationDigest: {
+		"FirstName": "Erika",
+		"Notifications": []TemplateData{
+			{"DefinitionID": "000000000000000000000000", "DefinitionTitle": "Lorem ipsum", "SavedSearchName": "Informatik"},
+			{"DefinitionID": "000000000000000000000001", "DefinitionTitle": "Dolor sit amet", "SavedSearchName": "Mathematik"},
+		},
+	},
+}
@@ -0,0 +1,327 @@
+package searchquery
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"yacoid_server/types"
+)
+
+var ErrorInvalidSearchQuery = errors.New("INVALID_SEARCH_QUERY")
+
+const (
+	FieldText   = "text"
+	FieldTag    = "tag"
+	FieldAuthor = "author"
+	FieldYear   = "year"
+)
+
+/*
+Clause is a single part of a search query like `-tag:"machine learning"`. Start and End are the
+character offsets of the clause in the query, so that the client can render it as a filter chip.
+*/
+type Clause struct {
+	Field    string `json:"field"`
+	Value    string `json:"value"`
+	Phrase   bool   `json:"phrase"`
+	Negated  bool   `json:"negated"`
+	YearFrom *int   `json:"yearFrom,omitempty"`
+	YearTo   *int   `json:"yearTo,omitempty"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+type Query struct {
+	Clauses []*Clause `json:"clauses"`
+}
+
+/*
+SyntaxError is a problem at the given character offset of the query.
+*/
+type SyntaxError struct {
+	Message  string `json:"message"`
+	Position int    `json:"position"`
+}
+
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", err.Message, err.Position)
+}
+
+type parser struct {
+	chars    []rune
+	position int
+	errors   []*SyntaxError
+}
+
+/*
+Parse parses a query like `tag:"machine learning" author:winston year:1985..1995 -tag:philosophy "perceive, reason"`.
+
+Words and "quoted phrases" without a field search the title and content. The fields are tag, author
+(matching any of the author's names) and year (the publishing year, either 1990, 1985..1995, 1985.. or ..1995).
+A leading minus negates a clause, except for years. Parsing continues after errors, so that all of them are reported.
+*/
+func Parse(input string) (*Query, []*SyntaxError) {
+
+	parser := parser{chars: []rune(input)}
+	query := Query{Clauses: []*Clause{}}
+
+	for {
+
+		parser.skipSpaces()
+
+		if parser.position >= len(parser.chars) {
+			break
+		}
+
+		if clause := parser.parseClause(); clause != nil {
+			query.Clauses = append(query.Clauses, clause)
+		}
+	}
+
+	return &query, parser.errors
+
+}
+
+func (parser *parser) addError(position int, format string, arguments ...interface{}) {
+	parser.errors = append(parser.errors, &SyntaxError{Message: fmt.Sprintf(format, arguments...), Position: position})
+}
+
+func (parser *parser) peek() rune {
+
+	if parser.position >= len(parser.chars) {
+		return 0
+	}
+
+	return parser.chars[parser.position]
+
+}
+
+func (parser *parser) atValueEnd() bool {
+	return parser.position >= len(parser.chars) || unicode.IsSpace(parser.chars[parser.position])
+}
+
+func (parser *parser) skipSpaces() {
+	for parser.position < len(parser.chars) && unicode.IsSpace(parser.chars[parser.position]) {
+		parser.position++
+	}
+}
+
+func (parser *parser) parseClause() *Clause {
+
+	clause := Clause{Field: FieldText, Start: parser.position}
+
+	if parser.peek() == '-' {
+
+		clause.Negated = true
+		parser.position++
+
+		if parser.atValueEnd() {
+			parser.addError(clause.Start, "expected a term after '-'")
+			return nil
+		}
+	}
+
+	/* a field name is a word directly followed by a colon */
+	fieldStart := parser.position
+	for parser.position < len(parser.chars) && unicode.IsLetter(parser.chars[parser.position]) {
+		parser.position++
+	}
+
+	if parser.position > fieldStart && parser.peek() == ':' {
+
+		clause.Field = strings.ToLower(string(parser.chars[fieldStart:parser.position]))
+		parser.position++
+
+		if clause.Field != FieldTag && clause.Field != FieldAuthor && clause.Field != FieldYear {
+			parser.addError(fieldStart, "unknown field '%s', expected tag, author or year", clause.Field)
+		}
+
+		if parser.atValueEnd() {
+			parser.addError(parser.position, "expected a value after '%s:'", clause.Field)
+			return nil
+		}
+	} else {
+		parser.position = fieldStart
+	}
+
+	valueStart := parser.position
+	value, phrase, ok := parser.parseValue()
+	clause.End = parser.position
+
+	if !ok {
+		return nil
+	}
+
+	clause.Value = value
+	clause.Phrase = phrase
+
+	if clause.Field == FieldYear {
+
+		if clause.Negated {
+			parser.addError(clause.Start, "years cannot be negated")
+			return nil
+		}
+
+		if !parser.parseYears(&clause, valueStart) {
+			return nil
+		}
+	}
+
+	if clause.Field != FieldTag && clause.Field != FieldAuthor && clause.Field != FieldYear && clause.Field != FieldText {
+		return nil
+	}
+
+	return &clause
+
+}
+
+/*
+parseValue reads a "quoted phrase" or a word up to the next space or quote.
+*/
+func (parser *parser) parseValue() (string, bool, bool) {
+
+	if parser.peek() == '"' {
+
+		quote := parser.position
+		parser.position++
+
+		start := parser.position
+		for parser.position < len(parser.chars) && parser.chars[parser.position] != '"' {
+			parser.position++
+		}
+
+		if parser.position >= len(parser.chars) {
+			parser.addError(quote, "unterminated phrase")
+			return "", true, false
+		}
+
+		value := strings.Join(strings.Fields(string(parser.chars[start:parser.position])), " ")
+		parser.position++
+
+		if len(value) == 0 {
+			parser.addError(quote, "empty phrase")
+			return "", true, false
+		}
+
+		return value, true, true
+	}
+
+	start := parser.position
+	for !parser.atValueEnd() && parser.peek() != '"' {
+		parser.position++
+	}
+
+	return string(parser.chars[start:parser.position]), false, true
+
+}
+
+func (parser *parser) parseYears(clause *Clause, valueStart int) bool {
+
+	from, to, isRange := clause.Value, clause.Value, false
+
+	if index := strings.Index(clause.Value, ".."); index >= 0 {
+		from, to, isRange = clause.Value[:index], clause.Value[index+2:], true
+	}
+
+	if isRange && len(from) == 0 && len(to) == 0 {
+		parser.addError(valueStart, "expected a year or a range like 1985..1995")
+		return false
+	}
+
+	if len(from) > 0 {
+
+		year, err := strconv.Atoi(from)
+
+		if err != nil {
+			parser.addError(valueStart, "invalid year '%s'", from)
+			return false
+		}
+
+		clause.YearFrom = &year
+	}
+
+	if len(to) > 0 {
+
+		year, err := strconv.Atoi(to)
+
+		if err != nil {
+			parser.addError(valueStart+len([]rune(clause.Value))-len([]rune(to)), "invalid year '%s'", to)
+			return false
+		}
+
+		clause.YearTo = &year
+	}
+
+	if clause.YearFrom != nil && clause.YearTo != nil && *clause.YearFrom > *clause.YearTo {
+		parser.addError(valueStart, "empty year range %s", clause.Value)
+		return false
+	}
+
+	return true
+
+}
+
+/*
+ToFilter compiles the query into a definition filter. The text clauses become a text search in the same syntax,
+repeated tags and authors match any of them and several year clauses narrow the range down.
+*/
+func (query *Query) ToFilter() *types.DefinitionFilter {
+
+	filter := types.DefinitionFilter{}
+	textParts := []string{}
+
+	appendTo := func(list **[]string, value string) {
+		if *list == nil {
+			*list = &[]string{}
+		}
+		**list = append(**list, value)
+	}
+
+	for _, clause := range query.Clauses {
+
+		switch clause.Field {
+
+		case FieldText:
+			part := clause.Value
+			if clause.Phrase {
+				part = "\"" + part + "\""
+			}
+			if clause.Negated {
+				part = "-" + part
+			}
+			textParts = append(textParts, part)
+
+		case FieldTag:
+			if clause.Negated {
+				appendTo(&filter.ExcludedTags, clause.Value)
+			} else {
+				appendTo(&filter.Tags, clause.Value)
+			}
+
+		case FieldAuthor:
+			if clause.Negated {
+				appendTo(&filter.ExcludedAuthorNames, clause.Value)
+			} else {
+				appendTo(&filter.AuthorNames, clause.Value)
+			}
+
+		case FieldYear:
+			if clause.YearFrom != nil && (filter.YearFrom == nil || *clause.YearFrom > *filter.YearFrom) {
+				filter.YearFrom = clause.YearFrom
+			}
+			if clause.YearTo != nil && (filter.YearTo == nil || *clause.YearTo < *filter.YearTo) {
+				filter.YearTo = clause.YearTo
+			}
+		}
+	}
+
+	if len(textParts) > 0 {
+		content := strings.Join(textParts, " ")
+		filter.Content = &content
+	}
+
+	return &filter
+
+}
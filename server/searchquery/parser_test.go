@@ -0,0 +1,138 @@
+package searchquery
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func intPointer(value int) *int {
+	return &value
+}
+
+func TestParse(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected []*Clause
+	}{
+		{"", []*Clause{}},
+		{"   ", []*Clause{}},
+		{"perception", []*Clause{
+			{Field: FieldText, Value: "perception", Start: 0, End: 10},
+		}},
+		{`"perceive,  reason"`, []*Clause{
+			{Field: FieldText, Value: "perceive, reason", Phrase: true, Start: 0, End: 19},
+		}},
+		{`tag:"machine learning" -tag:philosophy`, []*Clause{
+			{Field: FieldTag, Value: "machine learning", Phrase: true, Start: 0, End: 22},
+			{Field: FieldTag, Value: "philosophy", Negated: true, Start: 23, End: 38},
+		}},
+		{"Author:winston -author:orwell", []*Clause{
+			{Field: FieldAuthor, Value: "winston", Start: 0, End: 14},
+			{Field: FieldAuthor, Value: "orwell", Negated: true, Start: 15, End: 29},
+		}},
+		{"year:1990 year:1985..1995 year:1985.. year:..1995", []*Clause{
+			{Field: FieldYear, Value: "1990", YearFrom: intPointer(1990), YearTo: intPointer(1990), Start: 0, End: 9},
+			{Field: FieldYear, Value: "1985..1995", YearFrom: intPointer(1985), YearTo: intPointer(1995), Start: 10, End: 25},
+			{Field: FieldYear, Value: "1985..", YearFrom: intPointer(1985), Start: 26, End: 37},
+			{Field: FieldYear, Value: "..1995", YearTo: intPointer(1995), Start: 38, End: 49},
+		}},
+		{`-"machine learning"`, []*Clause{
+			{Field: FieldText, Value: "machine learning", Phrase: true, Negated: true, Start: 0, End: 19},
+		}},
+		{"künstliche intelligenz", []*Clause{
+			{Field: FieldText, Value: "künstliche", Start: 0, End: 10},
+			{Field: FieldText, Value: "intelligenz", Start: 11, End: 22},
+		}},
+		{"self-awareness 10:30", []*Clause{
+			{Field: FieldText, Value: "self-awareness", Start: 0, End: 14},
+			{Field: FieldText, Value: "10:30", Start: 15, End: 20},
+		}},
+	}
+
+	for _, test := range tests {
+
+		query, errors := Parse(test.input)
+
+		if !reflect.DeepEqual(query.Clauses, test.expected) || len(errors) > 0 {
+			actual, _ := json.Marshal(query.Clauses)
+			expected, _ := json.Marshal(test.expected)
+			t.Errorf("Parse(%q) = %s, %v, expected %s", test.input, actual, errors, expected)
+		}
+	}
+
+}
+
+func TestParseErrors(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected []SyntaxError
+	}{
+		{"-", []SyntaxError{{"expected a term after '-'", 0}}},
+		{"mind - body", []SyntaxError{{"expected a term after '-'", 5}}},
+		{"tag:", []SyntaxError{{"expected a value after 'tag:'", 4}}},
+		{"tag: mind", []SyntaxError{{"expected a value after 'tag:'", 4}}},
+		{"mind title:body", []SyntaxError{{"unknown field 'title', expected tag, author or year", 5}}},
+		{`tag:"machine learning`, []SyntaxError{{"unterminated phrase", 4}}},
+		{`mind ""`, []SyntaxError{{"empty phrase", 5}}},
+		{"-year:1990", []SyntaxError{{"years cannot be negated", 0}}},
+		{"year:..", []SyntaxError{{"expected a year or a range like 1985..1995", 5}}},
+		{"year:nineteen", []SyntaxError{{"invalid year 'nineteen'", 5}}},
+		{"year:1985..later", []SyntaxError{{"invalid year 'later'", 11}}},
+		{"year:1995..1985", []SyntaxError{{"empty year range 1995..1985", 5}}},
+		{`über tag: "x`, []SyntaxError{{"expected a value after 'tag:'", 9}, {"unterminated phrase", 10}}},
+	}
+
+	for _, test := range tests {
+
+		_, errors := Parse(test.input)
+
+		actual := []SyntaxError{}
+		for _, err := range errors {
+			actual = append(actual, *err)
+		}
+
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Parse(%q) errors = %v, expected %v", test.input, actual, test.expected)
+		}
+	}
+
+}
+
+func TestToFilter(t *testing.T) {
+
+	query, errors := Parse(`perception "artificial intelligence" -philosophy tag:ai -tag:ethics author:turing -author:searle year:1950..1990 year:1960..`)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	filter := query.ToFilter()
+
+	expected := map[string]interface{}{
+		"content":             `perception "artificial intelligence" -philosophy`,
+		"tags":                []interface{}{"ai"},
+		"excludedTags":        []interface{}{"ethics"},
+		"authorNames":         []interface{}{"turing"},
+		"excludedAuthorNames": []interface{}{"searle"},
+		"yearFrom":            float64(1960),
+		"yearTo":              float64(1990),
+	}
+
+	encoded, _ := json.Marshal(filter)
+	actual := map[string]interface{}{}
+	json.Unmarshal(encoded, &actual)
+
+	for key, value := range actual {
+		if value == nil {
+			delete(actual, key)
+		}
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("ToFilter() = %v, expected %v", actual, expected)
+	}
+
+}
@@ -5,6 +5,7 @@ import (
 	"yacoid_server/api"
 	"yacoid_server/common"
 	"yacoid_server/database"
+	"yacoid_server/mail"
 )
 
 // TODO: Filter, Sort
@@ -17,12 +18,40 @@ func main() {
 		panic(fmt.Sprintf("Failed to load env variables: %v\n", err))
 	}
 
+	err = database.LoadAccessTokenKeys()
+
+	if err != nil {
+		panic(fmt.Sprintf("Failed to configure access tokens: %v\n", err))
+	}
+
+	err = database.LoadSessionConfig()
+
+	if err != nil {
+		panic(fmt.Sprintf("Failed to configure sessions: %v\n", err))
+	}
+
+	err = api.LoadVersionConfig()
+
+	if err != nil {
+		panic(fmt.Sprintf("Failed to configure API versions: %v\n", err))
+	}
+
 	err = database.Connect()
 
 	if err != nil {
 		panic(fmt.Sprintf("Failed to connect to database: %v\n", err))
 	}
 
+	mailer, err := mail.NewMailerFromEnv()
+
+	if err != nil {
+		panic(fmt.Sprintf("Failed to configure mailer: %v\n", err))
+	}
+
+	database.StartMailOutbox(mailer)
+	database.StartNotificationDigests()
+	database.StartWebhookDeliveries()
+
 	api.StartAPI()
 
 }
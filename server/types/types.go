@@ -1,6 +1,8 @@
 package types
 
 import (
+	"fmt"
+	"strings"
 	"time"
 	"yacoid_server/common"
 
@@ -21,10 +23,12 @@ func (author *SubmitDefinitionRequest) Validate(validate *validator.Validate) []
 }
 
 type DefinitionPageRequest struct {
-	PageSize int               `json:"pageSize" validate:"required"`
+	PageSize int               `json:"pageSize" validate:"required,min=1,max=100"`
 	Page     int               `json:"page" validate:"required,min=1"`
 	Filter   *DefinitionFilter `json:"filter" validate:"omitempty,dive"`
 	Sort     *interface{}      `json:"sort"`
+	Fuzzy    bool              `json:"fuzzy"`
+	Query    *string           `json:"query" validate:"omitempty,excluded_with=Filter"`
 }
 
 func (DefinitionPageRequest *DefinitionPageRequest) Validate(validate *validator.Validate) []string {
@@ -44,7 +48,7 @@ type ChangeDefinitionRequest struct {
 	ID             string     `json:"id" validate:"required"`
 	Title          *string    `json:"title"`
 	Content        *string    `json:"content"`
-	Source         *Source    `json:"source" validate:"omitempty,dive"`
+	Source         *string    `json:"source"`
 	PublishingDate *time.Time `json:"publishingDate" validate:"omitempty"`
 	Tags           *[]string  `json:"tags"`
 }
@@ -54,47 +58,340 @@ func (rejection *ChangeDefinitionRequest) Validate(validate *validator.Validate)
 }
 
 type CreateAuthorRequest struct {
-	FirstName string `json:"firstName" validate:"required,min=1"`
-	LastName  string `json:"lastName" validate:"required,min=1"`
+	FirstName    string   `json:"firstName" validate:"required,min=1"`
+	LastName     string   `json:"lastName" validate:"required,min=1"`
+	ORCID        *string  `json:"orcid"`
+	WikidataID   *string  `json:"wikidataId"`
+	NameVariants []string `json:"nameVariants" validate:"omitempty,dive,min=1"`
+	BirthYear    *int     `json:"birthYear"`
+	DeathYear    *int     `json:"deathYear"`
 }
 
 func (rejection *CreateAuthorRequest) Validate(validate *validator.Validate) []string {
 	return common.ValidateStruct(rejection, validate)
 }
 
+type AuthorPageRequest struct {
+	PageSize int     `json:"pageSize" validate:"required,min=1,max=100"`
+	Page     int     `json:"page" validate:"required,min=1"`
+	Search   *string `json:"search"`
+}
+
+func (request *AuthorPageRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type UpdateAuthorRequest struct {
+	ID           string    `json:"id" validate:"required"`
+	FirstName    *string   `json:"firstName" validate:"omitempty,min=1"`
+	LastName     *string   `json:"lastName" validate:"omitempty,min=1"`
+	ORCID        *string   `json:"orcid"`
+	WikidataID   *string   `json:"wikidataId"`
+	NameVariants *[]string `json:"nameVariants" validate:"omitempty,dive,min=1"`
+	BirthYear    *int      `json:"birthYear"`
+	DeathYear    *int      `json:"deathYear"`
+}
+
+func (request *UpdateAuthorRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type MergeAuthorsRequest struct {
+	TargetID  string   `json:"targetId" validate:"required"`
+	AuthorIDs []string `json:"authorIds" validate:"required,min=1"`
+}
+
+func (request *MergeAuthorsRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
 type CreateSourceRequest struct {
 	Authors []string `bson:"authors" json:"authors" validate:"required,min=1"`
+	Title   string   `bson:"title" json:"title" validate:"required,min=1"`
+	Year    *int     `bson:"year" json:"year" validate:"omitempty"`
+	ISBN    *string  `bson:"isbn" json:"isbn" validate:"omitempty"`
+	DOI     *string  `bson:"doi" json:"doi" validate:"omitempty"`
 }
 
 func (rejection *CreateSourceRequest) Validate(validate *validator.Validate) []string {
 	return common.ValidateStruct(rejection, validate)
 }
 
+type SourcePageRequest struct {
+	PageSize int     `json:"pageSize" validate:"required,min=1,max=100"`
+	Page     int     `json:"page" validate:"required,min=1"`
+	Search   *string `json:"search"`
+	Year     *int    `json:"year"`
+}
+
+func (request *SourcePageRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type UpdateSourceRequest struct {
+	ID      string    `json:"id" validate:"required"`
+	Title   *string   `json:"title" validate:"omitempty,min=1"`
+	Year    *int      `json:"year"`
+	ISBN    *string   `json:"isbn"`
+	DOI     *string   `json:"doi"`
+	Authors *[]string `json:"authors" validate:"omitempty,min=1"`
+}
+
+func (request *UpdateSourceRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type MergeSourcesRequest struct {
+	TargetID  string   `json:"targetId" validate:"required"`
+	SourceIDs []string `json:"sourceIds" validate:"required,min=1"`
+}
+
+func (request *MergeSourcesRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query" validate:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func (request *GraphQLRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type SaveSearchRequest struct {
+	Name        string            `json:"name" validate:"required,min=1"`
+	Filter      *DefinitionFilter `json:"filter" validate:"required_without=Query,excluded_with=Query"`
+	Query       *string           `json:"query" validate:"required_without=Filter"`
+	NotifyInApp bool              `json:"notifyInApp"`
+	NotifyEmail bool              `json:"notifyEmail"`
+}
+
+func (request *SaveSearchRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type ReadNotificationsRequest struct {
+	IDs []string `json:"ids"`
+}
+
+func (request *ReadNotificationsRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" validate:"required,url"`
+	Events []string `json:"events" validate:"required,min=1,dive,required"`
+}
+
+func (request *CreateWebhookRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type UpdateWebhookRequest struct {
+	ID     string    `json:"id" validate:"required"`
+	URL    *string   `json:"url" validate:"omitempty,url"`
+	Events *[]string `json:"events" validate:"omitempty,min=1,dive,required"`
+	Active *bool     `json:"active"`
+}
+
+func (request *UpdateWebhookRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type AuditLogRequest struct {
+	PageSize   int        `json:"pageSize" validate:"required,min=1,max=100"`
+	Page       int        `json:"page" validate:"required,min=1"`
+	ActorID    *string    `json:"actorId"`
+	TargetID   *string    `json:"targetId"`
+	TargetType *string    `json:"targetType" validate:"omitempty,oneof=definition author source user"`
+	Action     *string    `json:"action"`
+	From       *time.Time `json:"from"`
+	To         *time.Time `json:"to"`
+}
+
+func (request *AuditLogRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type EmailPreferencesRequest struct {
+	OptOuts []string `json:"optOuts" validate:"dive,required"`
+}
+
+func (request *EmailPreferencesRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (request *LoginRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (request *ResetPasswordRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+func (request *RefreshTokenRequest) Validate(validate *validator.Validate) []string {
+	return common.ValidateStruct(request, validate)
+}
+
+type Rejection struct {
+	ID           primitive.ObjectID `bson:"_id" json:"-"`
+	RejectedBy   primitive.ObjectID `bson:"rejected_by" json:"rejectedBy" validate:"required"`
+	RejectedDate time.Time          `bson:"rejected_date" json:"rejectedDate" validate:"required"`
+	Content      string             `bson:"content" json:"content" validate:"required"`
+}
+
+/*
+IsRejected reports whether the latest rejection of the log happened after the last change of the submitter.
+*/
+func IsRejected(rejectionLog []*Rejection, lastSubmitChangeDate time.Time) bool {
+
+	var latestRejectionDate time.Time
+	for _, rejection := range rejectionLog {
+		if rejection.RejectedDate.After(latestRejectionDate) {
+			latestRejectionDate = rejection.RejectedDate
+		}
+	}
+
+	return !latestRejectionDate.IsZero() && latestRejectionDate.After(lastSubmitChangeDate)
+
+}
+
 type Author struct {
-	ID            primitive.ObjectID `bson:"_id" json:"-"`
-	SlugId        string             `bson:"slug_id" json:"slugId"`
-	SubmittedBy   primitive.ObjectID `bson:"submitted_by" json:"submittedBy"`
-	SubmittedDate time.Time          `bson:"submitted_date" json:"submittedDate"`
-	FirstName     string             `bson:"first_name" json:"firstName"`
-	LastName      string             `bson:"last_name" json:"lastName"`
+	ID                   primitive.ObjectID  `bson:"_id" json:"id"`
+	SlugId               string              `bson:"slug_id" json:"slugId"`
+	MergedSlugIds        []string            `bson:"merged_slug_ids,omitempty" json:"-"`
+	SubmittedBy          primitive.ObjectID  `bson:"submitted_by" json:"submittedBy"`
+	SubmittedDate        time.Time           `bson:"submitted_date" json:"submittedDate"`
+	LastSubmitChangeDate time.Time           `bson:"last_submit_change_date" json:"lastSubmitChangeDate"`
+	ApprovedBy           *primitive.ObjectID `bson:"approved_by" json:"approvedBy"`
+	ApprovedDate         *time.Time          `bson:"approved_date" json:"approvedDate"`
+	Approved             bool                `bson:"approved" json:"approved"`
+	RejectionLog         []*Rejection        `bson:"rejection_log" json:"-"`
+	FirstName            string              `bson:"first_name" json:"firstName"`
+	LastName             string              `bson:"last_name" json:"lastName"`
+	ORCID                *string             `bson:"orcid,omitempty" json:"orcid,omitempty"`
+	WikidataID           *string             `bson:"wikidata_id,omitempty" json:"wikidataId,omitempty"`
+	NameVariants         []string            `bson:"name_variants,omitempty" json:"nameVariants,omitempty"`
+	BirthYear            *int                `bson:"birth_year,omitempty" json:"birthYear,omitempty"`
+	DeathYear            *int                `bson:"death_year,omitempty" json:"deathYear,omitempty"`
+	SearchNames          []string            `bson:"search_names" json:"-"`
+}
+
+/*
+FullName returns the name in "first last" order.
+*/
+func (author *Author) FullName() string {
+	return strings.TrimSpace(author.FirstName + " " + author.LastName)
 }
 
 type Source struct {
-	ID            primitive.ObjectID   `bson:"_id" json:"-"`
-	SubmittedBy   primitive.ObjectID   `bson:"submitted_by" json:"submittedBy"`
-	SubmittedDate time.Time            `bson:"submitted_date" json:"submittedDate"`
-	Authors       []primitive.ObjectID `bson:"authors" json:"authors" validate:"required,min=1"`
+	ID                   primitive.ObjectID   `bson:"_id" json:"id"`
+	SubmittedBy          primitive.ObjectID   `bson:"submitted_by" json:"submittedBy"`
+	SubmittedDate        time.Time            `bson:"submitted_date" json:"submittedDate"`
+	LastSubmitChangeDate time.Time            `bson:"last_submit_change_date" json:"lastSubmitChangeDate"`
+	ApprovedBy           *primitive.ObjectID  `bson:"approved_by" json:"approvedBy"`
+	ApprovedDate         *time.Time           `bson:"approved_date" json:"approvedDate"`
+	Approved             bool                 `bson:"approved" json:"approved"`
+	RejectionLog         []*Rejection         `bson:"rejection_log" json:"-"`
+	Authors              []primitive.ObjectID `bson:"authors" json:"authors" validate:"required,min=1"`
+	Title                string               `bson:"title" json:"title"`
+	Year                 *int                 `bson:"year,omitempty" json:"year,omitempty"`
+	ISBN                 *string              `bson:"isbn,omitempty" json:"isbn,omitempty"`
+	DOI                  *string              `bson:"doi,omitempty" json:"doi,omitempty"`
 }
 
 func (author *Source) Validate(validate *validator.Validate) []string {
 	return common.ValidateStruct(author, validate)
 }
 
+/*
+PopulatedSource is a source with its author references resolved to the author records.
+*/
+type PopulatedSource struct {
+	ID            primitive.ObjectID `json:"id"`
+	SubmittedBy   primitive.ObjectID `json:"submittedBy"`
+	SubmittedDate time.Time          `json:"submittedDate"`
+	Approved      bool               `json:"approved"`
+	Authors       []*Author          `json:"authors"`
+	Title         string             `json:"title"`
+	Year          *int               `json:"year,omitempty"`
+	ISBN          *string            `json:"isbn,omitempty"`
+	DOI           *string            `json:"doi,omitempty"`
+}
+
+/*
+Citation formats the source like "Winston, Patrick Henry (1992). Artificial Intelligence. ISBN 0-201-53377-4. https://doi.org/...".
+*/
+func (source *PopulatedSource) Citation() string {
+
+	names := []string{}
+	for _, author := range source.Authors {
+		name := author.LastName
+		if len(author.FirstName) > 0 {
+			name += ", " + author.FirstName
+		}
+		names = append(names, name)
+	}
+
+	citation := strings.Join(names, "; ")
+
+	if source.Year != nil {
+		citation += fmt.Sprintf(" (%d)", *source.Year)
+	}
+
+	if len(citation) > 0 {
+		citation += ". "
+	}
+
+	citation += strings.TrimSuffix(source.Title, ".") + "."
+
+	if source.ISBN != nil {
+		citation += " ISBN " + *source.ISBN + "."
+	}
+
+	if source.DOI != nil {
+		citation += " https://doi.org/" + *source.DOI
+	}
+
+	return citation
+
+}
+
+/*
+PublicUser is the part of a user that may be shown to everyone.
+*/
+type PublicUser struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	FirstName string             `bson:"first_name" json:"firstName"`
+	LastName  string             `bson:"last_name" json:"lastName"`
+}
+
 type DefinitionFilter struct {
-	Title           *string      `json:"title" bson:"title" validate:"omitempty"`
-	Content         *string      `json:"content" bson:"content" validate:"omitempty"`
-	PublishingDates *[]time.Time `json:"publishing_dates" bson:"publishing_dates" validate:"omitempty,min=1"`
-	Authors         *[]*Author   `json:"authors" bson:"authors" validate:"omitempty,min=1,dive"`
-	Sources         *[]*Source   `json:"sources" bson:"sources" validate:"omitempty,min=1,dive"`
-	Tags            *[]string    `json:"tags" bson:"tags" validate:"omitempty,min=1"`
+	Title               *string      `json:"title" bson:"title" validate:"omitempty"`
+	Content             *string      `json:"content" bson:"content" validate:"omitempty"`
+	PublishingDates     *[]time.Time `json:"publishing_dates" bson:"publishing_dates" validate:"omitempty,min=1"`
+	Authors             *[]*Author   `json:"authors" bson:"authors" validate:"omitempty,min=1,dive"`
+	Sources             *[]*Source   `json:"sources" bson:"sources" validate:"omitempty,min=1,dive"`
+	Tags                *[]string    `json:"tags" bson:"tags" validate:"omitempty,min=1"`
+	ExcludedTags        *[]string    `json:"excludedTags" bson:"excluded_tags" validate:"omitempty,min=1"`
+	AuthorNames         *[]string    `json:"authorNames" bson:"author_names" validate:"omitempty,min=1,dive,min=1"`
+	ExcludedAuthorNames *[]string    `json:"excludedAuthorNames" bson:"excluded_author_names" validate:"omitempty,min=1,dive,min=1"`
+	YearFrom            *int         `json:"yearFrom" bson:"year_from"`
+	YearTo              *int         `json:"yearTo" bson:"year_to"`
 }